@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/dispatcher"
+	"github.com/kcloutie/knot/pkg/escalation"
+	"github.com/kcloutie/knot/pkg/heartbeat"
+	"github.com/kcloutie/knot/pkg/http"
+	"github.com/kcloutie/knot/pkg/logger"
+	"github.com/kcloutie/knot/pkg/ratelimit"
+	"github.com/kcloutie/knot/pkg/silence"
+	"go.uber.org/zap"
+)
+
+// AddAdminRoutes adds the admin api routes to the router group when the admin api is enabled
+func AddAdminRoutes(ctx context.Context, group *gin.RouterGroup) {
+	cfg := config.FromCtx(ctx)
+	if cfg.Admin == nil {
+		return
+	}
+	admin := group.Group("/admin")
+	admin.Use(AdminAuthMiddleware(ctx, cfg.Admin))
+
+	limiter := ratelimit.FromCtx(ctx)
+	if limiter != nil {
+		admin.GET("/ratelimit", func(c *gin.Context) {
+			c.JSON(200, RateLimitResponse{
+				Notifications: limiter.Stats(),
+			})
+		})
+	}
+
+	monitor := heartbeat.FromCtx(ctx)
+	if monitor != nil {
+		admin.GET("/heartbeats", func(c *gin.Context) {
+			c.JSON(200, HeartbeatResponse{
+				Heartbeats: monitor.Status(ctx),
+			})
+		})
+	}
+
+	scheduler := escalation.FromCtx(ctx)
+	if scheduler != nil {
+		admin.GET("/escalations", func(c *gin.Context) {
+			ListEscalations(ctx, c, scheduler)
+		})
+		admin.POST("/escalations/:id/ack", func(c *gin.Context) {
+			var log *zap.Logger
+			log, ctx := http.SetCommonLoggingAttributes(ctx, c)
+			acknowledge(ctx, c, log, scheduler, c.Param("id"), c.DefaultQuery("by", "admin"))
+		})
+	}
+
+	silences := silence.FromCtx(ctx)
+	if silences != nil {
+		admin.GET("/silences", func(c *gin.Context) {
+			ListSilences(ctx, c, silences)
+		})
+		admin.POST("/silences", func(c *gin.Context) {
+			AddSilence(ctx, c, silences)
+		})
+		admin.GET("/silences/:id", func(c *gin.Context) {
+			GetSilence(ctx, c, silences)
+		})
+		admin.DELETE("/silences/:id", func(c *gin.Context) {
+			ExpireSilence(ctx, c, silences)
+		})
+	}
+
+	store := deadletter.FromCtx(ctx)
+	if store != nil {
+		admin.GET("/dlq", func(c *gin.Context) {
+			ListDeadLetters(ctx, c, store)
+		})
+		admin.DELETE("/dlq", func(c *gin.Context) {
+			PurgeDeadLetters(ctx, c, store)
+		})
+		admin.POST("/dlq/replay", func(c *gin.Context) {
+			ReplayDeadLetters(ctx, c, store)
+		})
+		admin.GET("/dlq/:id", func(c *gin.Context) {
+			GetDeadLetter(ctx, c, store)
+		})
+		admin.DELETE("/dlq/:id", func(c *gin.Context) {
+			DeleteDeadLetter(ctx, c, store)
+		})
+		admin.POST("/dlq/:id/replay", func(c *gin.Context) {
+			c.Set("ids", []string{c.Param("id")})
+			ReplayDeadLetters(ctx, c, store)
+		})
+	}
+}
+
+// AdminAuthMiddleware rejects requests that do not contain valid credentials. The admin authentication is required by
+// the configuration
+func AdminAuthMiddleware(ctx context.Context, admin *config.AdminConfiguration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := admin.Authentication.Authenticate(ctx, logger.FromCtx(ctx), c.Request)
+		if err != nil {
+			errD := &http.ErrorDetail{
+				Type:     "authenticate-request",
+				Title:    "Authenticate Request",
+				Status:   401,
+				Detail:   err.Error(),
+				Instance: c.Request.URL.Path,
+			}
+			c.AbortWithStatusJSON(int(errD.Status), errD)
+			return
+		}
+		c.Next()
+	}
+}
+
+func ListDeadLetters(ctx context.Context, c *gin.Context, store deadletter.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	entries, err := store.List(ctx)
+	if err != nil {
+		respondDeadLetterError(c, log, 500, "List", err)
+		return
+	}
+	c.JSON(200, DeadLetterListResponse{
+		Entries: entries,
+	})
+}
+
+func GetDeadLetter(ctx context.Context, c *gin.Context, store deadletter.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	entry, err := store.Get(ctx, c.Param("id"))
+	if err != nil {
+		respondDeadLetterError(c, log, 404, "Get", err)
+		return
+	}
+	c.JSON(200, entry)
+}
+
+func DeleteDeadLetter(ctx context.Context, c *gin.Context, store deadletter.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	err := store.Delete(ctx, c.Param("id"))
+	if err != nil {
+		respondDeadLetterError(c, log, 500, "Delete", err)
+		return
+	}
+	c.Status(204)
+}
+
+func PurgeDeadLetters(ctx context.Context, c *gin.Context, store deadletter.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	entries, err := store.List(ctx)
+	if err != nil {
+		respondDeadLetterError(c, log, 500, "Purge", err)
+		return
+	}
+	for _, entry := range entries {
+		err = store.Delete(ctx, entry.Id)
+		if err != nil {
+			respondDeadLetterError(c, log, 500, "Purge", err)
+			return
+		}
+	}
+	c.Status(204)
+}
+
+// ReplayDeadLetters sends the selected entries again. The entries are selected with the ids of the request body or
+// every entry is replayed when all is true
+func ReplayDeadLetters(ctx context.Context, c *gin.Context, store deadletter.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	request := DeadLetterReplayRequest{}
+	if ids, exists := c.Get("ids"); exists {
+		request.Ids = ids.([]string)
+	} else {
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			respondDeadLetterError(c, log, 400, "Replay", fmt.Errorf("failed to unmarshal the replay request - %v", err))
+			return
+		}
+	}
+	if !request.All && len(request.Ids) == 0 {
+		respondDeadLetterError(c, log, 400, "Replay", fmt.Errorf("the replay request must contain the ids of the entries or all"))
+		return
+	}
+
+	entries := []deadletter.Entry{}
+	if request.All {
+		var err error
+		entries, err = store.List(ctx)
+		if err != nil {
+			respondDeadLetterError(c, log, 500, "Replay", err)
+			return
+		}
+	} else {
+		for _, id := range request.Ids {
+			entry, err := store.Get(ctx, id)
+			if err != nil {
+				respondDeadLetterError(c, log, 404, "Replay", err)
+				return
+			}
+			entries = append(entries, *entry)
+		}
+	}
+
+	response := DeadLetterReplayResponse{
+		Results: []DeadLetterReplayResult{},
+	}
+	failed := 0
+	for _, entry := range entries {
+		result := dispatcher.Replay(ctx, log, store, entry)
+		if result.Status == dispatcher.StatusFailed {
+			failed++
+		}
+		response.Results = append(response.Results, DeadLetterReplayResult{
+			Id:     entry.Id,
+			Result: result,
+		})
+	}
+	c.JSON(dispatcher.GetResponseStatus(dispatcher.ResponsePolicyMultiStatus, len(entries)-failed, failed), response)
+}
+
+func respondDeadLetterError(c *gin.Context, log *zap.Logger, status int, action string, err error) {
+	errD := &http.ErrorDetail{
+		Type:     "dead-letter-" + strings.ToLower(action),
+		Title:    "Dead Letter " + action,
+		Status:   int64(status),
+		Detail:   err.Error(),
+		Instance: c.Request.URL.Path,
+	}
+	log.Error(errD.Detail)
+	c.JSON(status, errD)
+}
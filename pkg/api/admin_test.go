@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/escalation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminDeadLetters(t *testing.T) {
+	message := "hello {{ .data.test }}"
+	token := "secret"
+	cfg := &config.ServerConfiguration{
+		DeadLetter: &config.DeadLetterConfiguration{
+			Directory: t.TempDir(),
+		},
+		Admin: &config.AdminConfiguration{
+			Authentication: &config.ListenerAuthentication{
+				Token: &config.PropertyAndValue{Value: &token},
+			},
+		},
+		Notifications: []config.Notification{
+			{
+				Name: "broken",
+				Type: "does-not-exist",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	request := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("POST", "/api/v1/pubsub", `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`)
+	assert.Equal(t, 500, w.Code)
+
+	w = request("GET", "/api/v1/admin/dlq", "")
+	assert.Equal(t, 401, w.Code)
+
+	w = request("GET", "/api/v1/admin/dlq?token=secret", "")
+	assert.Equal(t, 200, w.Code)
+	list := DeadLetterListResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Entries, 1)
+	assert.Equal(t, "broken", list.Entries[0].Notification)
+	assert.Equal(t, "pub/sub", list.Entries[0].Source)
+	id := list.Entries[0].Id
+
+	w = request("GET", "/api/v1/admin/dlq/"+id+"?token=secret", "")
+	assert.Equal(t, 200, w.Code)
+
+	w = request("POST", "/api/v1/admin/dlq/"+id+"/replay?token=secret", "")
+	assert.Equal(t, 500, w.Code)
+
+	w = request("POST", "/api/v1/admin/dlq/replay?token=secret", `{}`)
+	assert.Equal(t, 400, w.Code)
+
+	cfg.Notifications[0].Type = "log"
+	w = request("POST", "/api/v1/admin/dlq/replay?token=secret", `{"all":true}`)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"results":[{"id":"`+id+`","result":{"name":"broken","status":"sent","attempts":1}}]}`, w.Body.String())
+
+	w = request("GET", "/api/v1/admin/dlq/"+id+"?token=secret", "")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestAdminRateLimit(t *testing.T) {
+	message := "hello {{ .data.test }}"
+	cfg := &config.ServerConfiguration{
+		Admin: &config.AdminConfiguration{},
+		Notifications: []config.Notification{
+			{
+				Name:      "log",
+				Type:      "log",
+				RateLimit: &config.RateLimit{Limit: 1, Period: "1h"},
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	// the second message is over the limit, it is dropped but the request still succeeds
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/pubsub", strings.NewReader(`{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`))
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/admin/ratelimit", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"notifications":{"log":{"allowed":1,"dropped":1,"delayed":0,"suppressed":0,"summaries":0}}}`, w.Body.String())
+}
+
+func TestAdminSilences(t *testing.T) {
+	message := "hello {{ .data.test }}"
+	cfg := &config.ServerConfiguration{
+		Silences: &config.SilenceConfiguration{
+			File: filepath.Join(t.TempDir(), "silences.json"),
+		},
+		Admin: &config.AdminConfiguration{},
+		Notifications: []config.Notification{
+			{
+				Name: "log",
+				Type: "log",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	request := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("POST", "/api/v1/admin/silences", `{"matcher":"data.","duration":"1h"}`)
+	assert.Equal(t, 400, w.Code)
+
+	w = request("POST", "/api/v1/admin/silences", `{"matcher":"notification == 'log' && data.test == '123'","duration":"1h","createdBy":"me"}`)
+	assert.Equal(t, 201, w.Code)
+	created := SilenceResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "active", created.State)
+	assert.Equal(t, "me", created.CreatedBy)
+
+	w = request("POST", "/api/v1/pubsub", `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"results":[{"index":0,"id":"1","notifications":[{"name":"log","status":"silenced"}]}]}`, w.Body.String())
+
+	w = request("GET", "/api/v1/admin/silences", "")
+	assert.Equal(t, 200, w.Code)
+	list := SilenceListResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Silences, 1)
+
+	w = request("DELETE", "/api/v1/admin/silences/"+created.Id, "")
+	assert.Equal(t, 200, w.Code)
+
+	w = request("GET", "/api/v1/admin/silences/"+created.Id, "")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"expired"`)
+
+	w = request("POST", "/api/v1/pubsub", `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"sent"`)
+}
+
+func TestAdminHeartbeats(t *testing.T) {
+	message := "heartbeat {{ .data.heartbeat }} is late"
+	cfg := &config.ServerConfiguration{
+		Heartbeats: &config.HeartbeatConfiguration{
+			File: filepath.Join(t.TempDir(), "heartbeats.json"),
+			Monitors: []config.Heartbeat{
+				{Name: "nightly", Matcher: "data.test == '123'", Interval: "24h", Notification: "late"},
+				{Name: "weekly", Matcher: "data.test == 'weekly'", Interval: "168h", Notification: "late"},
+			},
+		},
+		Admin: &config.AdminConfiguration{},
+		Notifications: []config.Notification{
+			{
+				Name:                "late",
+				Type:                "log",
+				CelExpressionFilter: "false",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	request := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("POST", "/api/v1/pubsub", `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`)
+	assert.Equal(t, 200, w.Code)
+
+	w = request("GET", "/api/v1/admin/heartbeats", "")
+	assert.Equal(t, 200, w.Code)
+	response := HeartbeatResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Heartbeats, 2)
+	assert.Equal(t, "nightly", response.Heartbeats[0].Name)
+	assert.Equal(t, "ok", response.Heartbeats[0].Status)
+	assert.Equal(t, "1", response.Heartbeats[0].LastMessageId)
+	assert.Equal(t, "pending", response.Heartbeats[1].Status)
+}
+
+func TestEscalations(t *testing.T) {
+	message := "{{ .data.test }} - acknowledge {{ .escalation.ackUrl }}"
+	key := "signing-key"
+	cfg := &config.ServerConfiguration{
+		Escalation: &config.EscalationConfiguration{
+			SigningKey: &config.PropertyAndValue{Value: &key},
+			BaseUrl:    "https://knot.example.com",
+		},
+		EscalationPolicies: map[string]config.EscalationPolicy{
+			"critical": {Steps: []config.EscalationStep{{After: "1h", Notifications: []string{"level2"}}}},
+		},
+		Admin: &config.AdminConfiguration{},
+		Notifications: []config.Notification{
+			{
+				Name:       "level1",
+				Type:       "log",
+				Escalation: "critical",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+			{
+				Name:                "level2",
+				Type:                "log",
+				CelExpressionFilter: "false",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	request := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("POST", "/api/v1/pubsub", `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`)
+	assert.Equal(t, 200, w.Code)
+
+	w = request("GET", "/api/v1/admin/escalations", "")
+	assert.Equal(t, 200, w.Code)
+	list := EscalationListResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Escalations, 1)
+	assert.Equal(t, "pending", list.Escalations[0].Status)
+	id := list.Escalations[0].Id
+
+	ackUrl := escalation.AckUrl("", key, id, time.Now().Add(time.Hour))
+	w = request("GET", ackUrl+"x", "")
+	assert.Equal(t, 403, w.Code)
+
+	// opening the link only shows the confirmation page
+	w = request("GET", ackUrl+"&by=oncall", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<form method="post"`)
+	w = request("GET", "/api/v1/admin/escalations", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, "pending", list.Escalations[0].Status)
+
+	w = request("POST", ackUrl+"&by=oncall", "")
+	assert.Equal(t, 200, w.Code)
+	acknowledged := escalation.Escalation{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &acknowledged))
+	assert.Equal(t, "acknowledged", acknowledged.Status)
+	assert.Equal(t, "oncall", acknowledged.AcknowledgedBy)
+}
@@ -4,15 +4,28 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	cache "github.com/chenyahui/gin-cache"
 	"github.com/chenyahui/gin-cache/persist"
 	"github.com/gin-gonic/gin"
 	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/correlation"
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/dedupe"
+	"github.com/kcloutie/knot/pkg/digest"
+	"github.com/kcloutie/knot/pkg/dispatcher"
+	"github.com/kcloutie/knot/pkg/enrichment"
+	"github.com/kcloutie/knot/pkg/escalation"
+	"github.com/kcloutie/knot/pkg/heartbeat"
 	knothttp "github.com/kcloutie/knot/pkg/http"
 	"github.com/kcloutie/knot/pkg/listener"
 	"github.com/kcloutie/knot/pkg/logger"
+	"github.com/kcloutie/knot/pkg/ratelimit"
+	"github.com/kcloutie/knot/pkg/silence"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -29,13 +42,16 @@ func CreateRouter(ctx context.Context, cacheInSeconds int) *gin.Engine {
 		Home(ctx, c)
 	})
 
+	ctx = WithStores(ctx)
+
 	router.GET("/healthz", Health)
 	router.GET("/readyz", Health)
 
 	apiV1 := router.Group("/api/v1")
 	apiV1.Use()
 	{
-		for _, l := range listener.GetListeners() {
+		for _, l := range listener.GetListeners(ctx) {
+			l := l
 			err := l.Initialize(ctx)
 			if err != nil {
 				slog.Errorf("Failed to initialize listener %s. Error: %v", l.GetName(), err)
@@ -45,13 +61,97 @@ func CreateRouter(ctx context.Context, cacheInSeconds int) *gin.Engine {
 			apiV1.POST(fmt.Sprintf("/%s", l.GetApiPath()), func(c *gin.Context) {
 				ExecuteListener(ctx, c, l)
 			})
+			apiV1.POST(fmt.Sprintf("/%s/batch", l.GetApiPath()), func(c *gin.Context) {
+				ExecuteBatchListener(ctx, c, l)
+			})
 		}
+		AddEscalationRoutes(ctx, apiV1)
+		AddAdminRoutes(ctx, apiV1)
 	}
 	return router
 }
 
+// WithStores adds the stores (dead letter, silences, deduplication, correlation), the heartbeat monitor, the
+// escalation scheduler, the rate limiter, the digest aggregator, the enricher and the worker pool to the context when they are configured. Existing
+// stores are kept so the same stores can be shared by the router and the watchers
+func WithStores(ctx context.Context) context.Context {
+	cfg := config.FromCtx(ctx)
+	if cfg.DeadLetter != nil && deadletter.FromCtx(ctx) == nil {
+		store, err := deadletter.NewStore(cfg.DeadLetter)
+		if err != nil {
+			logger.FromCtx(ctx).Error(fmt.Sprintf("Failed to create the dead letter store. Error: %v", err))
+		} else {
+			ctx = deadletter.WithCtx(ctx, store)
+		}
+	}
+	if cfg.Silences != nil && silence.FromCtx(ctx) == nil {
+		store, err := silence.NewStore(cfg.Silences)
+		if err != nil {
+			logger.FromCtx(ctx).Error(fmt.Sprintf("Failed to create the silence store. Error: %v", err))
+		} else {
+			ctx = silence.WithCtx(ctx, store)
+		}
+	}
+	if cfg.Heartbeats != nil && heartbeat.FromCtx(ctx) == nil {
+		monitor, err := heartbeat.NewMonitor(cfg.Heartbeats)
+		if err != nil {
+			logger.FromCtx(ctx).Error(fmt.Sprintf("Failed to create the heartbeat monitor. Error: %v", err))
+		} else {
+			ctx = heartbeat.WithCtx(ctx, monitor)
+		}
+	}
+	if len(cfg.Enrichments) > 0 && enrichment.FromCtx(ctx) == nil {
+		ctx = enrichment.WithCtx(ctx, enrichment.NewEnricher(cfg.Enrichments))
+	}
+	if cfg.Deduplication != nil && dedupe.FromCtx(ctx) == nil {
+		store, err := dedupe.NewStore(cfg.Deduplication)
+		if err != nil {
+			logger.FromCtx(ctx).Error(fmt.Sprintf("Failed to create the deduplication store. Error: %v", err))
+		} else {
+			ctx = dedupe.WithCtx(ctx, store)
+		}
+	}
+	for _, not := range cfg.Notifications {
+		if not.RateLimit != nil && ratelimit.FromCtx(ctx) == nil {
+			ctx = ratelimit.WithCtx(ctx, ratelimit.NewLimiter())
+		}
+		if not.Digest != nil && digest.FromCtx(ctx) == nil {
+			ctx = digest.WithCtx(ctx, digest.NewAggregator())
+		}
+		if not.Escalation != "" && escalation.FromCtx(ctx) == nil {
+			ctx = escalation.WithCtx(ctx, escalation.NewScheduler())
+		}
+		if not.Correlation != nil && correlation.FromCtx(ctx) == nil {
+			store, err := correlation.NewStore(cfg.Correlation)
+			if err != nil {
+				logger.FromCtx(ctx).Error(fmt.Sprintf("Failed to create the correlation store. Error: %v", err))
+			} else {
+				ctx = correlation.WithCtx(ctx, store)
+			}
+		}
+	}
+	// the pool is added last so the workers dispatch with the stores
+	if cfg.Async != nil && cfg.Async.Enabled && dispatcher.FromCtx(ctx) == nil {
+		pool := dispatcher.NewWorkerPool(cfg.Async.GetWorkers(), cfg.Async.GetQueueSize())
+		ctx = dispatcher.WithCtx(ctx, pool)
+		pool.Start(ctx)
+		logger.FromCtx(ctx).Sugar().Infof("Asynchronous dispatching enabled with %v workers and a queue size of %v", cfg.Async.GetWorkers(), cfg.Async.GetQueueSize())
+	}
+	return ctx
+}
+
+// shutdownTimeout is how long the server waits for the requests in progress when it is stopped
+const shutdownTimeout = 30 * time.Second
+
+// Start serves the router until the process receives SIGINT or SIGTERM. The server then stops accepting requests,
+// waits for the requests in progress, sends the pending digests and waits for the queued messages
 func Start(ctx context.Context, router *gin.Engine, cfg *config.ServerConfiguration, listeningAddr string) error {
 	knothttp.TraceHeaderKey = cfg.TraceHeaderKey
+	ctx = WithStores(ctx)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	StartWatchers(ctx)
+	dispatcher.StartHeartbeats(ctx, logger.FromCtx(ctx))
 
 	server := &http.Server{
 		Addr:              listeningAddr,
@@ -59,12 +159,43 @@ func Start(ctx context.Context, router *gin.Engine, cfg *config.ServerConfigurat
 		Handler:           router,
 	}
 
-	err := server.ListenAndServe()
-	if err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
 		return err
+	case <-ctx.Done():
 	}
+	return Shutdown(ctx, server)
+}
 
-	return nil
+// Shutdown stops the server and the worker pool once the queued messages are dispatched, then sends the pending
+// digests and saves the heartbeats. The pool is stopped first so the queued messages collected in a digest are sent
+// with it
+func Shutdown(ctx context.Context, server *http.Server) error {
+	log := logger.FromCtx(ctx)
+	log.Info("Shutting down the server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to shut down the server gracefully. Error: %v", err))
+	}
+	if pool := dispatcher.FromCtx(ctx); pool != nil {
+		pool.Stop()
+	}
+	if aggregator := digest.FromCtx(ctx); aggregator != nil {
+		aggregator.Flush()
+	}
+	if monitor := heartbeat.FromCtx(ctx); monitor != nil {
+		flushErr := monitor.Flush()
+		if flushErr != nil {
+			log.Error(fmt.Sprintf("Failed to save the heartbeats. Error: %v", flushErr))
+		}
+	}
+	return err
 }
 
 func RequestIdMiddleware() gin.HandlerFunc {
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/digest"
+	"github.com/kcloutie/knot/pkg/dispatcher"
+	"github.com/kcloutie/knot/pkg/message"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestShutdown(t *testing.T) {
+	aggregator := digest.NewAggregator()
+	pool := dispatcher.NewWorkerPool(1, 1)
+	ctx := dispatcher.WithCtx(digest.WithCtx(context.Background(), aggregator), pool)
+	pool.Start(ctx)
+	flushed := []string{}
+	aggregator.Add("log|build", &message.NotificationData{ID: "1"}, time.Hour, 0, func(key string, messages []*message.NotificationData) {
+		flushed = append(flushed, key)
+	})
+
+	err := Shutdown(ctx, &http.Server{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"log|build"}, flushed)
+	assert.Equal(t, 0, aggregator.Pending("log|build"))
+	// the stopped pool does not accept new messages
+	assert.False(t, pool.SubmitAll([]dispatcher.Job{{}}))
+}
+
+type testSource struct{}
+
+func (testSource) GetName() string    { return "test" }
+func (testSource) GetApiPath() string { return "test" }
+
+func TestShutdown_QueuedMessagesInDigest(t *testing.T) {
+	// the digest notification fails so the sent digest is found in the dead letter store
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name:   "broken",
+				Type:   "does-not-exist",
+				Digest: &config.Digest{Window: "1h"},
+			},
+		},
+	}
+	store, err := deadletter.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	aggregator := digest.NewAggregator()
+	pool := dispatcher.NewWorkerPool(1, 10)
+	ctx := deadletter.WithCtx(digest.WithCtx(config.WithCtx(context.Background(), cfg), aggregator), store)
+	ctx = dispatcher.WithCtx(ctx, pool)
+	log := zaptest.NewLogger(t)
+	queued := pool.SubmitAll([]dispatcher.Job{
+		{DeliveryId: "1", Log: log, Source: testSource{}, Data: &message.NotificationData{ID: "1", Data: map[string]interface{}{}, Attributes: map[string]string{}}},
+		{DeliveryId: "1", Log: log, Source: testSource{}, Data: &message.NotificationData{ID: "2", Data: map[string]interface{}{}, Attributes: map[string]string{}}},
+	})
+	assert.True(t, queued)
+	pool.Start(ctx)
+
+	err = Shutdown(ctx, &http.Server{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, aggregator.Pending("broken"))
+	entries, err := store.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, float64(2), entries[0].Data.Data["count"])
+	}
+}
@@ -1 +1,83 @@
 package api
+
+import (
+	"time"
+
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/dispatcher"
+	"github.com/kcloutie/knot/pkg/escalation"
+	"github.com/kcloutie/knot/pkg/heartbeat"
+	"github.com/kcloutie/knot/pkg/http"
+	"github.com/kcloutie/knot/pkg/ratelimit"
+	"github.com/kcloutie/knot/pkg/silence"
+)
+
+type AsyncResponse struct {
+	DeliveryId string `json:"deliveryId" yaml:"deliveryId"`
+	// Errors are the messages of a batch that could not be parsed and were not queued
+	Errors []MessageResult `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// DispatchResponse contains the outcome of every message received in a request
+type DispatchResponse struct {
+	Results []MessageResult `json:"results" yaml:"results"`
+}
+
+// MessageResult is the outcome of a single message. Error is set when the message could not be parsed, otherwise the
+// result of each matching notification is listed
+type MessageResult struct {
+	Index         int                             `json:"index" yaml:"index"`
+	ID            string                          `json:"id,omitempty" yaml:"id,omitempty"`
+	Notifications []dispatcher.NotificationResult `json:"notifications,omitempty" yaml:"notifications,omitempty"`
+	Error         *http.ErrorDetail               `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type DeadLetterListResponse struct {
+	Entries []deadletter.Entry `json:"entries" yaml:"entries"`
+}
+
+type DeadLetterReplayRequest struct {
+	Ids []string `json:"ids,omitempty" yaml:"ids,omitempty"`
+	All bool     `json:"all,omitempty" yaml:"all,omitempty"`
+}
+
+type DeadLetterReplayResponse struct {
+	Results []DeadLetterReplayResult `json:"results" yaml:"results"`
+}
+
+type DeadLetterReplayResult struct {
+	Id     string                        `json:"id" yaml:"id"`
+	Result dispatcher.NotificationResult `json:"result" yaml:"result"`
+}
+
+type RateLimitResponse struct {
+	Notifications map[string]ratelimit.Counters `json:"notifications" yaml:"notifications"`
+}
+
+type EscalationListResponse struct {
+	Escalations []escalation.Escalation `json:"escalations" yaml:"escalations"`
+}
+
+type HeartbeatResponse struct {
+	Heartbeats []heartbeat.Status `json:"heartbeats" yaml:"heartbeats"`
+}
+
+type SilenceListResponse struct {
+	Silences []SilenceResponse `json:"silences" yaml:"silences"`
+}
+
+type SilenceResponse struct {
+	silence.Silence `yaml:",inline"`
+	State           string `json:"state" yaml:"state"`
+}
+
+// SilenceRequest creates a silence. The silence starts now when startsAt is not specified and ends after the duration
+// when endsAt is not specified
+type SilenceRequest struct {
+	Matcher   string    `json:"matcher" yaml:"matcher"`
+	StartsAt  time.Time `json:"startsAt,omitempty" yaml:"startsAt,omitempty"`
+	EndsAt    time.Time `json:"endsAt,omitempty" yaml:"endsAt,omitempty"`
+	Duration  string    `json:"duration,omitempty" yaml:"duration,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty" yaml:"createdBy,omitempty"`
+	Comment   string    `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
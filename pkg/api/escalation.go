@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kcloutie/knot/pkg/clock"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/escalation"
+	"github.com/kcloutie/knot/pkg/http"
+	"go.uber.org/zap"
+)
+
+var ackPageTemplate = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <title>Acknowledge escalation</title>
+</head>
+<body>
+  <h1>Acknowledge escalation</h1>
+  <p>Acknowledging escalation {{ .Id }} cancels its remaining steps.</p>
+  <form method="post" action="{{ .Action }}">
+    <button type="submit">Acknowledge</button>
+  </form>
+</body>
+</html>
+`))
+
+// AddEscalationRoutes adds the acknowledgement routes of the escalations. The routes are protected by the signature of
+// the acknowledgement links so they can be embedded in the notifications. Opening the link only shows a confirmation
+// page, the escalation is acknowledged when the page is submitted, so link previews and mail scanners fetching the
+// link do not acknowledge it
+func AddEscalationRoutes(ctx context.Context, group *gin.RouterGroup) {
+	scheduler := escalation.FromCtx(ctx)
+	if scheduler == nil {
+		return
+	}
+	group.GET("/escalations/:id/ack", func(c *gin.Context) {
+		ConfirmEscalation(ctx, c)
+	})
+	group.POST("/escalations/:id/ack", func(c *gin.Context) {
+		AcknowledgeEscalation(ctx, c, scheduler)
+	})
+}
+
+// ConfirmEscalation shows the page confirming the acknowledgement of the escalation of a signed acknowledgement link.
+// The escalation is not acknowledged
+func ConfirmEscalation(ctx context.Context, c *gin.Context) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	if !verifyAckLink(ctx, c, log, "Confirm") {
+		return
+	}
+	c.Status(200)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	err := ackPageTemplate.Execute(c.Writer, map[string]string{
+		"Id":     c.Param("id"),
+		"Action": c.Request.URL.RequestURI(),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to render the escalation acknowledgement page - %v", err))
+	}
+}
+
+// AcknowledgeEscalation acknowledges the escalation with a signed acknowledgement link, cancelling its remaining steps.
+// The optional by query parameter records who acknowledged it
+func AcknowledgeEscalation(ctx context.Context, c *gin.Context, scheduler *escalation.Scheduler) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	if !verifyAckLink(ctx, c, log, "Acknowledge") {
+		return
+	}
+	acknowledge(ctx, c, log, scheduler, c.Param("id"), c.DefaultQuery("by", "link"))
+}
+
+// verifyAckLink verifies the signature of the acknowledgement link, responding with an error when it is not valid
+func verifyAckLink(ctx context.Context, c *gin.Context, log *zap.Logger, action string) bool {
+	cfg := config.FromCtx(ctx).Escalation
+	if cfg == nil || cfg.SigningKey == nil {
+		respondEscalationError(c, log, 500, action, fmt.Errorf("the escalation signing key is not configured"))
+		return false
+	}
+	key, err := cfg.SigningKey.GetValue(ctx, log, nil)
+	if err != nil {
+		respondEscalationError(c, log, 500, action, fmt.Errorf("failed to get the escalation signing key - %v", err))
+		return false
+	}
+	err = escalation.Verify(key, c.Param("id"), c.Query("expires"), c.Query("signature"), clock.Now(ctx))
+	if err != nil {
+		respondEscalationError(c, log, 403, action, err)
+		return false
+	}
+	return true
+}
+
+func ListEscalations(ctx context.Context, c *gin.Context, scheduler *escalation.Scheduler) {
+	c.JSON(200, EscalationListResponse{
+		Escalations: scheduler.List(),
+	})
+}
+
+func acknowledge(ctx context.Context, c *gin.Context, log *zap.Logger, scheduler *escalation.Scheduler, id string, by string) {
+	esc, err := scheduler.Acknowledge(id, by)
+	if err != nil {
+		respondEscalationError(c, log, 404, "Acknowledge", err)
+		return
+	}
+	log.Info(fmt.Sprintf("escalation '%s' acknowledged by '%s'", id, by))
+	c.JSON(200, esc)
+}
+
+func respondEscalationError(c *gin.Context, log *zap.Logger, status int, action string, err error) {
+	errD := &http.ErrorDetail{
+		Type:     "escalation-" + strings.ToLower(action),
+		Title:    "Escalation " + action,
+		Status:   int64(status),
+		Detail:   err.Error(),
+		Instance: c.Request.URL.Path,
+	}
+	log.Error(errD.Detail)
+	c.JSON(status, errD)
+}
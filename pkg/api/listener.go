@@ -2,110 +2,259 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/kcloutie/knot/pkg/adapter"
+	"github.com/kcloutie/knot/pkg/clock"
 	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/dispatcher"
 	"github.com/kcloutie/knot/pkg/http"
 	"github.com/kcloutie/knot/pkg/listener"
-	"github.com/kcloutie/knot/pkg/matcher"
+	"github.com/kcloutie/knot/pkg/message"
+	uuid "github.com/satori/go.uuid"
 
 	"go.uber.org/zap"
 )
 
 func ExecuteListener(ctx context.Context, c *gin.Context, listener listener.ListenerInterface) {
-	cfg := config.FromCtx(ctx)
 	var log *zap.Logger
 	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
-	slog := log.Sugar()
-	if c.Request.Body == nil {
-		errorMes := "request body was empty, request cannot be processed"
+	receivedTime := clock.Now(ctx)
+	payload, errD := readRequest(ctx, log, c, listener)
+	if errD != nil {
+		c.JSON(int(errD.Status), errD)
+		return
+	}
+	notifications, errD := parseRequest(ctx, log, c, listener, payload)
+	if errD != nil {
+		log.Error(errD.Detail)
+		c.JSON(int(errD.Status), errD)
+		return
+	}
+	setMeta(ctx, c, listener, receivedTime, notifications...)
+
+	pool := dispatcher.FromCtx(ctx)
+	if pool != nil {
+		enqueue(c, log, listener, pool, notifications, nil)
+		return
+	}
+
+	response := DispatchResponse{
+		Results: []MessageResult{},
+	}
+	sent := 0
+	failed := 0
+	for i, notifyData := range notifications {
+		results := dispatcher.Dispatch(ctx, log, listener, notifyData)
+		s, f := dispatcher.CountResults(results)
+		sent += s
+		failed += f
+		response.Results = append(response.Results, MessageResult{
+			Index:         i,
+			ID:            notifyData.ID,
+			Notifications: results,
+		})
+	}
+	policy := config.FromCtx(ctx).GetResponsePolicy(dispatcher.ResponsePolicyAllSucceeded)
+	c.JSON(dispatcher.GetResponseStatus(policy, sent, failed), response)
+}
+
+// enqueue queues the messages to be dispatched by the worker pool and responds with a 202, the delivery id and the
+// errors of the messages that could not be parsed. When the queue is full, a 503 is returned so the sender can retry
+// later. A 413 is returned when there are more messages than the queue can hold as retrying would never succeed
+func enqueue(c *gin.Context, log *zap.Logger, l listener.ListenerInterface, pool *dispatcher.WorkerPool, notifications []*message.NotificationData, errors []MessageResult) {
+	deliveryId := uuid.NewV4().String()
+	jobs := []dispatcher.Job{}
+	for _, notifyData := range notifications {
+		jobs = append(jobs, dispatcher.Job{
+			DeliveryId: deliveryId,
+			Log:        log,
+			Source:     l,
+			Data:       notifyData,
+		})
+	}
+	if len(jobs) > pool.Capacity() {
 		errD := &http.ErrorDetail{
-			Type:     listener.GetName() + "-get-request-body",
-			Title:    listener.GetName() + " Get Request Body",
-			Status:   400,
-			Detail:   errorMes,
-			Instance: listener.GetApiPath(),
+			Type:     l.GetName() + "-too-many-messages",
+			Title:    l.GetName() + " Too Many Messages",
+			Status:   413,
+			Detail:   fmt.Sprintf("the request contains %v messages, the dispatch queue can only hold %v", len(jobs), pool.Capacity()),
+			Instance: l.GetApiPath(),
 		}
-		log.Error(errorMes)
+		log.Error(errD.Detail)
 		c.JSON(int(errD.Status), errD)
 		return
 	}
-	payload, err := io.ReadAll(c.Request.Body)
-	if err != nil {
+	if !pool.SubmitAll(jobs) {
 		errD := &http.ErrorDetail{
-			Type:     listener.GetName() + "-get-request-body",
-			Title:    listener.GetName() + " Get Request Body",
-			Status:   400,
-			Detail:   err.Error(),
-			Instance: listener.GetApiPath(),
+			Type:     l.GetName() + "-queue-full",
+			Title:    l.GetName() + " Queue Full",
+			Status:   503,
+			Detail:   "the dispatch queue is full, the request cannot be processed at this time",
+			Instance: l.GetApiPath(),
 		}
-		log.Error(err.Error())
+		log.Warn(errD.Detail)
+		c.Header("Retry-After", "1")
 		c.JSON(int(errD.Status), errD)
 		return
 	}
-	notifyData, errD := listener.ParsePayload(ctx, log, payload)
+	log.Debug("message queued", zap.String("deliveryId", deliveryId))
+	c.JSON(202, AsyncResponse{
+		DeliveryId: deliveryId,
+		Errors:     errors,
+	})
+}
+
+// ExecuteBatchListener processes a JSON array of payloads. Each payload is parsed as if it was the body of the request,
+// so the listeners reading the headers or query parameters apply them to every payload, and dispatched independently.
+// The result of each message is returned with the index of its payload. A payload that cannot be parsed counts as a
+// failure when applying the response policy, which defaults to multiStatus for batches. When asynchronous dispatching
+// is enabled, the parsed payloads are queued and the errors of the other payloads are returned with the delivery id. A
+// 400 is returned with the errors when none of the payloads can be parsed
+func ExecuteBatchListener(ctx context.Context, c *gin.Context, l listener.ListenerInterface) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	receivedTime := clock.Now(ctx)
+	payload, errD := readRequest(ctx, log, c, l)
 	if errD != nil {
-		log.Error(errD.Detail)
 		c.JSON(int(errD.Status), errD)
 		return
 	}
 
-	providerFunctions := adapter.GetProviders()
-
-	if len(cfg.Notifications) == 0 {
-		slog.Warnf("no notifications configured for listener '%s'", listener.GetName())
+	items := []json.RawMessage{}
+	err := json.Unmarshal(payload, &items)
+	if err != nil {
+		errD := &http.ErrorDetail{
+			Type:     l.GetName() + "-unmarshal-batch",
+			Title:    l.GetName() + " Unmarshal Batch",
+			Status:   400,
+			Detail:   fmt.Sprintf("Failed to unmarshal body to a JSON array. Error: %v", err),
+			Instance: l.GetApiPath() + "/batch",
+		}
+		log.Error(errD.Detail)
+		c.JSON(int(errD.Status), errD)
+		return
 	}
 
-	for _, not := range cfg.Notifications {
-		matches, err := matcher.Matches(ctx, not, notifyData)
-		if err != nil {
-			errD := &http.ErrorDetail{
-				Type:     listener.GetName() + "-listener-message",
-				Title:    listener.GetName() + "Match Message",
-				Status:   400,
-				Detail:   err.Error(),
-				Instance: listener.GetApiPath(),
+	if pool := dispatcher.FromCtx(ctx); pool != nil {
+		notifications := []*message.NotificationData{}
+		errors := []MessageResult{}
+		for i, item := range items {
+			parsed, errD := parseRequest(ctx, log.With(zap.Int("batchIndex", i)), c, l, item)
+			if errD != nil {
+				errors = append(errors, MessageResult{Index: i, Error: errD})
+				continue
 			}
-			log.Error(err.Error())
-			c.JSON(int(errD.Status), errD)
+			setMeta(ctx, c, l, receivedTime, parsed...)
+			notifications = append(notifications, parsed...)
+		}
+		if len(notifications) == 0 && len(errors) > 0 {
+			// nothing is queued when none of the payloads can be parsed
+			log.Error("none of the payloads of the batch could be parsed")
+			c.JSON(400, DispatchResponse{Results: errors})
 			return
 		}
-		if !matches {
-			slog.Debugf("notification '%s' does not match message", not.Name)
+		enqueue(c, log, l, pool, notifications, errors)
+		return
+	}
+
+	response := DispatchResponse{
+		Results: []MessageResult{},
+	}
+	sent := 0
+	failed := 0
+	for i, item := range items {
+		itemLog := log.With(zap.Int("batchIndex", i))
+		notifications, errD := parseRequest(ctx, itemLog, c, l, item)
+		if errD != nil {
+			failed++
+			response.Results = append(response.Results, MessageResult{Index: i, Error: errD})
 			continue
 		}
+		setMeta(ctx, c, l, receivedTime, notifications...)
+		for _, notifyData := range notifications {
+			results := dispatcher.Dispatch(ctx, itemLog, l, notifyData)
+			s, f := dispatcher.CountResults(results)
+			sent += s
+			failed += f
+			response.Results = append(response.Results, MessageResult{
+				Index:         i,
+				ID:            notifyData.ID,
+				Notifications: results,
+			})
+		}
+	}
+	policy := config.FromCtx(ctx).GetResponsePolicy(dispatcher.ResponsePolicyMultiStatus)
+	c.JSON(dispatcher.GetResponseStatus(policy, sent, failed), response)
+}
 
-		proNewFunc, exists := providerFunctions[not.Type]
-		if !exists {
-			errD := &http.ErrorDetail{
-				Type:     listener.GetName() + "-exists",
-				Title:    listener.GetName() + " Exists",
-				Status:   400,
-				Detail:   fmt.Sprintf("notification type of '%s' does not exist. Check the notification type of the '%s' notification", not.Type, not.Name),
-				Instance: listener.GetApiPath(),
-			}
-			log.Error(err.Error())
-			c.JSON(int(errD.Status), errD)
-			return
+// setMeta populates the meta of the messages received by the listener with the request. The publish time set by the
+// listener is kept
+func setMeta(ctx context.Context, c *gin.Context, l listener.ListenerInterface, receivedTime time.Time, notifications ...*message.NotificationData) {
+	headers := map[string]string{}
+	if metaCfg := config.FromCtx(ctx).Meta; metaCfg != nil {
+		for _, name := range metaCfg.Headers {
+			headers[name] = c.GetHeader(name)
 		}
-		provider := proNewFunc(log, not)
-		slog.Debugf("sending notification '%s' to provider '%s'", not.Name, provider.GetName())
-		err = provider.SendNotification(ctx, notifyData)
-		if err != nil {
-			errD := &http.ErrorDetail{
-				Type:     listener.GetName() + "-" + provider.GetName() + "-send-notification",
-				Title:    listener.GetName() + "-" + provider.GetName() + " Send Notification",
-				Status:   400,
-				Detail:   err.Error(),
-				Instance: listener.GetApiPath(),
-			}
-			log.Error(err.Error())
-			c.JSON(int(errD.Status), errD)
-			return
+	}
+	for _, notifyData := range notifications {
+		meta := notifyData.GetMeta()
+		meta.Listener = l.GetName()
+		meta.Path = c.Request.URL.Path
+		meta.ReceivedTime = receivedTime
+		meta.RequestId = c.GetString(http.RequestHeaderKey)
+		meta.TraceId = c.GetString(http.TraceHeaderKey)
+		meta.Headers = headers
+		meta.SourceIp = c.ClientIP()
+		notifyData.Meta = &meta
+	}
+}
+
+func readRequest(ctx context.Context, log *zap.Logger, c *gin.Context, l listener.ListenerInterface) ([]byte, *http.ErrorDetail) {
+	if authenticator, ok := l.(listener.Authenticator); ok {
+		errD := authenticator.Authenticate(ctx, log, c.Request)
+		if errD != nil {
+			log.Error(errD.Detail)
+			return nil, errD
 		}
-		slog.Debugf("notification '%s' sent to provider '%s'", not.Name, provider.GetName())
 	}
+	if c.Request.Body == nil {
+		errorMes := "request body was empty, request cannot be processed"
+		errD := &http.ErrorDetail{
+			Type:     l.GetName() + "-get-request-body",
+			Title:    l.GetName() + " Get Request Body",
+			Status:   400,
+			Detail:   errorMes,
+			Instance: l.GetApiPath(),
+		}
+		log.Error(errorMes)
+		return nil, errD
+	}
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		errD := &http.ErrorDetail{
+			Type:     l.GetName() + "-get-request-body",
+			Title:    l.GetName() + " Get Request Body",
+			Status:   400,
+			Detail:   err.Error(),
+			Instance: l.GetApiPath(),
+		}
+		log.Error(err.Error())
+		return nil, errD
+	}
+	return payload, nil
+}
+
+func parseRequest(ctx context.Context, log *zap.Logger, c *gin.Context, l listener.ListenerInterface, payload []byte) ([]*message.NotificationData, *http.ErrorDetail) {
+	if requestListener, ok := l.(listener.RequestListenerInterface); ok {
+		return requestListener.ParseRequest(ctx, log, c.Request, payload)
+	}
+	notifyData, errD := l.ParsePayload(ctx, log, payload)
+	if errD != nil {
+		return nil, errD
+	}
+	return []*message.NotificationData{notifyData}, nil
 }
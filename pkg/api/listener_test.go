@@ -2,12 +2,15 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/kcloutie/knot/pkg/config"
 	"github.com/kcloutie/knot/pkg/params/settings"
 	"github.com/stretchr/testify/assert"
 )
@@ -62,3 +65,414 @@ func TestPubSubListener(t *testing.T) {
 		})
 	}
 }
+
+func TestCloudEventsListener(t *testing.T) {
+	ctx := context.Background()
+	router := CreateRouter(ctx, 1)
+
+	type args struct {
+		headers map[string]string
+		body    string
+	}
+	tests := []struct {
+		name     string
+		args     args
+		wantCode int
+		wantBody string
+	}{
+		{
+			name: "binary mode",
+			args: args{
+				headers: map[string]string{
+					"Content-Type":   "application/json",
+					"Ce-Specversion": "1.0",
+					"Ce-Id":          "1",
+					"Ce-Source":      "source",
+					"Ce-Type":        "type",
+				},
+				body: `{"test":"123"}`,
+			},
+			wantCode: 200,
+			wantBody: `{"results":[{"index":0,"id":"1"}]}`,
+		},
+		{
+			name: "structured mode missing attributes",
+			args: args{
+				headers: map[string]string{
+					"Content-Type": "application/cloudevents+json",
+				},
+				body: `{"specversion":"1.0","id":"1"}`,
+			},
+			wantCode: 400,
+			wantBody: "{\"type\":\"convert-cloudevent\",\"title\":\"Convert Cloud Event\",\"status\":400,\"detail\":\"the cloud event is missing the following required attributes: source, type\",\"instance\":\"cloudevents\"}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/cloudevents", strings.NewReader(tt.args.body))
+			for key, val := range tt.args.headers {
+				req.Header.Set(key, val)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestWebhookListener(t *testing.T) {
+	message := "hello {{ .data.test }}"
+	cfg := &config.ServerConfiguration{
+		Webhooks: []config.WebhookEndpoint{
+			{
+				Name:          "test",
+				IdExpression:  "data.test",
+				Notifications: []string{"allowed"},
+			},
+		},
+		Notifications: []config.Notification{
+			{
+				// matches every message and fails when it is sent
+				Name: "not-allowed",
+				Type: "does-not-exist",
+			},
+			{
+				Name: "allowed",
+				Type: "log",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "restricted notifications are skipped",
+			url:      "/api/v1/hooks/test",
+			body:     `{"test":"123"}`,
+			wantCode: 200,
+			wantBody: `{"results":[{"index":0,"id":"123","notifications":[{"name":"allowed","status":"sent","attempts":1}]}]}`,
+		},
+		{
+			name:     "bad body",
+			url:      "/api/v1/hooks/test",
+			body:     `dude`,
+			wantCode: 400,
+			wantBody: "{\"type\":\"unmarshal-body-data\",\"title\":\"Unmarshal Body Data\",\"status\":400,\"detail\":\"Failed to unmarshal the webhook body. Error: invalid character 'd' looking for beginning of value\",\"instance\":\"hooks/test\"}",
+		},
+		{
+			name:     "unknown webhook",
+			url:      "/api/v1/hooks/unknown",
+			body:     `{"test":"123"}`,
+			wantCode: 404,
+			wantBody: "404 page not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", tt.url, strings.NewReader(tt.body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestListenerMeta(t *testing.T) {
+	message := `{{ .meta.listener }} {{ .meta.notification }} {{ index .meta.headers "X-Source" }}`
+	cfg := &config.ServerConfiguration{
+		Meta: &config.MetaConfiguration{
+			Headers: []string{"X-Source"},
+		},
+		Webhooks: []config.WebhookEndpoint{
+			{Name: "test", IdExpression: "data.id"},
+		},
+		Notifications: []config.Notification{
+			{
+				Name:                "log",
+				Type:                "log",
+				CelExpressionFilter: `meta.listener == "webhook/test" && meta.path == "/api/v1/hooks/test" && meta.notification == "log" && meta.requestId != "" && meta.receivedTime != "" && meta.headers["X-Source"] == "ci"`,
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		wantBody string
+	}{
+		{
+			name:     "selected header",
+			headers:  map[string]string{"X-Source": "ci", "X-Other": "other"},
+			wantBody: `{"results":[{"index":0,"id":"1","notifications":[{"name":"log","status":"sent","attempts":1}]}]}`,
+		},
+		{
+			name:     "missing header",
+			headers:  map[string]string{"X-Other": "ci"},
+			wantBody: `{"results":[{"index":0,"id":"1"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/hooks/test", strings.NewReader(`{"id":"1"}`))
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestBatchListener(t *testing.T) {
+	message := "hello {{ .data.test }}"
+	token := "secret"
+	cfg := &config.ServerConfiguration{
+		Grafana: &config.AlertListenerConfiguration{
+			Authentication: &config.ListenerAuthentication{
+				Token: &config.PropertyAndValue{Value: &token},
+			},
+		},
+		Webhooks: []config.WebhookEndpoint{
+			{
+				Name:         "test",
+				IdExpression: `attributes.env + "-" + data.test`,
+				QueryParams:  []string{"env"},
+			},
+		},
+		Notifications: []config.Notification{
+			{
+				Name:                "log",
+				Type:                "log",
+				CelExpressionFilter: "has(data.test)",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &message},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "all items succeed",
+			url:      "/api/v1/pubsub/batch",
+			body:     `[{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"},{"data":"eyJvdGhlciI6IjEyMyJ9","ID":"2"}]`,
+			wantCode: 200,
+			wantBody: `{"results":[{"index":0,"id":"1","notifications":[{"name":"log","status":"sent","attempts":1}]},{"index":1,"id":"2"}]}`,
+		},
+		{
+			name:     "some items fail",
+			url:      "/api/v1/pubsub/batch",
+			body:     `[{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"},{}]`,
+			wantCode: 207,
+			wantBody: `{"results":[{"index":0,"id":"1","notifications":[{"name":"log","status":"sent","attempts":1}]},{"index":1,"error":{"type":"convert-pubsub-message","title":"Convert Pub/Sub Message","status":400,"detail":"failed to unmarshal the pub/sub data property of the message - unexpected end of JSON input","instance":"pubsub"}}]}`,
+		},
+		{
+			name:     "not an array",
+			url:      "/api/v1/pubsub/batch",
+			body:     `dude`,
+			wantCode: 400,
+			wantBody: `{"type":"pub/sub-unmarshal-batch","title":"pub/sub Unmarshal Batch","status":400,"detail":"Failed to unmarshal body to a JSON array. Error: invalid character 'd' looking for beginning of value","instance":"pubsub/batch"}`,
+		},
+		{
+			name:     "batch requests are authenticated",
+			url:      "/api/v1/grafana/batch",
+			body:     `[{"status":"firing"}]`,
+			wantCode: 401,
+			wantBody: `{"type":"authenticate-request","title":"Authenticate Request","status":401,"detail":"the request did not contain valid credentials","instance":"grafana"}`,
+		},
+		{
+			name:     "authenticated batch request",
+			url:      "/api/v1/grafana/batch?token=secret",
+			body:     `[{"status":"firing","groupKey":"key"}]`,
+			wantCode: 200,
+			wantBody: `{"results":[{"index":0,"id":"key/firing"}]}`,
+		},
+		{
+			name:     "items use the query parameters of the request",
+			url:      "/api/v1/hooks/test/batch?env=prod",
+			body:     `[{"test":"1"},{"test":"2"}]`,
+			wantCode: 200,
+			wantBody: `{"results":[{"index":0,"id":"prod-1","notifications":[{"name":"log","status":"sent","attempts":1}]},{"index":1,"id":"prod-2","notifications":[{"name":"log","status":"sent","attempts":1}]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", tt.url, strings.NewReader(tt.body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestAsyncListener(t *testing.T) {
+	cfg := &config.ServerConfiguration{
+		Async: &config.AsyncConfiguration{
+			Enabled:   true,
+			Workers:   1,
+			QueueSize: 10,
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	router := CreateRouter(ctx, 1)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/pubsub", strings.NewReader(`{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 202, w.Code)
+	response := AsyncResponse{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, response.DeliveryId)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/pubsub", strings.NewReader(`{}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	// the parsed messages of a batch are queued, the other ones are returned as errors
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/pubsub/batch", strings.NewReader(`[{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"2"},{}]`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 202, w.Code)
+	response = AsyncResponse{}
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, response.DeliveryId)
+	if assert.Len(t, response.Errors, 1) {
+		assert.Equal(t, 1, response.Errors[0].Index)
+	}
+
+	// nothing is queued when none of the payloads can be parsed
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/pubsub/batch", strings.NewReader(`[{},{}]`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	failed := DispatchResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &failed))
+	if assert.Len(t, failed.Results, 2) {
+		assert.Equal(t, 1, failed.Results[1].Index)
+		assert.NotNil(t, failed.Results[1].Error)
+	}
+
+	// a batch larger than the queue can never be queued
+	items := []string{}
+	for i := 0; i < 11; i++ {
+		items = append(items, fmt.Sprintf(`{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"%v"}`, i))
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/pubsub/batch", strings.NewReader("["+strings.Join(items, ",")+"]"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 413, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+}
+
+func TestResponsePolicy(t *testing.T) {
+	message := "hello {{ .data.test }}"
+	failure := `{"index":0,"id":"1","notifications":[{"name":"broken","status":"failed","error":"notification type of 'does-not-exist' does not exist. Check the notification type of the 'broken' notification"},{"name":"log","status":"sent","attempts":1}]}`
+	tests := []struct {
+		name     string
+		policy   string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "default policy fails when any notification fails",
+			body:     `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`,
+			wantCode: 500,
+			wantBody: `{"results":[` + failure + `]}`,
+		},
+		{
+			name:     "any succeeded",
+			policy:   "anySucceeded",
+			body:     `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`,
+			wantCode: 200,
+			wantBody: `{"results":[` + failure + `]}`,
+		},
+		{
+			name:     "multi status",
+			policy:   "multiStatus",
+			body:     `{"data":"eyJ0ZXN0IjoiMTIzIn0=","ID":"1"}`,
+			wantCode: 207,
+			wantBody: `{"results":[` + failure + `]}`,
+		},
+		{
+			name:     "multi status when all fail",
+			policy:   "multiStatus",
+			body:     `{"data":"eyJvdGhlciI6IjEyMyJ9","ID":"2"}`,
+			wantCode: 500,
+			wantBody: `{"results":[{"index":0,"id":"2","notifications":[{"name":"broken","status":"failed","error":"notification type of 'does-not-exist' does not exist. Check the notification type of the 'broken' notification"}]}]}`,
+		},
+		{
+			name:     "always ok",
+			policy:   "alwaysOk",
+			body:     `{"data":"eyJvdGhlciI6IjEyMyJ9","ID":"2"}`,
+			wantCode: 200,
+			wantBody: `{"results":[{"index":0,"id":"2","notifications":[{"name":"broken","status":"failed","error":"notification type of 'does-not-exist' does not exist. Check the notification type of the 'broken' notification"}]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ServerConfiguration{
+				ResponsePolicy: tt.policy,
+				Notifications: []config.Notification{
+					{
+						Name: "broken",
+						Type: "does-not-exist",
+					},
+					{
+						Name:                "log",
+						Type:                "log",
+						CelExpressionFilter: "has(data.test)",
+						Properties: map[string]config.PropertyAndValue{
+							"message": {Value: &message},
+						},
+					},
+				},
+			}
+			ctx := config.WithCtx(context.Background(), cfg)
+			router := CreateRouter(ctx, 1)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/pubsub", strings.NewReader(tt.body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kcloutie/knot/pkg/http"
+	"github.com/kcloutie/knot/pkg/silence"
+	"go.uber.org/zap"
+)
+
+func ListSilences(ctx context.Context, c *gin.Context, store silence.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	silences, err := store.List(ctx)
+	if err != nil {
+		respondSilenceError(c, log, 500, "List", err)
+		return
+	}
+	now := time.Now()
+	response := SilenceListResponse{
+		Silences: []SilenceResponse{},
+	}
+	for _, s := range silences {
+		response.Silences = append(response.Silences, SilenceResponse{Silence: s, State: s.State(now)})
+	}
+	c.JSON(200, response)
+}
+
+func GetSilence(ctx context.Context, c *gin.Context, store silence.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	s, err := store.Get(ctx, c.Param("id"))
+	if err != nil {
+		respondSilenceError(c, log, 404, "Get", err)
+		return
+	}
+	c.JSON(200, SilenceResponse{Silence: *s, State: s.State(time.Now())})
+}
+
+func AddSilence(ctx context.Context, c *gin.Context, store silence.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	request := SilenceRequest{}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		respondSilenceError(c, log, 400, "Add", fmt.Errorf("failed to unmarshal the silence request - %v", err))
+		return
+	}
+	s, err := NewSilence(request)
+	if err != nil {
+		respondSilenceError(c, log, 400, "Add", err)
+		return
+	}
+	err = store.Add(ctx, s)
+	if err != nil {
+		respondSilenceError(c, log, 400, "Add", err)
+		return
+	}
+	log.Info(fmt.Sprintf("silence '%s' added by '%s' until %v", s.Id, s.CreatedBy, s.EndsAt))
+	c.JSON(201, SilenceResponse{Silence: s, State: s.State(time.Now())})
+}
+
+// ExpireSilence ends the silence now. Expired silences are kept so they can still be listed
+func ExpireSilence(ctx context.Context, c *gin.Context, store silence.Store) {
+	var log *zap.Logger
+	log, ctx = http.SetCommonLoggingAttributes(ctx, c)
+	s, err := store.Expire(ctx, c.Param("id"))
+	if err != nil {
+		respondSilenceError(c, log, 404, "Expire", err)
+		return
+	}
+	c.JSON(200, SilenceResponse{Silence: *s, State: s.State(time.Now())})
+}
+
+// NewSilence creates the silence from the request
+func NewSilence(request SilenceRequest) (silence.Silence, error) {
+	endsAt, err := silence.GetEndTime(request.StartsAt, request.EndsAt, request.Duration)
+	if err != nil {
+		return silence.Silence{}, err
+	}
+	s := silence.New(request.Matcher, request.StartsAt, endsAt, request.CreatedBy, request.Comment)
+	return s, s.Validate()
+}
+
+func respondSilenceError(c *gin.Context, log *zap.Logger, status int, action string, err error) {
+	errD := &http.ErrorDetail{
+		Type:     "silence-" + strings.ToLower(action),
+		Title:    "Silence " + action,
+		Status:   int64(status),
+		Detail:   err.Error(),
+		Instance: c.Request.URL.Path,
+	}
+	log.Error(errD.Detail)
+	c.JSON(status, errD)
+}
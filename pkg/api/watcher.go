@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+
+	"github.com/kcloutie/knot/pkg/clock"
+	"github.com/kcloutie/knot/pkg/dispatcher"
+	"github.com/kcloutie/knot/pkg/listener"
+	"github.com/kcloutie/knot/pkg/logger"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// watcherSource exposes a watcher as a dispatcher source. Watchers do not have an api path so the name is used instead
+type watcherSource struct {
+	listener.WatcherInterface
+}
+
+func (w watcherSource) GetApiPath() string {
+	return w.GetName()
+}
+
+// StartWatchers initializes and starts all configured watchers. Each watcher is started in its own goroutine so a
+// watcher that can not start does not block the server. Watchers stop when the context is cancelled
+func StartWatchers(ctx context.Context) {
+	log := logger.FromCtx(ctx)
+	for _, w := range listener.GetWatchers(ctx) {
+		w := w
+		go startWatcher(ctx, log, w)
+	}
+}
+
+func startWatcher(ctx context.Context, log *zap.Logger, w listener.WatcherInterface) {
+	slog := log.Sugar()
+	source := watcherSource{w}
+	err := w.Initialize(ctx)
+	if err != nil {
+		slog.Errorf("Failed to initialize watcher %s. Error: %v", w.GetName(), err)
+		return
+	}
+	watcherLog := log.With(zap.String("watcher", w.GetName()))
+	err = w.Watch(ctx, func(ctx context.Context, data *message.NotificationData) {
+		meta := data.GetMeta()
+		meta.Listener = w.GetName()
+		meta.Path = source.GetApiPath()
+		meta.ReceivedTime = clock.Now(ctx)
+		data.Meta = &meta
+		results := dispatcher.Dispatch(ctx, watcherLog, source, data)
+		watcherLog.Debug("message dispatched", zap.Any("results", results))
+	})
+	if err != nil {
+		slog.Errorf("Failed to start watcher %s. Error: %v", w.GetName(), err)
+		return
+	}
+	slog.Debugf("Started watcher %s", w.GetName())
+}
@@ -6,11 +6,15 @@ import (
 	"fmt"
 
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
 	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter"
+	"github.com/kcloutie/knot/pkg/clock"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/structpb"
@@ -22,70 +26,147 @@ var (
 	mapType    = reflect.TypeOf(&structpb.Struct{})
 )
 
-func celEvaluateValue(expr string, env *cel.Env, data map[string]interface{}) (ref.Val, error) {
-	parsed, issues := env.Parse(expr)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("failed to parse expression %#v: %w", expr, issues.Err())
-	}
+// Environment compiles the CEL expressions using a set of declarations. The programs are cached by expression so each
+// expression is parsed, checked and planned once. It is safe for concurrent use
+type Environment struct {
+	env      *cel.Env
+	mutex    sync.RWMutex
+	programs map[string]program
+	// limit is the maximum number of cached programs, 0 means unbounded
+	limit int
+}
 
-	checked, issues := env.Check(parsed)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("expression %#v check failed: %w", expr, issues.Err())
+func NewEnvironment(declarations ...cel.EnvOption) (*Environment, error) {
+	options := append([]cel.EnvOption{}, declarations...)
+	env, err := cel.NewEnv(append(options, TimeFunctions(), Libraries())...)
+	if err != nil {
+		return nil, err
 	}
+	return &Environment{
+		env:      env,
+		programs: map[string]program{},
+	}, nil
+}
 
-	prg, err := env.Program(checked, cel.EvalOptions(cel.OptOptimize))
+// program is a compiled expression with the variables it references
+type program struct {
+	program   cel.Program
+	variables map[string]bool
+}
+
+// NewBoundedEnvironment creates an environment caching at most limit programs. Use it when the expressions are not
+// known when the configuration is loaded, such as the ones created at runtime, so the cache cannot grow forever
+func NewBoundedEnvironment(limit int, declarations ...cel.EnvOption) (*Environment, error) {
+	env, err := NewEnvironment(declarations...)
 	if err != nil {
-		return nil, fmt.Errorf("expression %#v failed to create a Program: %w", expr, err)
+		return nil, err
 	}
+	env.limit = limit
+	return env, nil
+}
 
-	out, _, err := prg.Eval(data)
+// Compile returns the program of the expression, compiling and caching it when it is not cached yet
+func (e *Environment) Compile(expr string) (cel.Program, error) {
+	compiled, err := e.compile(expr)
 	if err != nil {
-		return nil, fmt.Errorf("expression %#v failed to evaluate: %w", expr, err)
+		return nil, err
 	}
-
-	return out, nil
+	return compiled.program, nil
 }
 
-func CelValue(query string, declarations cel.EnvOption, data map[string]interface{}) (ref.Val, error) {
-
-	celDec, _ := cel.NewEnv(declarations)
-	val, err := celEvaluateValue(query, celDec, data)
+// References returns true when the expression uses the variable
+func (e *Environment) References(expr string, variable string) (bool, error) {
+	compiled, err := e.compile(expr)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return val, nil
+	return compiled.variables[variable], nil
 }
 
-func CelEvaluate(ctx context.Context, expr string, declarations cel.EnvOption, data map[string]interface{}) (ref.Val, error) {
+func (e *Environment) compile(expr string) (program, error) {
+	e.mutex.RLock()
+	compiled, exists := e.programs[expr]
+	e.mutex.RUnlock()
+	if exists {
+		return compiled, nil
+	}
 
-	env, err := cel.NewEnv(declarations)
+	parsed, issues := e.env.Parse(expr)
+	if issues != nil && issues.Err() != nil {
+		return program{}, fmt.Errorf("failed to parse expression %#v: %w", expr, issues.Err())
+	}
+
+	checked, issues := e.env.Check(parsed)
+	if issues != nil && issues.Err() != nil {
+		return program{}, fmt.Errorf("expression %#v check failed: %w", expr, issues.Err())
+	}
 
+	prg, err := e.env.Program(checked, cel.EvalOptions(cel.OptOptimize))
 	if err != nil {
-		return nil, err
+		return program{}, fmt.Errorf("expression %#v failed to create a Program: %w", expr, err)
+	}
+	checkedExpr, err := cel.AstToCheckedExpr(checked)
+	if err != nil {
+		return program{}, fmt.Errorf("expression %#v failed to list its references: %w", expr, err)
+	}
+	compiled = program{program: prg, variables: map[string]bool{}}
+	for _, reference := range checkedExpr.GetReferenceMap() {
+		if reference.GetName() != "" {
+			compiled.variables[reference.GetName()] = true
+		}
 	}
 
-	parsed, issues := env.Parse(expr)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("failed to parse expression %#v: %w", expr, issues.Err())
+	e.mutex.Lock()
+	if e.limit > 0 && len(e.programs) >= e.limit {
+		// evict an arbitrary program, it is compiled again the next time it is used
+		for key := range e.programs {
+			delete(e.programs, key)
+			break
+		}
 	}
+	e.programs[expr] = compiled
+	e.mutex.Unlock()
+	return compiled, nil
+}
 
-	checked, issues := env.Check(parsed)
-	if issues != nil && issues.Err() != nil {
-		return nil, fmt.Errorf("expression %#v check failed: %w", expr, issues.Err())
+// Evaluate evaluates the expression against the data, using the clock of the context for the time functions
+func (e *Environment) Evaluate(ctx context.Context, expr string, data map[string]interface{}) (ref.Val, error) {
+	prg, err := e.Compile(expr)
+	if err != nil {
+		return nil, err
 	}
 
-	prg, err := env.Program(checked)
+	vars, err := interpreter.NewActivation(data)
 	if err != nil {
-		return nil, fmt.Errorf("expression %#v failed to create a Program: %w", expr, err)
+		return nil, err
+	}
+	now, err := interpreter.NewActivation(map[string]interface{}{nowVariable: types.Timestamp{Time: clock.Now(ctx)}})
+	if err != nil {
+		return nil, err
 	}
 
-	out, _, err := prg.Eval(data)
+	out, _, err := prg.Eval(interpreter.NewHierarchicalActivation(vars, now))
 	if err != nil {
 		return nil, fmt.Errorf("expression %#v failed to evaluate: %w", expr, err)
 	}
 	return out, nil
 }
 
+// CelValue evaluates the query once without caching the program. Use an Environment to evaluate an expression many times
+func CelValue(query string, declarations cel.EnvOption, data map[string]interface{}) (ref.Val, error) {
+	return CelEvaluate(context.Background(), query, declarations, data)
+}
+
+// CelEvaluate evaluates the expression once without caching the program. Use an Environment to evaluate an expression
+// many times
+func CelEvaluate(ctx context.Context, expr string, declarations cel.EnvOption, data map[string]interface{}) (ref.Val, error) {
+	env, err := NewEnvironment(declarations)
+	if err != nil {
+		return nil, err
+	}
+	return env.Evaluate(ctx, expr, data)
+}
+
 func GetCelValue(val ref.Val) string {
 	var raw interface{}
 	var b []byte
@@ -148,3 +229,23 @@ func GetCelValue(val ref.Val) string {
 	}
 	return string(b)
 }
+
+// GetNativeValue converts the CEL value to the go value used by the templates (string, float64, bool, nil,
+// []interface{} or map[string]interface{}). Timestamps and durations are converted to strings
+func GetNativeValue(val ref.Val) (interface{}, error) {
+	switch v := val.(type) {
+	case types.Timestamp:
+		return v.Time.Format(time.RFC3339Nano), nil
+	case types.Duration:
+		return v.Duration.String(), nil
+	case types.Int:
+		return int64(v), nil
+	case types.Uint:
+		return uint64(v), nil
+	}
+	raw, err := val.ConvertToNative(structType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the value of type %v - %v", val.Type(), err)
+	}
+	return raw.(*structpb.Value).AsInterface(), nil
+}
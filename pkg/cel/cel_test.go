@@ -4,12 +4,15 @@ import (
 	"context"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/kcloutie/knot/pkg/clock"
 )
 
 var desc = cel.Declarations(
@@ -183,6 +186,22 @@ func TestCelEvaluate(t *testing.T) {
 			},
 			want: types.False,
 		},
+		{
+			name: "time functions",
+			args: args{
+				ctx:  clock.WithCtx(context.Background(), func() time.Time { return time.Date(2024, 1, 8, 14, 30, 0, 0, time.UTC) }),
+				expr: `weekday("America/New_York") == "monday" && timeOfDay("America/New_York") == "09:30" && date("Asia/Tokyo") == "2024-01-08" && now().getHours() == 14`,
+			},
+			want: types.True,
+		},
+		{
+			name: "invalid time zone",
+			args: args{
+				ctx:  context.Background(),
+				expr: `weekday("Nowhere/Else") == "monday"`,
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -197,3 +216,132 @@ func TestCelEvaluate(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvironment_Evaluate(t *testing.T) {
+	env, err := NewEnvironment(desc)
+	if err != nil {
+		t.Fatalf("NewEnvironment() error = %v", err)
+	}
+	_, err = env.Compile("data.")
+	if err == nil {
+		t.Fatalf("Environment.Compile() expected an error for an invalid expression")
+	}
+
+	expr := `data.severity == "critical" && timeOfDay("UTC") == "14:30"`
+	data := map[string]interface{}{"data": map[string]interface{}{"severity": "critical"}}
+	ctx := clock.WithCtx(context.Background(), func() time.Time { return time.Date(2024, 1, 8, 14, 30, 0, 0, time.UTC) })
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := env.Evaluate(ctx, expr, data)
+			if err != nil || got != types.True {
+				t.Errorf("Environment.Evaluate() = %v, %v, want true", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(env.programs) != 1 {
+		t.Errorf("Environment.programs = %v programs, want 1", len(env.programs))
+	}
+
+	// the cached program uses the clock of each evaluation
+	later := clock.WithCtx(context.Background(), func() time.Time { return time.Date(2024, 1, 8, 15, 0, 0, 0, time.UTC) })
+	got, err := env.Evaluate(later, expr, data)
+	if err != nil || got != types.False {
+		t.Errorf("Environment.Evaluate() = %v, %v, want false", got, err)
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	location, err := loadLocation("America/New_York")
+	if err != nil || location.String() != "America/New_York" {
+		t.Fatalf("loadLocation() = %v, %v, want America/New_York", location, err)
+	}
+	if cached, exists := locations.Load("America/New_York"); !exists || cached != location {
+		t.Errorf("loadLocation() did not cache the location")
+	}
+	_, err = loadLocation("Nowhere/Else")
+	if err == nil {
+		t.Fatalf("loadLocation() expected an error for an invalid time zone")
+	}
+	if _, exists := locations.Load("Nowhere/Else"); exists {
+		t.Errorf("loadLocation() cached an invalid time zone")
+	}
+}
+
+func TestEnvironment_References(t *testing.T) {
+	env, err := NewEnvironment(desc)
+	if err != nil {
+		t.Fatalf("NewEnvironment() error = %v", err)
+	}
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{expr: `data.severity == "critical"`, want: true},
+		{expr: `"severity" in data`, want: true},
+		{expr: `attributes.source == "prod"`, want: false},
+		{expr: `data.`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := env.References(tt.expr, "data")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Environment.References(%v) = %v, %v, want %v", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewBoundedEnvironment(t *testing.T) {
+	env, err := NewBoundedEnvironment(2, desc)
+	if err != nil {
+		t.Fatalf("NewBoundedEnvironment() error = %v", err)
+	}
+	data := map[string]interface{}{"data": map[string]interface{}{"severity": "critical"}}
+	for _, expr := range []string{`data.severity == "critical"`, `data.severity == "warning"`, `data.severity != "info"`} {
+		_, err := env.Evaluate(context.Background(), expr, data)
+		if err != nil {
+			t.Fatalf("Environment.Evaluate() error = %v", err)
+		}
+	}
+	if len(env.programs) != 2 {
+		t.Errorf("Environment.programs = %v programs, want 2", len(env.programs))
+	}
+	if _, exists := env.programs[`data.severity != "info"`]; !exists {
+		t.Errorf("Environment.programs does not contain the last compiled expression")
+	}
+}
+
+var benchmarkExpr = `data.severity == "critical" && attributes.source.startsWith("prod")`
+
+var benchmarkData = map[string]interface{}{
+	"data":       map[string]interface{}{"severity": "critical"},
+	"attributes": map[string]interface{}{"source": "prod-cluster"},
+}
+
+func BenchmarkCelEvaluate(b *testing.B) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		_, err := CelEvaluate(ctx, benchmarkExpr, desc, benchmarkData)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnvironment_Evaluate(b *testing.B) {
+	ctx := context.Background()
+	env, err := NewEnvironment(desc)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := env.Evaluate(ctx, benchmarkExpr, benchmarkData)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
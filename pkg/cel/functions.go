@@ -0,0 +1,250 @@
+package cel
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+)
+
+// timestampLayouts are the layouts tried by parseTimestamp when no layout is given
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+var dayDuration = regexp.MustCompile(`(\d+(\.\d+)?)d`)
+
+// Libraries returns the cel-go extension libraries (strings, encoders, math, sets, lists and optional types) and the
+// knot functions
+func Libraries() cel.EnvOption {
+	return cel.Lib(&library{
+		options: []cel.EnvOption{
+			ext.Strings(),
+			ext.Encoders(),
+			ext.Math(),
+			ext.Sets(),
+			ext.Lists(),
+			cel.OptionalTypes(),
+			Functions(),
+		},
+	})
+}
+
+// Functions returns the knot CEL functions:
+//
+//	<string>.capture(regex) - the groups of the first match of the regex, empty when the string does not match
+//	<string>.captureNamed(regex) - the named groups of the first match of the regex by name
+//	<string>.glob(pattern) - true when the string matches the glob pattern, * and ? do not match /
+//	semverCompare(version, version) - -1, 0 or 1 when the first version is lower, equal or greater
+//	<string>.semverSatisfies(constraint) - true when the version satisfies the constraint, like >= 1.2, < 2
+//	parseDuration(string) - the duration of a go duration string which can also use the d (24h) unit, like 1d12h
+//	parseTimestamp(string) - the timestamp of a RFC3339, RFC1123, 2006-01-02 15:04:05 or 2006-01-02 string
+//	parseTimestamp(string, layout) - the timestamp of a string using a go time layout
+//	parseJson(string) - the value of a JSON string
+func Functions() cel.EnvOption {
+	return cel.Lib(&library{
+		options: []cel.EnvOption{
+			cel.Function("capture",
+				cel.MemberOverload("string_capture_string", []*cel.Type{cel.StringType, cel.StringType}, cel.ListType(cel.StringType),
+					cel.BinaryBinding(capture),
+				),
+			),
+			cel.Function("captureNamed",
+				cel.MemberOverload("string_captureNamed_string", []*cel.Type{cel.StringType, cel.StringType}, cel.MapType(cel.StringType, cel.StringType),
+					cel.BinaryBinding(captureNamed),
+				),
+			),
+			cel.Function("glob",
+				cel.MemberOverload("string_glob_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+					cel.BinaryBinding(glob),
+				),
+			),
+			cel.Function("semverCompare",
+				cel.Overload("semverCompare_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.IntType,
+					cel.BinaryBinding(semverCompare),
+				),
+			),
+			cel.Function("semverSatisfies",
+				cel.MemberOverload("string_semverSatisfies_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+					cel.BinaryBinding(semverSatisfies),
+				),
+			),
+			cel.Function("parseDuration",
+				cel.Overload("parseDuration_string", []*cel.Type{cel.StringType}, cel.DurationType,
+					cel.UnaryBinding(parseDuration),
+				),
+			),
+			cel.Function("parseTimestamp",
+				cel.Overload("parseTimestamp_string", []*cel.Type{cel.StringType}, cel.TimestampType,
+					cel.UnaryBinding(func(value ref.Val) ref.Val {
+						return parseTimestamp(value, nil)
+					}),
+				),
+				cel.Overload("parseTimestamp_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.TimestampType,
+					cel.BinaryBinding(parseTimestamp),
+				),
+			),
+			cel.Function("parseJson",
+				cel.Overload("parseJson_string", []*cel.Type{cel.StringType}, cel.DynType,
+					cel.UnaryBinding(parseJson),
+				),
+			),
+		},
+	})
+}
+
+// regexCacheLimit bounds the number of cached regexes as the regex of capture and captureNamed can come from the data
+const regexCacheLimit = 256
+
+var (
+	regexCacheMutex sync.RWMutex
+	regexCache      = map[string]*regexp.Regexp{}
+)
+
+// compileRegex returns the compiled regex, compiling and caching it when it is not cached yet
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	regexCacheMutex.RLock()
+	re, exists := regexCache[expr]
+	regexCacheMutex.RUnlock()
+	if exists {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexCacheMutex.Lock()
+	if len(regexCache) >= regexCacheLimit {
+		// evict an arbitrary regex, it is compiled again the next time it is used
+		for key := range regexCache {
+			delete(regexCache, key)
+			break
+		}
+	}
+	regexCache[expr] = re
+	regexCacheMutex.Unlock()
+	return re, nil
+}
+
+func capture(value ref.Val, expr ref.Val) ref.Val {
+	re, err := compileRegex(string(expr.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid regex %v - %v", expr, err)
+	}
+	groups := []string{}
+	match := re.FindStringSubmatch(string(value.(types.String)))
+	if len(match) > 1 {
+		groups = match[1:]
+	}
+	return types.DefaultTypeAdapter.NativeToValue(groups)
+}
+
+func captureNamed(value ref.Val, expr ref.Val) ref.Val {
+	re, err := compileRegex(string(expr.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid regex %v - %v", expr, err)
+	}
+	groups := map[string]string{}
+	match := re.FindStringSubmatch(string(value.(types.String)))
+	if match != nil {
+		for i, name := range re.SubexpNames() {
+			if i > 0 && name != "" {
+				groups[name] = match[i]
+			}
+		}
+	}
+	return types.DefaultTypeAdapter.NativeToValue(groups)
+}
+
+func glob(value ref.Val, pattern ref.Val) ref.Val {
+	matches, err := path.Match(string(pattern.(types.String)), string(value.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid glob pattern %v - %v", pattern, err)
+	}
+	return types.Bool(matches)
+}
+
+func semverCompare(first ref.Val, second ref.Val) ref.Val {
+	v1, err := semver.NewVersion(string(first.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid version %v - %v", first, err)
+	}
+	v2, err := semver.NewVersion(string(second.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid version %v - %v", second, err)
+	}
+	return types.Int(v1.Compare(v2))
+}
+
+func semverSatisfies(value ref.Val, constraint ref.Val) ref.Val {
+	version, err := semver.NewVersion(string(value.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid version %v - %v", value, err)
+	}
+	constraints, err := semver.NewConstraint(string(constraint.(types.String)))
+	if err != nil {
+		return types.NewErr("invalid version constraint %v - %v", constraint, err)
+	}
+	return types.Bool(constraints.Check(version))
+}
+
+func parseDuration(value ref.Val) ref.Val {
+	s := dayDuration.ReplaceAllStringFunc(string(value.(types.String)), func(days string) string {
+		d, _ := strconv.ParseFloat(days[:len(days)-1], 64)
+		return strconv.FormatFloat(d*24, 'f', -1, 64) + "h"
+	})
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return types.NewErr("invalid duration %v - %v", value, err)
+	}
+	return types.Duration{Duration: duration}
+}
+
+func parseTimestamp(value ref.Val, layout ref.Val) ref.Val {
+	layouts := timestampLayouts
+	if layout != nil {
+		layouts = []string{string(layout.(types.String))}
+	}
+	for _, l := range layouts {
+		t, err := time.Parse(l, string(value.(types.String)))
+		if err == nil {
+			return types.Timestamp{Time: t}
+		}
+	}
+	return types.NewErr("invalid timestamp %v", value)
+}
+
+func parseJson(value ref.Val) ref.Val {
+	var parsed interface{}
+	err := json.Unmarshal([]byte(value.(types.String)), &parsed)
+	if err != nil {
+		return types.NewErr("invalid JSON - %v", err)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(parsed)
+}
+
+// library is a CEL library of environment options
+type library struct {
+	options []cel.EnvOption
+}
+
+func (l *library) CompileOptions() []cel.EnvOption {
+	return l.options
+}
+
+func (l *library) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{}
+}
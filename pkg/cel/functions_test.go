@@ -0,0 +1,88 @@
+package cel
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+)
+
+func TestFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"data": map[string]interface{}{
+			"message": "deploy of api-v1.4.2 failed after 90s",
+			"path":    "/var/log/api/error.log",
+			"version": "1.4.2",
+			"tags":    []interface{}{"prod", "api", "prod"},
+		},
+		"attributes": map[string]interface{}{
+			"details": `{"retries": 3, "region": "us-east1", "owners": ["platform"]}`,
+		},
+	}
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "strings", expr: `data.message.upperAscii().startsWith("DEPLOY") && "a,b".split(",").size() == 2`},
+		{name: "encoders", expr: `base64.encode(b"knot") == "a25vdA=="`},
+		{name: "math", expr: `math.greatest(1, 5, 3) == 5`},
+		{name: "sets", expr: `sets.contains(data.tags, ["api"])`},
+		{name: "lists", expr: `[1, 2, 3, 4].slice(1, 3) == [2, 3]`},
+		{name: "optional types", expr: `data.?missing.orValue("default") == "default"`},
+		{name: "capture", expr: `data.message.capture("api-v([0-9.]+) failed after ([0-9]+)s") == ["1.4.2", "90"]`},
+		{name: "capture without match", expr: `data.message.capture("nope-([0-9]+)") == []`},
+		{name: "capture named", expr: `data.message.captureNamed("v(?P<version>[0-9.]+)").version == "1.4.2"`},
+		{name: "invalid regex", expr: `data.message.capture("(") == []`, wantErr: true},
+		{name: "glob", expr: `data.path.glob("/var/log/*/*.log") && !data.path.glob("/var/log/*.log")`},
+		{name: "semver compare", expr: `semverCompare(data.version, "1.10.0") == -1 && semverCompare("2.0.0", "2.0.0") == 0`},
+		{name: "semver satisfies", expr: `data.version.semverSatisfies(">= 1.4, < 2") && !data.version.semverSatisfies("^2")`},
+		{name: "invalid version", expr: `semverCompare("latest", "1.0.0") == 0`, wantErr: true},
+		{name: "parse duration", expr: `parseDuration("1d12h") == duration("36h") && parseDuration("90s") > duration("1m")`},
+		{name: "parse timestamp", expr: `parseTimestamp("2024-01-08 14:30:00") == timestamp("2024-01-08T14:30:00Z") && parseTimestamp("2024-01-08").getDate() == 8`},
+		{name: "parse timestamp with layout", expr: `parseTimestamp("08/01/2024", "02/01/2006") == timestamp("2024-01-08T00:00:00Z")`},
+		{name: "invalid timestamp", expr: `parseTimestamp("yesterday") == now()`, wantErr: true},
+		{name: "parse json", expr: `parseJson(attributes.details).retries == 3.0 && "platform" in parseJson(attributes.details).owners`},
+		{name: "invalid json", expr: `parseJson("{").retries == 3`, wantErr: true},
+	}
+	env, err := NewEnvironment(desc)
+	if err != nil {
+		t.Fatalf("NewEnvironment() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := env.Evaluate(context.Background(), tt.expr, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Environment.Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != types.True {
+				t.Errorf("Environment.Evaluate() = %v, want true", got)
+			}
+		})
+	}
+}
+
+func TestCompileRegex(t *testing.T) {
+	first, err := compileRegex("v([0-9.]+)")
+	if err != nil {
+		t.Fatalf("compileRegex() error = %v", err)
+	}
+	second, err := compileRegex("v([0-9.]+)")
+	if err != nil || second != first {
+		t.Errorf("compileRegex() = %p, %v, want the cached regex %p", second, err, first)
+	}
+	_, err = compileRegex("(")
+	if err == nil {
+		t.Errorf("compileRegex() expected an error for an invalid regex")
+	}
+	for i := 0; i < regexCacheLimit*2; i++ {
+		_, err := compileRegex(fmt.Sprintf("v%v", i))
+		if err != nil {
+			t.Fatalf("compileRegex() error = %v", err)
+		}
+	}
+	if len(regexCache) > regexCacheLimit {
+		t.Errorf("regexCache = %v regexes, want at most %v", len(regexCache), regexCacheLimit)
+	}
+}
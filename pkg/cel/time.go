@@ -0,0 +1,82 @@
+package cel
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// nowVariable holds the current time of the evaluation. The time functions are expanded to read it so the compiled
+// programs do not depend on the clock and can be cached
+const nowVariable = "@now"
+
+// locations caches the time zones by name so they are not loaded each time an expression is evaluated. The time zones
+// that fail to load are not cached
+var locations sync.Map
+
+func loadLocation(zone string) (*time.Location, error) {
+	if location, exists := locations.Load(zone); exists {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(zone, location)
+	return location, nil
+}
+
+// TimeFunctions returns the CEL functions exposing the current time of the clock used to evaluate the expression:
+//
+//	now() - the current time as a timestamp
+//	timeOfDay(zone) - the current time of day in the time zone, in the 15:04 format
+//	weekday(zone) - the current lower case day of the week in the time zone, like monday
+//	date(zone) - the current date in the time zone, in the 2006-01-02 format
+func TimeFunctions() cel.EnvOption {
+	inZone := func(format func(time.Time) string) func(ref.Val, ref.Val) ref.Val {
+		return func(now ref.Val, zone ref.Val) ref.Val {
+			location, err := loadLocation(zone.(types.String).Value().(string))
+			if err != nil {
+				return types.NewErr("invalid time zone %v - %v", zone, err)
+			}
+			return types.String(format(now.(types.Timestamp).Time.In(location)))
+		}
+	}
+	zoneFunction := func(name string, format func(time.Time) string) cel.EnvOption {
+		return cel.Function("@"+name,
+			cel.Overload("@"+name+"_timestamp_string", []*cel.Type{cel.TimestampType, cel.StringType}, cel.StringType,
+				cel.BinaryBinding(inZone(format)),
+			),
+		)
+	}
+	return cel.Lib(&library{
+		options: []cel.EnvOption{
+			cel.Variable(nowVariable, cel.TimestampType),
+			cel.Macros(
+				cel.NewGlobalMacro("now", 0, expandTimeFunction("")),
+				cel.NewGlobalMacro("timeOfDay", 1, expandTimeFunction("@timeOfDay")),
+				cel.NewGlobalMacro("weekday", 1, expandTimeFunction("@weekday")),
+				cel.NewGlobalMacro("date", 1, expandTimeFunction("@date")),
+			),
+			zoneFunction("timeOfDay", func(t time.Time) string { return t.Format("15:04") }),
+			zoneFunction("weekday", func(t time.Time) string { return strings.ToLower(t.Weekday().String()) }),
+			zoneFunction("date", func(t time.Time) string { return t.Format("2006-01-02") }),
+		},
+	})
+}
+
+// expandTimeFunction expands a time function to the function receiving the current time, or to the current time when
+// the function is empty
+func expandTimeFunction(function string) cel.MacroExpander {
+	return func(eh cel.MacroExprHelper, target *exprpb.Expr, args []*exprpb.Expr) (*exprpb.Expr, *cel.Error) {
+		if function == "" {
+			return eh.Ident(nowVariable), nil
+		}
+		return eh.GlobalCall(function, append([]*exprpb.Expr{eh.Ident(nowVariable)}, args...)...), nil
+	}
+}
@@ -0,0 +1,26 @@
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+type ctxClockKey struct{}
+
+// FromCtx returns the clock stored in the context or time.Now when none is stored. Tests store a fixed clock to
+// evaluate time based logic
+func FromCtx(ctx context.Context) func() time.Time {
+	if now, ok := ctx.Value(ctxClockKey{}).(func() time.Time); ok {
+		return now
+	}
+	return time.Now
+}
+
+// Now returns the current time of the clock stored in the context
+func Now(ctx context.Context) time.Time {
+	return FromCtx(ctx)()
+}
+
+func WithCtx(ctx context.Context, now func() time.Time) context.Context {
+	return context.WithValue(ctx, ctxClockKey{}, now)
+}
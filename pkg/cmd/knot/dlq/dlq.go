@@ -0,0 +1,240 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/kcloutie/knot/pkg/cli"
+	"github.com/kcloutie/knot/pkg/cmd"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/dispatcher"
+	"github.com/kcloutie/knot/pkg/logger"
+	"github.com/kcloutie/knot/pkg/params/settings"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+type DlqCmdOptions struct {
+	IoStreams      *cli.IOStreams
+	CliOpts        *cli.CliOpts
+	ConfigFilePath string
+	Output         string
+	All            bool
+}
+
+func Root(ioStreams *cli.IOStreams) *cobra.Command {
+	options := &DlqCmdOptions{}
+	cCmd := &cobra.Command{
+		Use:   "dlq",
+		Short: "Manages the notifications that failed to be sent",
+		Long: heredoc.Docf(`
+			Manages the notifications stored in the dead letter store. The dead letter store is read from the
+			%[1]sdeadLetter%[1]s property of the server configuration.
+		`, "`"),
+	}
+	cCmd.PersistentFlags().StringVarP(&options.ConfigFilePath, "config-file-path", "c", "", "The path to the server configuration file")
+	cCmd.PersistentFlags().StringVarP(&options.Output, "output", "o", "", "Output format. One of: (json, yaml)")
+	cCmd.AddCommand(listCommand(options, ioStreams))
+	cCmd.AddCommand(showCommand(options, ioStreams))
+	cCmd.AddCommand(replayCommand(options, ioStreams))
+	cCmd.AddCommand(purgeCommand(options, ioStreams))
+	return cCmd
+}
+
+func listCommand(options *DlqCmdOptions, ioStreams *cli.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "Lists the notifications in the dead letter store",
+		Run: func(cCmd *cobra.Command, args []string) {
+			ctx, store := options.init(ioStreams, "list")
+			cmd.CheckForUnknownArgsExitWhenFound(args, ioStreams)
+			err := options.List(ctx, store)
+			if err != nil {
+				cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+			}
+		},
+	}
+}
+
+func showCommand(options *DlqCmdOptions, ioStreams *cli.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "show [id]",
+		Short: "Shows a notification in the dead letter store",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cCmd *cobra.Command, args []string) {
+			ctx, store := options.init(ioStreams, "show")
+			err := options.Show(ctx, store, args[0])
+			if err != nil {
+				cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+			}
+		},
+	}
+}
+
+func replayCommand(options *DlqCmdOptions, ioStreams *cli.IOStreams) *cobra.Command {
+	cCmd := &cobra.Command{
+		Use:   "replay [id...]",
+		Short: "Sends notifications in the dead letter store again using the current configuration",
+		Example: heredoc.Doc(`
+			# replay a single notification
+			knot dlq replay 0b5ba5a8-8bb4-4a7a-a3e5-0d9fa0d9b3a8 -c ./config.yaml
+
+			# replay every notification
+			knot dlq replay --all -c ./config.yaml
+		`),
+		Run: func(cCmd *cobra.Command, args []string) {
+			ctx, store := options.init(ioStreams, "replay")
+			err := options.Replay(ctx, store, args)
+			if err != nil {
+				cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+			}
+		},
+	}
+	cCmd.Flags().BoolVar(&options.All, "all", false, "Replay every notification in the dead letter store")
+	return cCmd
+}
+
+func purgeCommand(options *DlqCmdOptions, ioStreams *cli.IOStreams) *cobra.Command {
+	cCmd := &cobra.Command{
+		Use:   "purge [id...]",
+		Short: "Removes notifications from the dead letter store",
+		Run: func(cCmd *cobra.Command, args []string) {
+			ctx, store := options.init(ioStreams, "purge")
+			err := options.Purge(ctx, store, args)
+			if err != nil {
+				cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+			}
+		},
+	}
+	cCmd.Flags().BoolVar(&options.All, "all", false, "Remove every notification from the dead letter store")
+	return cCmd
+}
+
+// init loads the server configuration and creates the dead letter store. The process exits on errors
+func (o *DlqCmdOptions) init(ioStreams *cli.IOStreams, subCmd string) (context.Context, deadletter.Store) {
+	ctx := cmd.InitContextWithLogger("dlq", subCmd)
+	o.IoStreams = ioStreams
+	o.CliOpts = cli.NewCliOptions()
+	o.IoStreams.SetColorEnabled(!settings.RootOptions.NoColor)
+	err := cmd.VerifyOutputParameterValue(o.Output)
+	if err != nil {
+		cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+	}
+	if o.ConfigFilePath == "" {
+		cmd.WriteCmdErrorToScreen("the --config-file-path flag is required", ioStreams, true, true)
+	}
+	serverConfig, err := config.LoadFromFile(o.ConfigFilePath)
+	if err != nil {
+		cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+	}
+	if serverConfig.DeadLetter == nil {
+		cmd.WriteCmdErrorToScreen("the dead letter store is not configured", ioStreams, true, true)
+	}
+	store, err := deadletter.NewStore(serverConfig.DeadLetter)
+	if err != nil {
+		cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+	}
+	return config.WithCtx(ctx, serverConfig), store
+}
+
+func (o *DlqCmdOptions) List(ctx context.Context, store deadletter.Store) error {
+	entries, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	if o.Output != "" {
+		return o.print(entries)
+	}
+	w := tabwriter.NewWriter(o.IoStreams.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tNOTIFICATION\tSOURCE\tATTEMPTS\tERROR")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%s\n", entry.Id, entry.Time.Format("2006-01-02T15:04:05Z07:00"), entry.Notification, entry.Source, entry.Attempts, entry.Error)
+	}
+	return w.Flush()
+}
+
+func (o *DlqCmdOptions) Show(ctx context.Context, store deadletter.Store, id string) error {
+	entry, err := store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if o.Output == "" {
+		o.Output = "yaml"
+	}
+	return o.print(entry)
+}
+
+func (o *DlqCmdOptions) Replay(ctx context.Context, store deadletter.Store, ids []string) error {
+	entries, err := o.selectEntries(ctx, store, ids)
+	if err != nil {
+		return err
+	}
+	log := logger.FromCtx(ctx)
+	failed := 0
+	for _, entry := range entries {
+		result := dispatcher.Replay(ctx, log, store, entry)
+		if result.Status == dispatcher.StatusFailed {
+			failed++
+			cmd.PrintMessageToConsole(o.IoStreams.Out, fmt.Sprintf("%s %s: %s\n", o.IoStreams.ColorScheme().FailureIcon(), entry.Id, result.Error))
+			continue
+		}
+		cmd.PrintMessageToConsole(o.IoStreams.Out, fmt.Sprintf("%s %s: sent to '%s'\n", o.IoStreams.ColorScheme().SuccessIcon(), entry.Id, result.Name))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v notifications failed to be replayed", failed, len(entries))
+	}
+	return nil
+}
+
+func (o *DlqCmdOptions) Purge(ctx context.Context, store deadletter.Store, ids []string) error {
+	entries, err := o.selectEntries(ctx, store, ids)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = store.Delete(ctx, entry.Id)
+		if err != nil {
+			return err
+		}
+	}
+	cmd.PrintMessageToConsole(o.IoStreams.Out, fmt.Sprintf("%v notification(s) removed\n", len(entries)))
+	return nil
+}
+
+func (o *DlqCmdOptions) selectEntries(ctx context.Context, store deadletter.Store, ids []string) ([]deadletter.Entry, error) {
+	if o.All {
+		return store.List(ctx)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("specify the ids of the notifications or use the --all flag")
+	}
+	entries := []deadletter.Entry{}
+	for _, id := range ids {
+		entry, err := store.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+func (o *DlqCmdOptions) print(value interface{}) error {
+	var out []byte
+	var err error
+	if o.Output == "json" {
+		out, err = json.Marshal(value)
+	} else {
+		out, err = yaml.Marshal(value)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal the output - %v", err)
+	}
+	fmt.Fprintf(o.IoStreams.Out, "%s\n", string(out))
+	return nil
+}
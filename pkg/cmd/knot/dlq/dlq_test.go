@@ -0,0 +1,75 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/message"
+	"github.com/kcloutie/knot/pkg/params/settings"
+	testcli "github.com/kcloutie/knot/pkg/test/cli"
+)
+
+func TestDlqCommandExecute(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(configPath, []byte(fmt.Sprintf("deadLetter:\n  directory: %s\n", filepath.Join(dir, "dlq"))), 0600)
+	if err != nil {
+		t.Fatalf("failed to write the configuration - %v", err)
+	}
+	store, err := deadletter.NewFileStore(filepath.Join(dir, "dlq"))
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	err = store.Add(context.Background(), deadletter.Entry{
+		Id:           "entry1",
+		Time:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Source:       "pub/sub",
+		Notification: "log",
+		Error:        "dude",
+		Attempts:     3,
+		Data:         message.NotificationData{ID: "1"},
+	})
+	if err != nil {
+		t.Fatalf("FileStore.Add() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantOut string
+	}{
+		{
+			name:    "list",
+			args:    []string{"list", "-c", configPath},
+			wantOut: `entry1\s+2024-01-02T03:04:05Z\s+log\s+pub/sub\s+3\s+dude`,
+		},
+		{
+			name:    "show",
+			args:    []string{"show", "entry1", "-c", configPath, "-o", "json"},
+			wantOut: `\{"id":"entry1","time":"2024-01-02T03:04:05Z","source":"pub/sub","notification":"log","error":"dude","attempts":3,"data":\{"Data":null,"Attributes":null,"ID":"1"\}\}`,
+		},
+		{
+			name:    "purge",
+			args:    []string{"purge", "--all", "-c", configPath},
+			wantOut: `1 notification\(s\) removed`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings.IsQuiet = false
+			ioStreams, _, outBuf, errOutBuf := testcli.NewTestIOStreams()
+			cCmd := Root(ioStreams)
+			testcli.TestCommand(t, cCmd, outBuf, errOutBuf, tt.wantOut, "", false, tt.args)
+		})
+	}
+
+	entries, _ := store.List(context.Background())
+	if len(entries) != 0 {
+		t.Errorf("purge should remove every entry, got %v", entries)
+	}
+}
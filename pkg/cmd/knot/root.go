@@ -11,7 +11,9 @@ import (
 	"github.com/MakeNowJust/heredoc"
 	"github.com/kcloutie/knot/pkg/cli"
 	"github.com/kcloutie/knot/pkg/cmd"
+	"github.com/kcloutie/knot/pkg/cmd/knot/dlq"
 	"github.com/kcloutie/knot/pkg/cmd/knot/run"
+	"github.com/kcloutie/knot/pkg/cmd/knot/silence"
 	"github.com/kcloutie/knot/pkg/cmd/knot/version"
 	"github.com/kcloutie/knot/pkg/logger"
 	"github.com/kcloutie/knot/pkg/params"
@@ -63,6 +65,8 @@ func Root(cliParams *params.Run) *cobra.Command {
 	cCmd.PersistentFlags().BoolVar(&showVersion, "version", false, "Show the version")
 	cCmd.AddCommand(version.VersionCommand(ioStreams))
 	cCmd.AddCommand(run.Root(cliParams, ioStreams))
+	cCmd.AddCommand(dlq.Root(ioStreams))
+	cCmd.AddCommand(silence.Root(ioStreams))
 
 	return cCmd
 }
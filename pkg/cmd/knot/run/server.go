@@ -1,10 +1,6 @@
 package run
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-
 	"github.com/MakeNowJust/heredoc"
 	"github.com/kcloutie/knot/pkg/api"
 	"github.com/kcloutie/knot/pkg/cli"
@@ -14,7 +10,6 @@ import (
 
 	"github.com/kcloutie/knot/pkg/cmd"
 	"github.com/kcloutie/knot/pkg/params"
-	"gopkg.in/yaml.v3"
 )
 
 type ServerCmdOptions struct {
@@ -44,20 +39,16 @@ func ServerCommand(run *params.Run, ioStreams *cli.IOStreams) *cobra.Command {
 			ctx := cmd.InitContextWithLogger("run", "server")
 			serverConfig := config.NewServerConfiguration()
 			if options.ConfigFilePath != "" {
-				data, err := os.ReadFile(options.ConfigFilePath)
+				var err error
+				serverConfig, err = config.LoadFromFile(options.ConfigFilePath)
 				if err != nil {
 					cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
 				}
-				err = json.Unmarshal(data, serverConfig)
-				if err != nil {
-					err = yaml.Unmarshal(data, serverConfig)
-					if err != nil {
-						cmd.WriteCmdErrorToScreen(fmt.Sprintf("failed to unmarshal the settings using yaml and json - %v\n\nContents:\n%s", err, string(data)), ioStreams, true, true)
-					}
-				}
 			}
 
 			ctx = config.WithCtx(ctx, serverConfig)
+			// the stores are shared by the router, the watchers and the heartbeats
+			ctx = api.WithStores(ctx)
 
 			options.IoStreams = ioStreams
 			options.CliOpts = cli.NewCliOptions()
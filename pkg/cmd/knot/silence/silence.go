@@ -0,0 +1,226 @@
+package silence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/kcloutie/knot/pkg/cli"
+	"github.com/kcloutie/knot/pkg/cmd"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/params/settings"
+	"github.com/kcloutie/knot/pkg/silence"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+type SilenceCmdOptions struct {
+	IoStreams      *cli.IOStreams
+	CliOpts        *cli.CliOpts
+	ConfigFilePath string
+	Output         string
+	Matcher        string
+	StartsAt       string
+	EndsAt         string
+	Duration       string
+	CreatedBy      string
+	Comment        string
+	All            bool
+}
+
+func Root(ioStreams *cli.IOStreams) *cobra.Command {
+	options := &SilenceCmdOptions{}
+	cCmd := &cobra.Command{
+		Use:   "silence",
+		Short: "Manages the silences which mute notifications",
+		Long: heredoc.Docf(`
+			Manages the silences which mute the notifications matching them for a period of time. The silence file is
+			read from the %[1]ssilences%[1]s property of the server configuration. A running server uses the changes
+			right away.
+		`, "`"),
+	}
+	cCmd.PersistentFlags().StringVarP(&options.ConfigFilePath, "config-file-path", "c", "", "The path to the server configuration file")
+	cCmd.PersistentFlags().StringVarP(&options.Output, "output", "o", "", "Output format. One of: (json, yaml)")
+	cCmd.AddCommand(addCommand(options, ioStreams))
+	cCmd.AddCommand(listCommand(options, ioStreams))
+	cCmd.AddCommand(expireCommand(options, ioStreams))
+	return cCmd
+}
+
+func addCommand(options *SilenceCmdOptions, ioStreams *cli.IOStreams) *cobra.Command {
+	cCmd := &cobra.Command{
+		Use:   "add",
+		Short: "Adds a silence",
+		Example: heredoc.Doc(`
+			# silence the github notification for 2 hours
+			knot silence add --matcher 'notification == "github"' --duration 2h --comment "maintenance" -c ./config.yaml
+
+			# silence a project during a maintenance window
+			knot silence add --matcher 'attributes.project == "demo"' --starts-at 2024-01-06T22:00:00Z --ends-at 2024-01-07T02:00:00Z -c ./config.yaml
+		`),
+		Run: func(cCmd *cobra.Command, args []string) {
+			ctx, store := options.init(ioStreams, "add")
+			cmd.CheckForUnknownArgsExitWhenFound(args, ioStreams)
+			err := options.Add(ctx, store)
+			if err != nil {
+				cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+			}
+		},
+	}
+	cCmd.Flags().StringVar(&options.Matcher, "matcher", "", "The CEL expression selecting the messages to silence. The notification name is available in the notification variable")
+	cCmd.Flags().StringVar(&options.StartsAt, "starts-at", "", "When the silence starts, in the RFC3339 format. Defaults to now")
+	cCmd.Flags().StringVar(&options.EndsAt, "ends-at", "", "When the silence ends, in the RFC3339 format")
+	cCmd.Flags().StringVar(&options.Duration, "duration", "", "How long the silence lasts when --ends-at is not specified, using the go duration format")
+	cCmd.Flags().StringVar(&options.CreatedBy, "created-by", os.Getenv("USER"), "Who created the silence")
+	cCmd.Flags().StringVar(&options.Comment, "comment", "", "Why the notifications are silenced")
+	return cCmd
+}
+
+func listCommand(options *SilenceCmdOptions, ioStreams *cli.IOStreams) *cobra.Command {
+	cCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "Lists the active and pending silences",
+		Run: func(cCmd *cobra.Command, args []string) {
+			ctx, store := options.init(ioStreams, "list")
+			cmd.CheckForUnknownArgsExitWhenFound(args, ioStreams)
+			err := options.List(ctx, store)
+			if err != nil {
+				cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+			}
+		},
+	}
+	cCmd.Flags().BoolVar(&options.All, "all", false, "Also list the expired silences")
+	return cCmd
+}
+
+func expireCommand(options *SilenceCmdOptions, ioStreams *cli.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "expire [id...]",
+		Short: "Ends silences now",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cCmd *cobra.Command, args []string) {
+			ctx, store := options.init(ioStreams, "expire")
+			err := options.Expire(ctx, store, args)
+			if err != nil {
+				cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+			}
+		},
+	}
+}
+
+// init loads the server configuration and creates the silence store. The process exits on errors
+func (o *SilenceCmdOptions) init(ioStreams *cli.IOStreams, subCmd string) (context.Context, silence.Store) {
+	ctx := cmd.InitContextWithLogger("silence", subCmd)
+	o.IoStreams = ioStreams
+	o.CliOpts = cli.NewCliOptions()
+	o.IoStreams.SetColorEnabled(!settings.RootOptions.NoColor)
+	err := cmd.VerifyOutputParameterValue(o.Output)
+	if err != nil {
+		cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+	}
+	if o.ConfigFilePath == "" {
+		cmd.WriteCmdErrorToScreen("the --config-file-path flag is required", ioStreams, true, true)
+	}
+	serverConfig, err := config.LoadFromFile(o.ConfigFilePath)
+	if err != nil {
+		cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+	}
+	if serverConfig.Silences == nil {
+		cmd.WriteCmdErrorToScreen("silences are not configured", ioStreams, true, true)
+	}
+	store, err := silence.NewStore(serverConfig.Silences)
+	if err != nil {
+		cmd.WriteCmdErrorToScreen(err.Error(), ioStreams, true, true)
+	}
+	return config.WithCtx(ctx, serverConfig), store
+}
+
+func (o *SilenceCmdOptions) Add(ctx context.Context, store silence.Store) error {
+	startsAt, err := parseTime("starts-at", o.StartsAt)
+	if err != nil {
+		return err
+	}
+	endsAt, err := parseTime("ends-at", o.EndsAt)
+	if err != nil {
+		return err
+	}
+	endsAt, err = silence.GetEndTime(startsAt, endsAt, o.Duration)
+	if err != nil {
+		return err
+	}
+	s := silence.New(o.Matcher, startsAt, endsAt, o.CreatedBy, o.Comment)
+	err = store.Add(ctx, s)
+	if err != nil {
+		return err
+	}
+	if o.Output != "" {
+		return o.print(s)
+	}
+	cmd.PrintMessageToConsole(o.IoStreams.Out, fmt.Sprintf("%s silence '%s' added until %s\n", o.IoStreams.ColorScheme().SuccessIcon(), s.Id, s.EndsAt.Format(time.RFC3339)))
+	return nil
+}
+
+func (o *SilenceCmdOptions) List(ctx context.Context, store silence.Store) error {
+	silences, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	selected := []silence.Silence{}
+	for _, s := range silences {
+		if o.All || s.State(now) != silence.StateExpired {
+			selected = append(selected, s)
+		}
+	}
+	if o.Output != "" {
+		return o.print(selected)
+	}
+	w := tabwriter.NewWriter(o.IoStreams.Out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tSTARTS AT\tENDS AT\tCREATED BY\tMATCHER\tCOMMENT")
+	for _, s := range selected {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Id, s.State(now), s.StartsAt.Format(time.RFC3339), s.EndsAt.Format(time.RFC3339), s.CreatedBy, s.Matcher, s.Comment)
+	}
+	return w.Flush()
+}
+
+func (o *SilenceCmdOptions) Expire(ctx context.Context, store silence.Store, ids []string) error {
+	for _, id := range ids {
+		_, err := store.Expire(ctx, id)
+		if err != nil {
+			return err
+		}
+		cmd.PrintMessageToConsole(o.IoStreams.Out, fmt.Sprintf("%s silence '%s' expired\n", o.IoStreams.ColorScheme().SuccessIcon(), id))
+	}
+	return nil
+}
+
+func parseTime(flag string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s value '%s', the RFC3339 format is expected - %v", flag, value, err)
+	}
+	return t, nil
+}
+
+func (o *SilenceCmdOptions) print(value interface{}) error {
+	var out []byte
+	var err error
+	if o.Output == "json" {
+		out, err = json.Marshal(value)
+	} else {
+		out, err = yaml.Marshal(value)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal the output - %v", err)
+	}
+	fmt.Fprintf(o.IoStreams.Out, "%s\n", string(out))
+	return nil
+}
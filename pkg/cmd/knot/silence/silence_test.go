@@ -0,0 +1,80 @@
+package silence
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/params/settings"
+	"github.com/kcloutie/knot/pkg/silence"
+	testcli "github.com/kcloutie/knot/pkg/test/cli"
+)
+
+func TestSilenceCommandExecute(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	silencePath := filepath.Join(dir, "silences.json")
+	err := os.WriteFile(configPath, []byte(fmt.Sprintf("silences:\n  file: %s\n", silencePath)), 0600)
+	if err != nil {
+		t.Fatalf("failed to write the configuration - %v", err)
+	}
+	store, err := silence.NewFileStore(silencePath)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	err = store.Add(context.Background(), silence.Silence{
+		Id:        "silence1",
+		Matcher:   `notification == "log"`,
+		StartsAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		EndsAt:    time.Now().Add(time.Hour).UTC(),
+		CreatedBy: "me",
+		Comment:   "maintenance",
+	})
+	if err != nil {
+		t.Fatalf("FileStore.Add() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantOut    string
+		wantErrOut string
+		wantErr    bool
+	}{
+		{
+			name:    "list",
+			args:    []string{"list", "-c", configPath},
+			wantOut: `silence1\s+active\s+2024-01-02T03:04:05Z\s+\S+\s+me\s+notification == "log"\s+maintenance`,
+		},
+		{
+			name:    "add",
+			args:    []string{"add", "--matcher", `id == "1"`, "--duration", "1h", "-c", configPath},
+			wantOut: `silence '.+' added until`,
+		},
+		{
+			name:    "expire",
+			args:    []string{"expire", "silence1", "-c", configPath},
+			wantOut: `silence 'silence1' expired`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings.IsQuiet = false
+			ioStreams, _, outBuf, errOutBuf := testcli.NewTestIOStreams()
+			cCmd := Root(ioStreams)
+			testcli.TestCommand(t, cCmd, outBuf, errOutBuf, tt.wantOut, tt.wantErrOut, tt.wantErr, tt.args)
+		})
+	}
+
+	silences, _ := store.List(context.Background())
+	if len(silences) != 2 {
+		t.Fatalf("add should store the silence, got %v", silences)
+	}
+	s, _ := store.Get(context.Background(), "silence1")
+	if s.State(time.Now()) != silence.StateExpired {
+		t.Errorf("expire should end the silence, got %v", s)
+	}
+}
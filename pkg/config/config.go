@@ -2,13 +2,19 @@ package config
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/kcloutie/knot/pkg/gcp"
 	"github.com/kcloutie/knot/pkg/message"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
 )
 
 func AsBoolPointer(val bool) *bool {
@@ -16,20 +22,537 @@ func AsBoolPointer(val bool) *bool {
 }
 
 type ServerConfiguration struct {
-	Notifications  []Notification `json:"notifications,omitempty" yaml:"notifications,omitempty"`
-	TraceHeaderKey string         `json:"traceHeaderKey,omitempty" yaml:"traceHeaderKey,omitempty"`
+	Notifications   []Notification                  `json:"notifications,omitempty" yaml:"notifications,omitempty"`
+	Webhooks        []WebhookEndpoint               `json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+	Kubernetes      *KubernetesWatcherConfiguration `json:"kubernetes,omitempty" yaml:"kubernetes,omitempty"`
+	Grafana         *AlertListenerConfiguration     `json:"grafana,omitempty" yaml:"grafana,omitempty"`
+	CloudMonitoring *AlertListenerConfiguration     `json:"cloudMonitoring,omitempty" yaml:"cloudMonitoring,omitempty"`
+	Async           *AsyncConfiguration             `json:"async,omitempty" yaml:"async,omitempty"`
+	TraceHeaderKey  string                          `json:"traceHeaderKey,omitempty" yaml:"traceHeaderKey,omitempty"`
+	// ResponsePolicy determines the http status returned to the listener when notifications fail. One of
+	// allSucceeded, anySucceeded, multiStatus or alwaysOk
+	ResponsePolicy string                      `json:"responsePolicy,omitempty" yaml:"responsePolicy,omitempty"`
+	DeadLetter     *DeadLetterConfiguration    `json:"deadLetter,omitempty" yaml:"deadLetter,omitempty"`
+	Silences       *SilenceConfiguration       `json:"silences,omitempty" yaml:"silences,omitempty"`
+	Admin          *AdminConfiguration         `json:"admin,omitempty" yaml:"admin,omitempty"`
+	Deduplication  *DeduplicationConfiguration `json:"deduplication,omitempty" yaml:"deduplication,omitempty"`
+	Correlation    *CorrelationConfiguration   `json:"correlation,omitempty" yaml:"correlation,omitempty"`
+	Heartbeats     *HeartbeatConfiguration     `json:"heartbeats,omitempty" yaml:"heartbeats,omitempty"`
+	Escalation     *EscalationConfiguration    `json:"escalation,omitempty" yaml:"escalation,omitempty"`
+	// Route is the root of the routing tree. When set, the routing tree selects the notifications (receivers) of each
+	// message instead of evaluating every notification
+	Route *Route `json:"route,omitempty" yaml:"route,omitempty"`
+	// PropertySets are named sets of properties that notifications and notification templates can use
+	PropertySets map[string]map[string]PropertyAndValue `json:"propertySets,omitempty" yaml:"propertySets,omitempty"`
+	// NotificationTemplates are named partial notifications that notifications and other templates can extend
+	NotificationTemplates map[string]Notification `json:"notificationTemplates,omitempty" yaml:"notificationTemplates,omitempty"`
+	// EscalationPolicies are named multi step escalations that notifications can reference
+	EscalationPolicies map[string]EscalationPolicy `json:"escalationPolicies,omitempty" yaml:"escalationPolicies,omitempty"`
+	// Enrichments are lookup tables joined to the messages. The matching rows are available to the CEL expressions and
+	// templates as enrichment.<name>
+	Enrichments []Enrichment `json:"enrichments,omitempty" yaml:"enrichments,omitempty"`
+	// Schedules are named active windows that notifications can reference
+	Schedules map[string]Schedule `json:"schedules,omitempty" yaml:"schedules,omitempty"`
+	// Meta configures the meta object of the messages
+	Meta *MetaConfiguration `json:"meta,omitempty" yaml:"meta,omitempty"`
 	//X-Cloud-Trace-Context
 }
 
+// MetaConfiguration configures the meta object describing how the messages were received
+type MetaConfiguration struct {
+	// Headers is the list of request headers copied into meta.headers of the messages received by the http listeners
+	Headers []string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// Route is a node of the routing tree. A message matching the route is passed to the child routes in order, stopping
+// at the first matching child unless the child has continue set. When no child route matches, the message is sent to
+// the receivers of the route, so the receivers of the root route are the default receivers
+type Route struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Matcher is a CEL expression evaluated against the message. When empty, the route matches every message
+	Matcher string `json:"matcher,omitempty" yaml:"matcher,omitempty"`
+	// Receivers is the list of notification names the message is sent to
+	Receivers []string `json:"receivers,omitempty" yaml:"receivers,omitempty"`
+	// Continue evaluates the next sibling routes even when this route matched
+	Continue bool `json:"continue,omitempty" yaml:"continue,omitempty"`
+	// Properties are inherited by the child routes and override the properties of the receivers
+	Properties map[string]PropertyAndValue `json:"properties,omitempty" yaml:"properties,omitempty"`
+	Routes     []Route                     `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+// GetNotification returns the notification with the given name
+func (s *ServerConfiguration) GetNotification(name string) (Notification, bool) {
+	for _, not := range s.Notifications {
+		if not.Name == name {
+			return not, true
+		}
+	}
+	return Notification{}, false
+}
+
+const (
+	ResponsePolicyAllSucceeded = "allSucceeded"
+	ResponsePolicyAnySucceeded = "anySucceeded"
+	ResponsePolicyMultiStatus  = "multiStatus"
+	ResponsePolicyAlwaysOk     = "alwaysOk"
+)
+
+// GetResponsePolicy returns the configured response policy or the default policy when none is configured
+func (s *ServerConfiguration) GetResponsePolicy(defaultPolicy string) string {
+	if s.ResponsePolicy != "" {
+		return s.ResponsePolicy
+	}
+	return defaultPolicy
+}
+
+// WebhookEndpoint is a named endpoint (/api/v1/hooks/{name}) that accepts any JSON body
+type WebhookEndpoint struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Headers is the list of request headers that are copied into the message attributes
+	Headers []string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// QueryParams is the list of query parameters that are copied into the message attributes
+	QueryParams []string `json:"queryParams,omitempty" yaml:"queryParams,omitempty"`
+	// IdExpression is a CEL expression evaluated against the message to get the message ID. When empty, a random ID is generated
+	IdExpression string `json:"idExpression,omitempty" yaml:"idExpression,omitempty"`
+	// Notifications is the list of notification names the endpoint can trigger. When empty, all notifications can be triggered
+	Notifications []string `json:"notifications,omitempty" yaml:"notifications,omitempty"`
+}
+
+// KubernetesWatcherConfiguration configures the watching of kubernetes events and resources
+type KubernetesWatcherConfiguration struct {
+	// Kubeconfig is the path to the kubeconfig file. When empty, the in cluster configuration is used
+	Kubeconfig string `json:"kubeconfig,omitempty" yaml:"kubeconfig,omitempty"`
+	// ResyncSeconds is the number of seconds between informer resyncs. 0 disables resyncs
+	ResyncSeconds int `json:"resyncSeconds,omitempty" yaml:"resyncSeconds,omitempty"`
+	// SyncTimeoutSeconds is how long the watcher waits for the initial list of the watched objects. Defaults to 60
+	SyncTimeoutSeconds int                    `json:"syncTimeoutSeconds,omitempty" yaml:"syncTimeoutSeconds,omitempty"`
+	Events             *KubernetesEventsWatch `json:"events,omitempty" yaml:"events,omitempty"`
+	// Resources are the resources to watch. The service account must be allowed to list and watch them, see the
+	// config/200-deployment-role.yaml role
+	Resources []KubernetesResourceWatch `json:"resources,omitempty" yaml:"resources,omitempty"`
+}
+
+func (k *KubernetesWatcherConfiguration) GetSyncTimeout() time.Duration {
+	if k.SyncTimeoutSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(k.SyncTimeoutSeconds) * time.Second
+}
+
+type KubernetesEventsWatch struct {
+	Namespace     string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty" yaml:"fieldSelector,omitempty"`
+}
+
+type KubernetesResourceWatch struct {
+	Group   string `json:"group,omitempty" yaml:"group,omitempty"`
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	Kind    string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Resource is the plural resource name. When empty, it is guessed from the kind
+	Resource      string `json:"resource,omitempty" yaml:"resource,omitempty"`
+	Namespace     string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	LabelSelector string `json:"labelSelector,omitempty" yaml:"labelSelector,omitempty"`
+}
+
+// AsyncConfiguration enables the asynchronous processing of messages. When enabled, listeners respond as soon as the
+// message is queued and the notifications are sent by a pool of workers
+type AsyncConfiguration struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Workers is the number of messages processed concurrently. Defaults to 4
+	Workers int `json:"workers,omitempty" yaml:"workers,omitempty"`
+	// QueueSize is the number of messages that can wait to be processed. When the queue is full, listeners respond
+	// with a 503. Defaults to 100
+	QueueSize int `json:"queueSize,omitempty" yaml:"queueSize,omitempty"`
+}
+
+func (a *AsyncConfiguration) GetWorkers() int {
+	if a.Workers > 0 {
+		return a.Workers
+	}
+	return 4
+}
+
+func (a *AsyncConfiguration) GetQueueSize() int {
+	if a.QueueSize > 0 {
+		return a.QueueSize
+	}
+	return 100
+}
+
+// DeadLetterConfiguration enables the persistence of notifications that failed to be sent so they can be replayed
+type DeadLetterConfiguration struct {
+	// Type is the store of the failed notifications. Only file is supported and is the default
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Directory is where the failed notifications are stored, one file per notification
+	Directory string `json:"directory,omitempty" yaml:"directory,omitempty"`
+}
+
+const (
+	DeadLetterStoreFile = "file"
+)
+
+// SilenceConfiguration enables the silences which mute the notifications matching them for a period of time
+type SilenceConfiguration struct {
+	// File is where the silences are stored
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+// CorrelationConfiguration configures where the state of the correlated notifications is stored
+type CorrelationConfiguration struct {
+	// Directory is where the state is stored, one file per fingerprint. The state is kept in memory when empty
+	Directory string `json:"directory,omitempty" yaml:"directory,omitempty"`
+}
+
+// EscalationConfiguration configures the acknowledgement links of the escalations. The escalations are kept in memory,
+// the pending steps and acknowledgement links of the escalations started before a restart are lost
+type EscalationConfiguration struct {
+	// SigningKey is the secret used to sign the acknowledgement links
+	SigningKey *PropertyAndValue `json:"signingKey,omitempty" yaml:"signingKey,omitempty"`
+	// BaseUrl is the external url of the server used in the acknowledgement links, like https://knot.example.com
+	BaseUrl string `json:"baseUrl,omitempty" yaml:"baseUrl,omitempty"`
+	// LinkTTL is how long the acknowledgement links are valid, using the go duration format. Defaults to 24h
+	LinkTTL string `json:"linkTTL,omitempty" yaml:"linkTTL,omitempty"`
+}
+
+func (e *EscalationConfiguration) GetLinkTTL() (time.Duration, error) {
+	return parseDuration("link ttl", e.LinkTTL, 24*time.Hour)
+}
+
+// EscalationPolicy sends the notifications of each step, one after the other, until the escalation is acknowledged.
+// The templates of the notifications receive .escalation.id, .escalation.step and .escalation.ackUrl
+type EscalationPolicy struct {
+	Steps []EscalationStep `json:"steps,omitempty" yaml:"steps,omitempty"`
+}
+
+type EscalationStep struct {
+	// After is how long to wait for an acknowledgement after the previous step, using the go duration format
+	After string `json:"after,omitempty" yaml:"after,omitempty"`
+	// Notifications are the names of the notifications sent by the step
+	Notifications []string `json:"notifications,omitempty" yaml:"notifications,omitempty"`
+}
+
+func (e *EscalationStep) GetAfter() (time.Duration, error) {
+	after, err := parseDuration("after", e.After, 0)
+	if err == nil && after <= 0 {
+		err = fmt.Errorf("the after duration must be greater than 0")
+	}
+	return after, err
+}
+
+const (
+	EnrichmentFormatYaml = "yaml"
+	EnrichmentFormatJson = "json"
+	EnrichmentFormatCsv  = "csv"
+)
+
+// Enrichment is a lookup table read from a local file. The file is checked for changes at most once per second and
+// read again when it changes, the rows read before are kept when it cannot be read
+type Enrichment struct {
+	Name string `json:"name" yaml:"name"`
+	File string `json:"file" yaml:"file"`
+	// Format is yaml, json or csv. Defaults to the extension of the file
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	// KeyExpression is a CEL expression evaluated against the message returning the key of the row to join
+	KeyExpression string `json:"keyExpression" yaml:"keyExpression"`
+	// KeyField is the field of the rows containing the key for csv files and yaml or json lists. Defaults to the first
+	// column of csv files. The keys of yaml or json objects are used when the file contains an object
+	KeyField string `json:"keyField,omitempty" yaml:"keyField,omitempty"`
+}
+
+// GetFormat returns the format of the file, using its extension when the format is not specified
+func (e *Enrichment) GetFormat() string {
+	if e.Format != "" {
+		return strings.ToLower(e.Format)
+	}
+	switch strings.ToLower(filepath.Ext(e.File)) {
+	case ".json":
+		return EnrichmentFormatJson
+	case ".csv":
+		return EnrichmentFormatCsv
+	}
+	return EnrichmentFormatYaml
+}
+
+// HeartbeatConfiguration enables the heartbeats which fire a notification when an expected message does not arrive in
+// time
+type HeartbeatConfiguration struct {
+	// File is where the state of the heartbeats is stored so it survives restarts
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// CheckInterval is how often the heartbeats are checked, using the go duration format. Defaults to 1m
+	CheckInterval string      `json:"checkInterval,omitempty" yaml:"checkInterval,omitempty"`
+	Monitors      []Heartbeat `json:"monitors,omitempty" yaml:"monitors,omitempty"`
+}
+
+func (h *HeartbeatConfiguration) GetCheckInterval() (time.Duration, error) {
+	return parseDuration("check interval", h.CheckInterval, time.Minute)
+}
+
+// Heartbeat expects a message matching the CEL matcher every interval. When no message arrives before the interval
+// and the grace period elapse, the notification is sent. It is sent again every interval until a message arrives
+type Heartbeat struct {
+	Name    string `json:"name" yaml:"name"`
+	Matcher string `json:"matcher,omitempty" yaml:"matcher,omitempty"`
+	// Interval is how often the message is expected, using the go duration format
+	Interval string `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// GracePeriod is how late the message can be before the notification is sent. Defaults to 0
+	GracePeriod string `json:"gracePeriod,omitempty" yaml:"gracePeriod,omitempty"`
+	// Notification is the name of the notification sent when the message is late. The templates of the notification
+	// receive .data.heartbeat, .data.lastSeen and .data.expectedBy
+	Notification string `json:"notification,omitempty" yaml:"notification,omitempty"`
+}
+
+func (h *Heartbeat) GetInterval() (time.Duration, error) {
+	interval, err := parseDuration("interval", h.Interval, 0)
+	if err == nil && interval <= 0 {
+		err = fmt.Errorf("the interval must be greater than 0")
+	}
+	return interval, err
+}
+
+func (h *Heartbeat) GetGracePeriod() (time.Duration, error) {
+	return parseDuration("grace period", h.GracePeriod, 0)
+}
+
+// DeduplicationConfiguration enables the deduplication of messages. A message already delivered to a notification is
+// skipped when it is received again (same listener, message id and notification) before the ttl expires
+type DeduplicationConfiguration struct {
+	// Type is the store of the delivered messages, memory or file. Defaults to memory. The file store can be shared by
+	// multiple instances using the same directory
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// TTL is how long a delivered message is remembered, using the go duration format. Defaults to 1h
+	TTL string `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	// MaxEntries is the number of delivered messages remembered by the in memory store. Defaults to 10000
+	MaxEntries int `json:"maxEntries,omitempty" yaml:"maxEntries,omitempty"`
+	// Directory is where the file store records the delivered messages, one file per message
+	Directory string `json:"directory,omitempty" yaml:"directory,omitempty"`
+}
+
+const (
+	DeduplicationStoreMemory = "memory"
+	DeduplicationStoreFile   = "file"
+)
+
+func (d *DeduplicationConfiguration) GetTTL() (time.Duration, error) {
+	return parseDuration("ttl", d.TTL, time.Hour)
+}
+
+func (d *DeduplicationConfiguration) GetMaxEntries() int {
+	if d.MaxEntries > 0 {
+		return d.MaxEntries
+	}
+	return 10000
+}
+
+// AdminConfiguration enables the admin api (/api/v1/admin). The authentication is required
+type AdminConfiguration struct {
+	Authentication *ListenerAuthentication `json:"authentication,omitempty" yaml:"authentication,omitempty"`
+}
+
+// AlertListenerConfiguration enables an alerting listener (grafana, google cloud monitoring...)
+type AlertListenerConfiguration struct {
+	Authentication *ListenerAuthentication `json:"authentication,omitempty" yaml:"authentication,omitempty"`
+}
+
+// ListenerAuthentication is used by listeners to authenticate requests before they are processed. When both basic
+// auth and a token are configured, either one is accepted
+type ListenerAuthentication struct {
+	BasicAuth *BasicAuth        `json:"basicAuth,omitempty" yaml:"basicAuth,omitempty"`
+	Token     *PropertyAndValue `json:"token,omitempty" yaml:"token,omitempty"`
+	// TokenQueryParam is the name of the query parameter containing the token. Defaults to 'token'
+	TokenQueryParam string `json:"tokenQueryParam,omitempty" yaml:"tokenQueryParam,omitempty"`
+}
+
+type BasicAuth struct {
+	Username PropertyAndValue `json:"username,omitempty" yaml:"username,omitempty"`
+	Password PropertyAndValue `json:"password,omitempty" yaml:"password,omitempty"`
+}
+
 type Notification struct {
-	Name                string                      `json:"name,omitempty" yaml:"name,omitempty"`
-	CelExpressionFilter string                      `json:"celExpressionFilter,omitempty" yaml:"celExpressionFilter,omitempty"`
-	Disabled            bool                        `json:"disabled,omitempty" yaml:"disabled,omitempty"`
-	Type                string                      `json:"type,omitempty" yaml:"type,omitempty"`
-	Properties          map[string]PropertyAndValue `json:"properties,omitempty" yaml:"properties,omitempty"`
+	Name                string `json:"name,omitempty" yaml:"name,omitempty"`
+	CelExpressionFilter string `json:"celExpressionFilter,omitempty" yaml:"celExpressionFilter,omitempty"`
+	// Disabled disables the notification. It is a pointer so a notification can enable a disabled template
+	Disabled    *bool                       `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Type        string                      `json:"type,omitempty" yaml:"type,omitempty"`
+	Properties  map[string]PropertyAndValue `json:"properties,omitempty" yaml:"properties,omitempty"`
+	Retry       *RetryPolicy                `json:"retry,omitempty" yaml:"retry,omitempty"`
+	RateLimit   *RateLimit                  `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
+	Digest      *Digest                     `json:"digest,omitempty" yaml:"digest,omitempty"`
+	Correlation *Correlation                `json:"correlation,omitempty" yaml:"correlation,omitempty"`
+	// Variables are evaluated in order once the notification matched. Their values are available to the templates and
+	// CEL expressions as vars, a variable can use the variables declared before it
+	Variables []Variable `json:"variables,omitempty" yaml:"variables,omitempty"`
+	// Fallbacks are the names of the notifications sent, in order, when the notification fails to be sent. The first
+	// fallback sent stops the chain. The templates of the fallbacks receive .failure.notification, .failure.error and
+	// .failure.attempts
+	Fallbacks []string `json:"fallbacks,omitempty" yaml:"fallbacks,omitempty"`
+	// Escalation is the name of the escalation policy started once the notification is sent
+	Escalation string `json:"escalation,omitempty" yaml:"escalation,omitempty"`
+	// Schedule is the name of the schedule during which the notification is active. The notification does not match
+	// any message outside of the schedule
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// Extends is the list of notification templates the notification is based on, applied in order
+	Extends []string `json:"extends,omitempty" yaml:"extends,omitempty"`
+	// PropertySets is the list of property sets added to the properties of the notification, applied in order
+	PropertySets []string `json:"propertySets,omitempty" yaml:"propertySets,omitempty"`
 	// Secrets             []Secret          `json:"secrets,omitempty" yaml:"secrets,omitempty"`
 }
 
+// IsDisabled returns true when the notification is disabled
+func (n Notification) IsDisabled() bool {
+	return n.Disabled != nil && *n.Disabled
+}
+
+// RetryPolicy determines how a notification is retried when the provider fails with a retryable error. Durations
+// use the go duration format (500ms, 10s, 1m...)
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one. Defaults to 3
+	MaxAttempts int `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`
+	// InitialBackoff is the time to wait after the first attempt, it doubles after each attempt. Defaults to 1s
+	InitialBackoff string `json:"initialBackoff,omitempty" yaml:"initialBackoff,omitempty"`
+	// MaxBackoff is the maximum time to wait between attempts. Defaults to 30s
+	MaxBackoff string `json:"maxBackoff,omitempty" yaml:"maxBackoff,omitempty"`
+	// Jitter is the fraction (0 to 1) of the backoff that is randomized. Defaults to 0.2
+	Jitter *float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// Deadline is the total time allowed for all attempts. When empty, there is no deadline
+	Deadline string `json:"deadline,omitempty" yaml:"deadline,omitempty"`
+}
+
+func (r *RetryPolicy) GetMaxAttempts() int {
+	if r.MaxAttempts > 0 {
+		return r.MaxAttempts
+	}
+	return 3
+}
+
+func (r *RetryPolicy) GetInitialBackoff() (time.Duration, error) {
+	return parseDuration("initialBackoff", r.InitialBackoff, time.Second)
+}
+
+func (r *RetryPolicy) GetMaxBackoff() (time.Duration, error) {
+	return parseDuration("maxBackoff", r.MaxBackoff, 30*time.Second)
+}
+
+func (r *RetryPolicy) GetJitter() float64 {
+	if r.Jitter != nil {
+		return *r.Jitter
+	}
+	return 0.2
+}
+
+func (r *RetryPolicy) GetDeadline() (time.Duration, error) {
+	return parseDuration("deadline", r.Deadline, 0)
+}
+
+func parseDuration(name string, value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s duration '%s' - %v", name, value, err)
+	}
+	return d, nil
+}
+
+// Digest collects the matching messages and sends them together once the window ends or the maximum number of
+// messages is reached. The templates of the notification receive .data.messages (the collected messages), .data.count
+// and .data.key
+type Digest struct {
+	// Window is how long messages are collected, starting with the first message. Uses the go duration format.
+	// Defaults to 5m
+	Window string `json:"window,omitempty" yaml:"window,omitempty"`
+	// MaxMessages sends the digest as soon as this number of messages is collected. 0 means there is no maximum
+	MaxMessages int `json:"maxMessages,omitempty" yaml:"maxMessages,omitempty"`
+	// Key is a go template rendered with the message to collect the messages in separate digests (repo, pipeline...).
+	// When empty, all messages of the notification are collected in the same digest
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+}
+
+func (d *Digest) GetWindow() (time.Duration, error) {
+	return parseDuration("window", d.Window, 5*time.Minute)
+}
+
+// Variable is a named CEL expression evaluated against the message
+type Variable struct {
+	Name       string `json:"name" yaml:"name"`
+	Expression string `json:"expression" yaml:"expression"`
+}
+
+// Correlation tracks the state of the notifications with the same fingerprint. The state of the earlier notifications
+// is available to the CEL expressions and templates as previous so providers can update, thread or resolve the original
+// notification. The state is loaded once the message matches unless the celExpressionFilter uses previous
+type Correlation struct {
+	// Fingerprint is a go template rendered with the message identifying the notifications that are correlated. It is
+	// required
+	Fingerprint string `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	// ResolvedExpression is a CEL expression that is true when the message resolves the earlier notifications. The
+	// other messages are firing
+	ResolvedExpression string `json:"resolvedExpression,omitempty" yaml:"resolvedExpression,omitempty"`
+}
+
+const (
+	RateLimitActionDrop      = "drop"
+	RateLimitActionDelay     = "delay"
+	RateLimitActionSummarize = "summarize"
+)
+
+// RateLimit limits the number of messages sent by a notification using a token bucket. The bucket holds Limit tokens
+// and is refilled at a rate of Limit tokens per Period
+type RateLimit struct {
+	Limit int `json:"limit,omitempty" yaml:"limit,omitempty"`
+	// Period uses the go duration format. Defaults to 1m
+	Period string `json:"period,omitempty" yaml:"period,omitempty"`
+	// Key is a go template rendered with the message to get a separate bucket per key (repo, channel...). When empty,
+	// all messages of the notification share the same bucket
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Action is what happens to messages over the limit. One of drop (default), delay or summarize
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+	// MaxDelay is the longest a message is delayed with the delay action, messages that would wait longer are dropped.
+	// Defaults to the period
+	MaxDelay string `json:"maxDelay,omitempty" yaml:"maxDelay,omitempty"`
+	// SummaryNotification is the name of the notification sent with the summary action once the bucket has room
+	// again. Its message contains the suppressed count, the key, the notification name and the last suppressed message.
+	// When empty, the summarize action drops the messages
+	SummaryNotification string `json:"summaryNotification,omitempty" yaml:"summaryNotification,omitempty"`
+}
+
+func (r *RateLimit) GetPeriod() (time.Duration, error) {
+	return parseDuration("period", r.Period, time.Minute)
+}
+
+func (r *RateLimit) GetMaxDelay() (time.Duration, error) {
+	if r.MaxDelay == "" {
+		return r.GetPeriod()
+	}
+	return parseDuration("maxDelay", r.MaxDelay, 0)
+}
+
+func (r *RateLimit) GetAction() string {
+	if r.Action != "" {
+		return r.Action
+	}
+	return RateLimitActionDrop
+}
+
+// Validate returns an error when the limit, the durations or the action of the rate limit are invalid
+func (r *RateLimit) Validate() error {
+	period, err := r.GetPeriod()
+	if err != nil {
+		return err
+	}
+	if r.Limit <= 0 || period <= 0 {
+		return fmt.Errorf("the limit and period must be greater than 0")
+	}
+	switch r.GetAction() {
+	case RateLimitActionDrop, RateLimitActionSummarize:
+		return nil
+	case RateLimitActionDelay:
+		_, err := r.GetMaxDelay()
+		return err
+	}
+	return fmt.Errorf("invalid action '%s', the action must be drop, delay or summarize", r.Action)
+}
+
 type PropertyAndValue struct {
 	// Name         string               `json:"name,omitempty" yaml:"name,omitempty"`
 	Value        *string              `json:"value,omitempty" yaml:"value,omitempty"`
@@ -104,7 +627,7 @@ func (o PropertyAndValue) GetValue(ctx context.Context, log *zap.Logger, data *m
 	if o.PayloadValue != nil && len(o.PayloadValue.PropertyPaths) != 0 {
 		errs := []string{}
 		for _, path := range o.PayloadValue.PropertyPaths {
-			val, err := data.GetPropertyValue(path)
+			val, err := data.GetPropertyValue(ctx, path)
 			if err == nil {
 				return val, nil
 			} else {
@@ -135,6 +658,56 @@ func (o PropertyAndValue) GetValue(ctx context.Context, log *zap.Logger, data *m
 	return o.GetValueProp(), nil
 }
 
+func (a *ListenerAuthentication) GetTokenQueryParam() string {
+	if a.TokenQueryParam != "" {
+		return a.TokenQueryParam
+	}
+	return "token"
+}
+
+// Authenticate returns an error when the request does not contain valid credentials
+func (a *ListenerAuthentication) Authenticate(ctx context.Context, log *zap.Logger, request *http.Request) error {
+	if a == nil || (a.BasicAuth == nil && a.Token == nil) {
+		return nil
+	}
+	if a.BasicAuth != nil {
+		username, password, ok := request.BasicAuth()
+		if ok {
+			expectedUsername, err := a.BasicAuth.Username.GetValue(ctx, log, nil)
+			if err != nil {
+				return fmt.Errorf("failed to get the basic auth username - %v", err)
+			}
+			expectedPassword, err := a.BasicAuth.Password.GetValue(ctx, log, nil)
+			if err != nil {
+				return fmt.Errorf("failed to get the basic auth password - %v", err)
+			}
+			if secureEquals(username, expectedUsername) && secureEquals(password, expectedPassword) {
+				return nil
+			}
+		}
+	}
+	if a.Token != nil {
+		token := request.URL.Query().Get(a.GetTokenQueryParam())
+		if token != "" {
+			expectedToken, err := a.Token.GetValue(ctx, log, nil)
+			if err != nil {
+				return fmt.Errorf("failed to get the authentication token - %v", err)
+			}
+			if secureEquals(token, expectedToken) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("the request did not contain valid credentials")
+}
+
+func secureEquals(a string, b string) bool {
+	if b == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
 // type Secret struct {
 // 	Name       string `json:"name,omitempty" yaml:"name,omitempty"`
 // 	Type       string `json:"type,omitempty" yaml:"type,omitempty"`
@@ -148,6 +721,27 @@ func NewServerConfiguration() *ServerConfiguration {
 	return &ServerConfiguration{}
 }
 
+// LoadFromFile reads the server configuration from a json or yaml file and resolves it
+func LoadFromFile(path string) (*ServerConfiguration, error) {
+	serverConfig := NewServerConfiguration()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, serverConfig)
+	if err != nil {
+		err = yaml.Unmarshal(data, serverConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the settings using yaml and json - %v\n\nContents:\n%s", err, string(data))
+		}
+	}
+	err = serverConfig.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration '%s' - %v", path, err)
+	}
+	return serverConfig, nil
+}
+
 var config *ServerConfiguration
 
 type ctxConfigKey struct{}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"hash/crc32"
+	"net/http"
 	"reflect"
 	"testing"
 
@@ -175,3 +176,70 @@ func TestGetValue(t *testing.T) {
 		})
 	}
 }
+
+func TestListenerAuthentication_Authenticate(t *testing.T) {
+	testLogger := zaptest.NewLogger(t)
+	username := "user"
+	password := "pass"
+	token := "token"
+	both := &ListenerAuthentication{
+		BasicAuth: &BasicAuth{
+			Username: PropertyAndValue{Value: &username},
+			Password: PropertyAndValue{Value: &password},
+		},
+		Token:           &PropertyAndValue{Value: &token},
+		TokenQueryParam: "auth",
+	}
+	tests := []struct {
+		name     string
+		auth     *ListenerAuthentication
+		url      string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{
+			name: "no authentication configured",
+			auth: nil,
+			url:  "/",
+		},
+		{
+			name:     "valid basic auth",
+			auth:     both,
+			url:      "/",
+			username: "user",
+			password: "pass",
+		},
+		{
+			name:     "invalid basic auth with valid token",
+			auth:     both,
+			url:      "/?auth=token",
+			username: "user",
+			password: "wrong",
+		},
+		{
+			name:    "token in default query param",
+			auth:    both,
+			url:     "/?token=token",
+			wantErr: true,
+		},
+		{
+			name:    "no credentials",
+			auth:    both,
+			url:     "/",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest("POST", tt.url, nil)
+			if tt.username != "" {
+				request.SetBasicAuth(tt.username, tt.password)
+			}
+			err := tt.auth.Authenticate(context.Background(), testLogger, request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+// Resolve applies the notification templates and property sets to the notifications. The properties of a
+// notification are merged with the properties of its templates and property sets, the notification properties taking
+// precedence. Other fields of the notification override the fields of its templates when they are set. The response
+// policy, schedules, admin api, enrichments, escalation policies and heartbeats are validated and the CEL expressions
+// are compiled so invalid expressions fail the load instead of every message
+func (s *ServerConfiguration) Resolve() error {
+	switch s.ResponsePolicy {
+	case "", ResponsePolicyAllSucceeded, ResponsePolicyAnySucceeded, ResponsePolicyMultiStatus, ResponsePolicyAlwaysOk:
+	default:
+		return fmt.Errorf("invalid response policy '%s', the policy must be allSucceeded, anySucceeded, multiStatus or alwaysOk", s.ResponsePolicy)
+	}
+	for name, schedule := range s.Schedules {
+		err := schedule.Validate()
+		if err != nil {
+			return fmt.Errorf("schedule '%s' - %v", name, err)
+		}
+		s.Schedules[name] = schedule
+	}
+	resolved := map[string]Notification{}
+	for i, not := range s.Notifications {
+		result, err := s.resolveNotification(not, resolved, []string{})
+		if err != nil {
+			return fmt.Errorf("notification '%s' - %v", not.Name, err)
+		}
+		if _, exists := s.Schedules[result.Schedule]; result.Schedule != "" && !exists {
+			return fmt.Errorf("notification '%s' - schedule '%s' does not exist", not.Name, result.Schedule)
+		}
+		if _, exists := s.EscalationPolicies[result.Escalation]; result.Escalation != "" && !exists {
+			return fmt.Errorf("notification '%s' - escalation policy '%s' does not exist", not.Name, result.Escalation)
+		}
+		err = validateVariables(result.Variables)
+		if err != nil {
+			return fmt.Errorf("notification '%s' - %v", not.Name, err)
+		}
+		if result.RateLimit != nil {
+			err = result.RateLimit.Validate()
+			if err != nil {
+				return fmt.Errorf("notification '%s' - rate limit - %v", not.Name, err)
+			}
+		}
+		if result.Correlation != nil && result.Correlation.Fingerprint == "" {
+			return fmt.Errorf("notification '%s' - correlation - the fingerprint is required", not.Name)
+		}
+		if result.Escalation != "" && (s.Escalation == nil || s.Escalation.SigningKey == nil) {
+			return fmt.Errorf("notification '%s' - the escalation signing key is required to escalate notifications", not.Name)
+		}
+		s.Notifications[i] = result
+	}
+	// the fallbacks and summary notifications are validated once every notification is resolved
+	for _, not := range s.Notifications {
+		for _, fallback := range not.Fallbacks {
+			if _, exists := s.GetNotification(fallback); !exists || fallback == not.Name {
+				return fmt.Errorf("notification '%s' - fallback notification '%s' does not exist or is the notification itself", not.Name, fallback)
+			}
+		}
+		if not.RateLimit != nil && not.RateLimit.SummaryNotification != "" {
+			if _, exists := s.GetNotification(not.RateLimit.SummaryNotification); !exists {
+				return fmt.Errorf("notification '%s' - rate limit - summary notification '%s' does not exist", not.Name, not.RateLimit.SummaryNotification)
+			}
+		}
+	}
+	err := s.validateAdmin()
+	if err != nil {
+		return err
+	}
+	err = s.validateAlertListeners()
+	if err != nil {
+		return err
+	}
+	err = s.validateEnrichments()
+	if err != nil {
+		return err
+	}
+	err = s.validateEscalationPolicies()
+	if err != nil {
+		return err
+	}
+	err = s.validateHeartbeats()
+	if err != nil {
+		return err
+	}
+	return s.compileExpressions()
+}
+
+// compileExpressions compiles the CEL expressions evaluated against the messages. The programs are cached by the
+// message CEL environment and reused when the messages are received
+func (s *ServerConfiguration) compileExpressions() error {
+	env := message.CelEnvironment()
+	compile := func(owner string, expressions ...string) error {
+		for _, expr := range expressions {
+			if expr == "" {
+				continue
+			}
+			_, err := env.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("%s - %v", owner, err)
+			}
+		}
+		return nil
+	}
+	compileProperties := func(owner string, properties map[string]PropertyAndValue) error {
+		for name, property := range properties {
+			if property.PayloadValue == nil {
+				continue
+			}
+			err := compile(fmt.Sprintf("%s property '%s'", owner, name), property.PayloadValue.PropertyPaths...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, not := range s.Notifications {
+		owner := fmt.Sprintf("notification '%s'", not.Name)
+		err := compile(owner, not.CelExpressionFilter)
+		if err != nil {
+			return err
+		}
+		for _, variable := range not.Variables {
+			err = compile(fmt.Sprintf("%s variable '%s'", owner, variable.Name), variable.Expression)
+			if err != nil {
+				return err
+			}
+		}
+		if not.Correlation != nil {
+			err = compile(owner+" correlation", not.Correlation.ResolvedExpression)
+			if err != nil {
+				return err
+			}
+		}
+		err = compileProperties(owner, not.Properties)
+		if err != nil {
+			return err
+		}
+	}
+	for _, webhook := range s.Webhooks {
+		err := compile(fmt.Sprintf("webhook '%s'", webhook.Name), webhook.IdExpression)
+		if err != nil {
+			return err
+		}
+	}
+	for _, enrichment := range s.Enrichments {
+		err := compile(fmt.Sprintf("enrichment '%s'", enrichment.Name), enrichment.KeyExpression)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Heartbeats != nil {
+		for _, heartbeat := range s.Heartbeats.Monitors {
+			err := compile(fmt.Sprintf("heartbeat '%s'", heartbeat.Name), heartbeat.Matcher)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Route != nil {
+		return compileRoute(*s.Route, "route", compile, compileProperties)
+	}
+	return nil
+}
+
+func compileRoute(route Route, owner string, compile func(string, ...string) error, compileProperties func(string, map[string]PropertyAndValue) error) error {
+	if route.Name != "" {
+		owner = fmt.Sprintf("route '%s'", route.Name)
+	}
+	err := compile(owner, route.Matcher)
+	if err != nil {
+		return err
+	}
+	err = compileProperties(owner, route.Properties)
+	if err != nil {
+		return err
+	}
+	for i, child := range route.Routes {
+		err = compileRoute(child, fmt.Sprintf("%s child %v", owner, i), compile, compileProperties)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAdmin requires the authentication of the admin api as it can purge, replay and silence notifications
+func (s *ServerConfiguration) validateAdmin() error {
+	if s.Admin == nil {
+		return nil
+	}
+	auth := s.Admin.Authentication
+	if auth == nil || (auth.BasicAuth == nil && auth.Token == nil) {
+		return fmt.Errorf("admin - the authentication is required to enable the admin api")
+	}
+	return nil
+}
+
+// validateAlertListeners requires the authentication of the alert listeners as they accept requests from anyone
+// otherwise
+func (s *ServerConfiguration) validateAlertListeners() error {
+	listeners := []struct {
+		name string
+		cfg  *AlertListenerConfiguration
+	}{
+		{"grafana", s.Grafana},
+		{"cloudMonitoring", s.CloudMonitoring},
+	}
+	for _, listener := range listeners {
+		if listener.cfg == nil {
+			continue
+		}
+		auth := listener.cfg.Authentication
+		if auth == nil || (auth.BasicAuth == nil && auth.Token == nil) {
+			return fmt.Errorf("%s - the authentication is required to enable the listener", listener.name)
+		}
+	}
+	return nil
+}
+
+func (s *ServerConfiguration) validateEnrichments() error {
+	names := map[string]bool{}
+	for i, enrichment := range s.Enrichments {
+		if enrichment.Name == "" || names[enrichment.Name] {
+			return fmt.Errorf("the name of enrichment %v must be unique and not empty", i)
+		}
+		names[enrichment.Name] = true
+		if enrichment.File == "" || enrichment.KeyExpression == "" {
+			return fmt.Errorf("enrichment '%s' - the file and key expression are required", enrichment.Name)
+		}
+		switch enrichment.GetFormat() {
+		case EnrichmentFormatYaml, EnrichmentFormatJson, EnrichmentFormatCsv:
+		default:
+			return fmt.Errorf("enrichment '%s' - invalid format '%s', the format must be yaml, json or csv", enrichment.Name, enrichment.Format)
+		}
+	}
+	return nil
+}
+
+func validateVariables(variables []Variable) error {
+	names := map[string]bool{}
+	for i, variable := range variables {
+		if variable.Name == "" || names[variable.Name] {
+			return fmt.Errorf("the name of variable %v must be unique and not empty", i)
+		}
+		if variable.Expression == "" {
+			return fmt.Errorf("the expression of variable '%s' is empty", variable.Name)
+		}
+		names[variable.Name] = true
+	}
+	return nil
+}
+
+func (s *ServerConfiguration) validateEscalationPolicies() error {
+	if s.Escalation != nil {
+		_, err := s.Escalation.GetLinkTTL()
+		if err != nil {
+			return fmt.Errorf("escalation - %v", err)
+		}
+	}
+	for name, policy := range s.EscalationPolicies {
+		for i, step := range policy.Steps {
+			_, err := step.GetAfter()
+			for _, notification := range step.Notifications {
+				if _, exists := s.GetNotification(notification); err == nil && !exists {
+					err = fmt.Errorf("notification '%s' does not exist", notification)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("escalation policy '%s' step %v - %v", name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ServerConfiguration) validateHeartbeats() error {
+	if s.Heartbeats == nil {
+		return nil
+	}
+	_, err := s.Heartbeats.GetCheckInterval()
+	if err != nil {
+		return fmt.Errorf("heartbeats - %v", err)
+	}
+	names := map[string]bool{}
+	for _, heartbeat := range s.Heartbeats.Monitors {
+		if heartbeat.Name == "" || names[heartbeat.Name] {
+			return fmt.Errorf("heartbeat '%s' - the name must be unique and not empty", heartbeat.Name)
+		}
+		names[heartbeat.Name] = true
+		_, err = heartbeat.GetInterval()
+		if err == nil {
+			_, err = heartbeat.GetGracePeriod()
+		}
+		if err == nil {
+			if _, exists := s.GetNotification(heartbeat.Notification); !exists {
+				err = fmt.Errorf("notification '%s' does not exist", heartbeat.Notification)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("heartbeat '%s' - %v", heartbeat.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *ServerConfiguration) resolveNotification(not Notification, resolved map[string]Notification, path []string) (Notification, error) {
+	result := Notification{}
+	for _, name := range not.Extends {
+		for _, visited := range path {
+			if visited == name {
+				return Notification{}, fmt.Errorf("notification template cycle detected: %s -> %s", strings.Join(path, " -> "), name)
+			}
+		}
+		template, cached := resolved[name]
+		if !cached {
+			unresolved, exists := s.NotificationTemplates[name]
+			if !exists {
+				return Notification{}, fmt.Errorf("notification template '%s' does not exist", name)
+			}
+			var err error
+			template, err = s.resolveNotification(unresolved, resolved, append(path, name))
+			if err != nil {
+				return Notification{}, err
+			}
+			resolved[name] = template
+		}
+		result = mergeNotifications(result, template)
+	}
+
+	for _, name := range not.PropertySets {
+		properties, exists := s.PropertySets[name]
+		if !exists {
+			return Notification{}, fmt.Errorf("property set '%s' does not exist", name)
+		}
+		result = mergeNotifications(result, Notification{Properties: properties})
+	}
+
+	not.Extends = nil
+	not.PropertySets = nil
+	return mergeNotifications(result, not), nil
+}
+
+// mergeNotifications returns the base notification with the fields of the override that are set. Properties are
+// merged, the properties of the override taking precedence. Disabled overrides the base when it is set, even to false.
+// The other fields override the base when they are not empty, so an inherited filter is replaced with "true" rather
+// than removed
+func mergeNotifications(base Notification, override Notification) Notification {
+	result := base
+	properties := map[string]PropertyAndValue{}
+	for name, value := range base.Properties {
+		properties[name] = value
+	}
+	for name, value := range override.Properties {
+		properties[name] = value
+	}
+	if len(properties) > 0 {
+		result.Properties = properties
+	}
+
+	if override.Disabled != nil {
+		result.Disabled = override.Disabled
+	}
+	overrideString(&result.Name, override.Name)
+	overrideString(&result.CelExpressionFilter, override.CelExpressionFilter)
+	overrideString(&result.Type, override.Type)
+	overrideString(&result.Escalation, override.Escalation)
+	overrideString(&result.Schedule, override.Schedule)
+	if override.Retry != nil {
+		result.Retry = override.Retry
+	}
+	if override.RateLimit != nil {
+		result.RateLimit = override.RateLimit
+	}
+	if override.Digest != nil {
+		result.Digest = override.Digest
+	}
+	if override.Correlation != nil {
+		result.Correlation = override.Correlation
+	}
+	if len(override.Variables) > 0 {
+		result.Variables = override.Variables
+	}
+	if len(override.Fallbacks) > 0 {
+		result.Fallbacks = override.Fallbacks
+	}
+	if len(override.Extends) > 0 {
+		result.Extends = override.Extends
+	}
+	if len(override.PropertySets) > 0 {
+		result.PropertySets = override.PropertySets
+	}
+	return result
+}
+
+func overrideString(base *string, override string) {
+	if override != "" {
+		*base = override
+	}
+}
@@ -0,0 +1,315 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerConfiguration_Resolve(t *testing.T) {
+	channel := "#alerts"
+	otherChannel := "#team"
+	token := "token"
+	tests := []struct {
+		name    string
+		config  ServerConfiguration
+		want    []Notification
+		wantErr string
+	}{
+		{
+			name: "no templates",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", Type: "log"}},
+			},
+			want: []Notification{{Name: "n1", Type: "log"}},
+		},
+		{
+			name: "enables a disabled template",
+			config: ServerConfiguration{
+				NotificationTemplates: map[string]Notification{
+					"draft": {Type: "log", Disabled: AsBoolPointer(true)},
+				},
+				Notifications: []Notification{
+					{Name: "n1", Extends: []string{"draft"}, Disabled: AsBoolPointer(false)},
+					{Name: "n2", Extends: []string{"draft"}},
+				},
+			},
+			want: []Notification{
+				{Name: "n1", Type: "log", Disabled: AsBoolPointer(false)},
+				{Name: "n2", Type: "log", Disabled: AsBoolPointer(true)},
+			},
+		},
+		{
+			name: "extends template and property set",
+			config: ServerConfiguration{
+				PropertySets: map[string]map[string]PropertyAndValue{
+					"auth": {"token": {Value: &token}},
+				},
+				NotificationTemplates: map[string]Notification{
+					"slack": {
+						Type:                "slack",
+						CelExpressionFilter: "true",
+						Properties:          map[string]PropertyAndValue{"channel": {Value: &channel}},
+						Retry:               &RetryPolicy{MaxAttempts: 5},
+					},
+				},
+				Notifications: []Notification{
+					{
+						Name:         "n1",
+						Extends:      []string{"slack"},
+						PropertySets: []string{"auth"},
+						Properties:   map[string]PropertyAndValue{"channel": {Value: &otherChannel}},
+					},
+				},
+			},
+			want: []Notification{
+				{
+					Name:                "n1",
+					Type:                "slack",
+					CelExpressionFilter: "true",
+					Properties: map[string]PropertyAndValue{
+						"channel": {Value: &otherChannel},
+						"token":   {Value: &token},
+					},
+					Retry: &RetryPolicy{MaxAttempts: 5},
+				},
+			},
+		},
+		{
+			name: "templates extending templates",
+			config: ServerConfiguration{
+				NotificationTemplates: map[string]Notification{
+					"base":  {Type: "log", Properties: map[string]PropertyAndValue{"token": {Value: &token}}},
+					"slack": {Type: "slack", Extends: []string{"base"}},
+				},
+				Notifications: []Notification{{Name: "n1", Extends: []string{"slack"}}},
+			},
+			want: []Notification{
+				{Name: "n1", Type: "slack", Properties: map[string]PropertyAndValue{"token": {Value: &token}}},
+			},
+		},
+		{
+			name: "missing template",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", Extends: []string{"missing"}}},
+			},
+			wantErr: "notification 'n1' - notification template 'missing' does not exist",
+		},
+		{
+			name: "missing property set",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", PropertySets: []string{"missing"}}},
+			},
+			wantErr: "notification 'n1' - property set 'missing' does not exist",
+		},
+		{
+			name: "missing schedule",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", Schedule: "missing"}},
+			},
+			wantErr: "notification 'n1' - schedule 'missing' does not exist",
+		},
+		{
+			name: "invalid schedule",
+			config: ServerConfiguration{
+				Schedules: map[string]Schedule{"s1": {Holidays: []string{"christmas"}}},
+			},
+			wantErr: "schedule 's1' - invalid holiday 'christmas', the 2006-01-02 format is expected",
+		},
+		{
+			name: "heartbeat with a missing notification",
+			config: ServerConfiguration{
+				Heartbeats: &HeartbeatConfiguration{
+					Monitors: []Heartbeat{{Name: "nightly", Interval: "24h", Notification: "missing"}},
+				},
+			},
+			wantErr: "heartbeat 'nightly' - notification 'missing' does not exist",
+		},
+		{
+			name: "heartbeat without interval",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1"}},
+				Heartbeats: &HeartbeatConfiguration{
+					Monitors: []Heartbeat{{Name: "nightly", Notification: "n1"}},
+				},
+			},
+			wantErr: "heartbeat 'nightly' - the interval must be greater than 0",
+		},
+		{
+			name: "duplicate enrichment",
+			config: ServerConfiguration{
+				Enrichments: []Enrichment{
+					{Name: "owners", File: "owners.csv", KeyExpression: "data.service"},
+					{Name: "owners", File: "owners.yaml", KeyExpression: "data.service"},
+				},
+			},
+			wantErr: "the name of enrichment 1 must be unique and not empty",
+		},
+		{
+			name: "enrichment with an invalid format",
+			config: ServerConfiguration{
+				Enrichments: []Enrichment{{Name: "owners", File: "owners.xml", Format: "xml", KeyExpression: "data.service"}},
+			},
+			wantErr: "enrichment 'owners' - invalid format 'xml', the format must be yaml, json or csv",
+		},
+		{
+			name: "invalid filter expression",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", CelExpressionFilter: "severity"}},
+			},
+			wantErr: "notification 'n1' - expression \"severity\" check failed: ERROR: <input>:1:1: undeclared reference to 'severity' (in container '')\n | severity\n | ^",
+		},
+		{
+			name: "invalid payload value path",
+			config: ServerConfiguration{
+				Route: &Route{
+					Routes: []Route{{Name: "critical", Properties: map[string]PropertyAndValue{
+						"channel": {PayloadValue: &PayloadValueRef{PropertyPaths: []string{"unknown.channel"}}},
+					}}},
+				},
+			},
+			wantErr: "route 'critical' property 'channel' - expression \"unknown.channel\" check failed: ERROR: <input>:1:1: undeclared reference to 'unknown' (in container '')\n | unknown.channel\n | ^",
+		},
+		{
+			name: "missing escalation policy",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", Escalation: "missing"}},
+			},
+			wantErr: "notification 'n1' - escalation policy 'missing' does not exist",
+		},
+		{
+			name: "escalation without signing key",
+			config: ServerConfiguration{
+				EscalationPolicies: map[string]EscalationPolicy{"p1": {}},
+				Notifications:      []Notification{{Name: "n1", Escalation: "p1"}},
+			},
+			wantErr: "notification 'n1' - the escalation signing key is required to escalate notifications",
+		},
+		{
+			name: "escalation step with a missing notification",
+			config: ServerConfiguration{
+				EscalationPolicies: map[string]EscalationPolicy{
+					"p1": {Steps: []EscalationStep{{After: "5m", Notifications: []string{"missing"}}}},
+				},
+			},
+			wantErr: "escalation policy 'p1' step 0 - notification 'missing' does not exist",
+		},
+		{
+			name: "missing fallback",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", Fallbacks: []string{"missing"}}},
+			},
+			wantErr: "notification 'n1' - fallback notification 'missing' does not exist or is the notification itself",
+		},
+		{
+			name: "duplicate variable",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", Variables: []Variable{{Name: "a", Expression: "1"}, {Name: "a", Expression: "2"}}}},
+			},
+			wantErr: "notification 'n1' - the name of variable 1 must be unique and not empty",
+		},
+		{
+			name: "invalid response policy",
+			config: ServerConfiguration{
+				ResponsePolicy: "allOk",
+			},
+			wantErr: "invalid response policy 'allOk', the policy must be allSucceeded, anySucceeded, multiStatus or alwaysOk",
+		},
+		{
+			name: "invalid rate limit",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", RateLimit: &RateLimit{Limit: 0}}},
+			},
+			wantErr: "notification 'n1' - rate limit - the limit and period must be greater than 0",
+		},
+		{
+			name: "invalid rate limit action",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", RateLimit: &RateLimit{Limit: 1, Action: "queue"}}},
+			},
+			wantErr: "notification 'n1' - rate limit - invalid action 'queue', the action must be drop, delay or summarize",
+		},
+		{
+			name: "invalid rate limit max delay",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", RateLimit: &RateLimit{Limit: 1, Action: "delay", MaxDelay: "soon"}}},
+			},
+			wantErr: "notification 'n1' - rate limit - invalid maxDelay duration 'soon' - time: invalid duration \"soon\"",
+		},
+		{
+			name: "missing summary notification",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", RateLimit: &RateLimit{Limit: 1, Action: "summarize", SummaryNotification: "missing"}}},
+			},
+			wantErr: "notification 'n1' - rate limit - summary notification 'missing' does not exist",
+		},
+		{
+			name: "admin without authentication",
+			config: ServerConfiguration{
+				Admin: &AdminConfiguration{},
+			},
+			wantErr: "admin - the authentication is required to enable the admin api",
+		},
+		{
+			name: "admin with authentication",
+			config: ServerConfiguration{
+				Admin: &AdminConfiguration{Authentication: &ListenerAuthentication{Token: &PropertyAndValue{}}},
+			},
+		},
+		{
+			name: "correlation without fingerprint",
+			config: ServerConfiguration{
+				Notifications: []Notification{{Name: "n1", Correlation: &Correlation{ResolvedExpression: "true"}}},
+			},
+			wantErr: "notification 'n1' - correlation - the fingerprint is required",
+		},
+		{
+			name: "grafana without authentication",
+			config: ServerConfiguration{
+				Grafana: &AlertListenerConfiguration{},
+			},
+			wantErr: "grafana - the authentication is required to enable the listener",
+		},
+		{
+			name: "cloud monitoring without basic auth or token",
+			config: ServerConfiguration{
+				CloudMonitoring: &AlertListenerConfiguration{Authentication: &ListenerAuthentication{}},
+			},
+			wantErr: "cloudMonitoring - the authentication is required to enable the listener",
+		},
+		{
+			name: "alert listeners with authentication",
+			config: ServerConfiguration{
+				Grafana:         &AlertListenerConfiguration{Authentication: &ListenerAuthentication{BasicAuth: &BasicAuth{}}},
+				CloudMonitoring: &AlertListenerConfiguration{Authentication: &ListenerAuthentication{Token: &PropertyAndValue{}}},
+			},
+		},
+		{
+			name: "template cycle",
+			config: ServerConfiguration{
+				NotificationTemplates: map[string]Notification{
+					"a": {Extends: []string{"b"}},
+					"b": {Extends: []string{"a"}},
+				},
+				Notifications: []Notification{{Name: "n1", Extends: []string{"a"}}},
+			},
+			wantErr: "notification 'n1' - notification template cycle detected: a -> b -> a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Resolve()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.config.Notifications, tt.want) {
+				t.Errorf("Resolve() = %+v, want %+v", tt.config.Notifications, tt.want)
+			}
+		})
+	}
+}
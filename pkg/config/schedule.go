@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is an active window defined by time ranges in a time zone, excluding holidays
+type Schedule struct {
+	// TimeZone is the IANA time zone of the ranges and holidays, like America/New_York. Defaults to UTC
+	TimeZone string `json:"timeZone,omitempty" yaml:"timeZone,omitempty"`
+	// Ranges are the time ranges during which the schedule is active. The schedule is active all the time, except
+	// during holidays, when no range is specified
+	Ranges []TimeRange `json:"ranges,omitempty" yaml:"ranges,omitempty"`
+	// Holidays are the dates, in the 2006-01-02 format, during which the schedule is not active
+	Holidays []string `json:"holidays,omitempty" yaml:"holidays,omitempty"`
+
+	parsed *parsedSchedule
+}
+
+// TimeRange is a range of time on some days of the week. When the end is not after the start, the range ends on the
+// next day
+type TimeRange struct {
+	// Weekdays are the days the range starts on, like monday or mon. Defaults to every day
+	Weekdays []string `json:"weekdays,omitempty" yaml:"weekdays,omitempty"`
+	// Start is the time of day the range starts, in the 15:04 format. Defaults to 00:00
+	Start string `json:"start,omitempty" yaml:"start,omitempty"`
+	// End is the time of day the range ends, in the 15:04 format. Defaults to 24:00
+	End string `json:"end,omitempty" yaml:"end,omitempty"`
+}
+
+// parsedSchedule is the schedule with its time zone, ranges and holidays parsed
+type parsedSchedule struct {
+	location *time.Location
+	ranges   []parsedTimeRange
+	holidays map[string]bool
+}
+
+type parsedTimeRange struct {
+	start    time.Duration
+	end      time.Duration
+	weekdays map[time.Weekday]bool
+}
+
+// Validate returns an error when the schedule can not be evaluated. The parsed schedule is kept so it is not parsed
+// again each time it is evaluated
+func (s *Schedule) Validate() error {
+	parsed, err := s.parse()
+	if err != nil {
+		return err
+	}
+	s.parsed = parsed
+	return nil
+}
+
+func (s *Schedule) parse() (*parsedSchedule, error) {
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone '%s' - %v", s.TimeZone, err)
+	}
+	parsed := &parsedSchedule{
+		location: location,
+		ranges:   []parsedTimeRange{},
+		holidays: map[string]bool{},
+	}
+	for _, holiday := range s.Holidays {
+		_, err := time.Parse("2006-01-02", holiday)
+		if err != nil {
+			return nil, fmt.Errorf("invalid holiday '%s', the 2006-01-02 format is expected", holiday)
+		}
+		parsed.holidays[holiday] = true
+	}
+	for i, r := range s.Ranges {
+		parsedRange, err := r.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %v - %v", i, err)
+		}
+		parsed.ranges = append(parsed.ranges, parsedRange)
+	}
+	return parsed, nil
+}
+
+// IsActive returns true when the time is within one of the ranges of the schedule and is not a holiday. The schedule
+// is parsed when it was not validated
+func (s *Schedule) IsActive(t time.Time) (bool, error) {
+	parsed := s.parsed
+	if parsed == nil {
+		var err error
+		parsed, err = s.parse()
+		if err != nil {
+			return false, err
+		}
+	}
+	t = t.In(parsed.location)
+	if parsed.holidays[t.Format("2006-01-02")] {
+		return false, nil
+	}
+	if len(parsed.ranges) == 0 {
+		return true, nil
+	}
+	for _, r := range parsed.ranges {
+		if r.contains(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *TimeRange) parse() (parsedTimeRange, error) {
+	start, err := parseTimeOfDay("start", r.Start, 0)
+	if err != nil {
+		return parsedTimeRange{}, err
+	}
+	end, err := parseTimeOfDay("end", r.End, 24*time.Hour)
+	if err != nil {
+		return parsedTimeRange{}, err
+	}
+	weekdays := map[time.Weekday]bool{}
+	for _, name := range r.Weekdays {
+		weekday, err := parseWeekday(name)
+		if err != nil {
+			return parsedTimeRange{}, err
+		}
+		weekdays[weekday] = true
+	}
+	return parsedTimeRange{start: start, end: end, weekdays: weekdays}, nil
+}
+
+func (r parsedTimeRange) contains(t time.Time) bool {
+	startsOn := func(day time.Weekday) bool {
+		return len(r.weekdays) == 0 || r.weekdays[day]
+	}
+
+	timeOfDay := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if r.end > r.start {
+		return startsOn(t.Weekday()) && timeOfDay >= r.start && timeOfDay < r.end
+	}
+	// the range ends on the next day
+	if startsOn(t.Weekday()) && timeOfDay >= r.start {
+		return true
+	}
+	return startsOn((t.Weekday()+6)%7) && timeOfDay < r.end
+}
+
+func parseTimeOfDay(name string, value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s', the 15:04 format is expected", name, value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseWeekday(value string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday '%s'", value)
+}
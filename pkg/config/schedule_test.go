@@ -0,0 +1,116 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_IsActive(t *testing.T) {
+	businessHours := Schedule{
+		TimeZone: "America/New_York",
+		Ranges:   []TimeRange{{Weekdays: []string{"monday", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}},
+		Holidays: []string{"2024-12-25"},
+	}
+	overnight := Schedule{
+		Ranges: []TimeRange{{Weekdays: []string{"fri"}, Start: "22:00", End: "06:00"}},
+	}
+	tests := []struct {
+		name     string
+		schedule Schedule
+		time     time.Time
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name:     "within business hours",
+			schedule: businessHours,
+			time:     time.Date(2024, 1, 8, 14, 0, 0, 0, time.UTC),
+			want:     true,
+		},
+		{
+			name:     "before business hours in the time zone",
+			schedule: businessHours,
+			time:     time.Date(2024, 1, 8, 13, 59, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekend",
+			schedule: businessHours,
+			time:     time.Date(2024, 1, 6, 15, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "holiday",
+			schedule: businessHours,
+			time:     time.Date(2024, 12, 25, 15, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "overnight range on the start day",
+			schedule: overnight,
+			time:     time.Date(2024, 1, 5, 23, 0, 0, 0, time.UTC),
+			want:     true,
+		},
+		{
+			name:     "overnight range on the next day",
+			schedule: overnight,
+			time:     time.Date(2024, 1, 6, 5, 0, 0, 0, time.UTC),
+			want:     true,
+		},
+		{
+			name:     "overnight range ended",
+			schedule: overnight,
+			time:     time.Date(2024, 1, 6, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "no ranges",
+			schedule: Schedule{},
+			time:     time.Date(2024, 1, 6, 7, 0, 0, 0, time.UTC),
+			want:     true,
+		},
+		{
+			name:     "invalid weekday",
+			schedule: Schedule{Ranges: []TimeRange{{Weekdays: []string{"someday"}}}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid time zone",
+			schedule: Schedule{TimeZone: "Nowhere/Else"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schedule.IsActive(tt.time)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IsActive() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("IsActive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule_Validate(t *testing.T) {
+	schedule := Schedule{
+		TimeZone: "America/New_York",
+		Ranges:   []TimeRange{{Weekdays: []string{"mon"}, Start: "09:00", End: "17:00"}},
+		Holidays: []string{"2024-12-25"},
+	}
+	if err := schedule.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if schedule.parsed == nil || schedule.parsed.location.String() != "America/New_York" || len(schedule.parsed.ranges) != 1 || !schedule.parsed.holidays["2024-12-25"] {
+		t.Fatalf("Validate() parsed = %v, want the parsed schedule", schedule.parsed)
+	}
+	// the parsed schedule is evaluated, the fields are not parsed again
+	schedule.TimeZone = "Nowhere/Else"
+	got, err := schedule.IsActive(time.Date(2024, 1, 8, 14, 0, 0, 0, time.UTC))
+	if err != nil || !got {
+		t.Errorf("IsActive() = %v, %v, want true", got, err)
+	}
+
+	invalid := Schedule{Holidays: []string{"christmas"}}
+	if err := invalid.Validate(); err == nil || invalid.parsed != nil {
+		t.Errorf("Validate() error = %v, parsed = %v, want an error", err, invalid.parsed)
+	}
+}
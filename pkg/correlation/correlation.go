@@ -0,0 +1,263 @@
+package correlation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+)
+
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// State is what is known about the earlier notifications with the same fingerprint
+type State struct {
+	Fingerprint  string    `json:"fingerprint" yaml:"fingerprint"`
+	Notification string    `json:"notification" yaml:"notification"`
+	Status       string    `json:"status" yaml:"status"`
+	FirstSeen    time.Time `json:"firstSeen" yaml:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen" yaml:"lastSeen"`
+	// Count is the number of notifications sent since the state started firing
+	Count int `json:"count" yaml:"count"`
+	// Handles are the provider specific references to the sent notifications (comment id, slack ts, incident key...)
+	Handles map[string]string `json:"handles,omitempty" yaml:"handles,omitempty"`
+}
+
+// AsMap returns the state as exposed to the templates and CEL expressions
+func (s State) AsMap() map[string]interface{} {
+	handles := map[string]interface{}{}
+	for name, value := range s.Handles {
+		handles[name] = value
+	}
+	return map[string]interface{}{
+		"fingerprint":  s.Fingerprint,
+		"notification": s.Notification,
+		"status":       s.Status,
+		"firstSeen":    s.FirstSeen.Format(time.RFC3339),
+		"lastSeen":     s.LastSeen.Format(time.RFC3339),
+		"count":        s.Count,
+		"handles":      handles,
+	}
+}
+
+// Next returns the state after a notification with the status was sent. A notification firing after the state was
+// resolved starts a new state
+func (s *State) Next(fingerprint string, notification string, status string, now time.Time, handles map[string]string) State {
+	next := State{
+		Fingerprint:  fingerprint,
+		Notification: notification,
+		Status:       status,
+		FirstSeen:    now,
+		LastSeen:     now,
+		Count:        1,
+		Handles:      map[string]string{},
+	}
+	if s != nil && !(s.Status == StatusResolved && status == StatusFiring) {
+		next.FirstSeen = s.FirstSeen
+		next.Count = s.Count + 1
+		for name, value := range s.Handles {
+			next.Handles[name] = value
+		}
+	}
+	for name, value := range handles {
+		next.Handles[name] = value
+	}
+	return next
+}
+
+// Store persists the state of the fingerprints
+type Store interface {
+	// Get returns the state of the fingerprint or nil when there is none
+	Get(ctx context.Context, fingerprint string) (*State, error)
+	Put(ctx context.Context, state State) error
+}
+
+// NewStore creates the store from the configuration. The state is kept in memory when no directory is configured
+func NewStore(cfg *config.CorrelationConfiguration) (Store, error) {
+	if cfg == nil || cfg.Directory == "" {
+		return NewMemoryStore(), nil
+	}
+	return NewFileStore(cfg.Directory)
+}
+
+type fingerprintLock struct {
+	mutex sync.Mutex
+	users int
+}
+
+var (
+	locksMutex sync.Mutex
+	locks      = map[string]*fingerprintLock{}
+)
+
+// lock locks the fingerprint and returns the function unlocking it. The lock is removed once no one uses it
+func lock(fingerprint string) func() {
+	locksMutex.Lock()
+	l, exists := locks[fingerprint]
+	if !exists {
+		l = &fingerprintLock{}
+		locks[fingerprint] = l
+	}
+	l.users++
+	locksMutex.Unlock()
+
+	l.mutex.Lock()
+	return func() {
+		l.mutex.Unlock()
+		locksMutex.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(locks, fingerprint)
+		}
+		locksMutex.Unlock()
+	}
+}
+
+// Update stores the state returned by next from the current state of the fingerprint, nil when it has no state yet.
+// The updates of a fingerprint are serialised so concurrent messages do not lose the count or the handles
+func Update(ctx context.Context, store Store, fingerprint string, next func(state *State) State) error {
+	unlock := lock(fingerprint)
+	defer unlock()
+	state, err := store.Get(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, next(state))
+}
+
+type MemoryStore struct {
+	mutex  sync.Mutex
+	states map[string]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		states: map[string]State{},
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, fingerprint string) (*State, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if state, exists := s.states[fingerprint]; exists {
+		return &state, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, state State) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.states[state.Fingerprint] = state
+	return nil
+}
+
+// FileStore stores the state of each fingerprint as a json file in a directory. The files are named with the hash of
+// the fingerprint
+type FileStore struct {
+	Directory string
+}
+
+func NewFileStore(directory string) (*FileStore, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the correlation directory '%s' - %v", directory, err)
+	}
+	return &FileStore{
+		Directory: directory,
+	}, nil
+}
+
+func (s *FileStore) path(fingerprint string) string {
+	hash := sha256.Sum256([]byte(fingerprint))
+	return filepath.Join(s.Directory, hex.EncodeToString(hash[:])+".json")
+}
+
+func (s *FileStore) Get(ctx context.Context, fingerprint string) (*State, error) {
+	data, err := os.ReadFile(s.path(fingerprint))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read the state of fingerprint '%s' - %v", fingerprint, err)
+	}
+	state := &State{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the state of fingerprint '%s' - %v", fingerprint, err)
+	}
+	return state, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, state State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the state of fingerprint '%s' - %v", state.Fingerprint, err)
+	}
+	path := s.path(state.Fingerprint)
+	// write to a temporary file first so a partially written state is never read
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write the state of fingerprint '%s' - %v", state.Fingerprint, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+type ctxHandlesKey struct{}
+
+type handles struct {
+	mutex   sync.Mutex
+	handles map[string]string
+}
+
+// WithHandles returns a context in which the providers can record the handles of the notification they send
+func WithHandles(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxHandlesKey{}, &handles{handles: map[string]string{}})
+}
+
+// SetHandle records a provider specific reference to the sent notification so later notifications with the same
+// fingerprint can update, thread or resolve it. It does nothing when the notification is not correlated
+func SetHandle(ctx context.Context, name string, value string) {
+	if h, ok := ctx.Value(ctxHandlesKey{}).(*handles); ok {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		h.handles[name] = value
+	}
+}
+
+// GetHandles returns the handles recorded in the context
+func GetHandles(ctx context.Context) map[string]string {
+	result := map[string]string{}
+	if h, ok := ctx.Value(ctxHandlesKey{}).(*handles); ok {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		for name, value := range h.handles {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+type ctxStoreKey struct{}
+
+// FromCtx returns the correlation store stored in the context or nil when no notification is correlated
+func FromCtx(ctx context.Context) Store {
+	if s, ok := ctx.Value(ctxStoreKey{}).(Store); ok {
+		return s
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, s Store) context.Context {
+	return context.WithValue(ctx, ctxStoreKey{}, s)
+}
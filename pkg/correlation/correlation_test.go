@@ -0,0 +1,113 @@
+package correlation
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	got, err := store.Get(ctx, "log|build/main")
+	if err != nil || got != nil {
+		t.Fatalf("FileStore.Get() = %v, %v, want no state", got, err)
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	state := got.Next("log|build/main", "log", StatusFiring, now, map[string]string{"commentId": "1"})
+	err = store.Put(ctx, state)
+	if err != nil {
+		t.Fatalf("FileStore.Put() error = %v", err)
+	}
+	got, err = store.Get(ctx, "log|build/main")
+	if err != nil || !reflect.DeepEqual(*got, state) {
+		t.Fatalf("FileStore.Get() = %v, %v, want %v", got, err, state)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(ctx, store, "log|build", func(state *State) State {
+				return state.Next("log|build", "log", StatusFiring, now, map[string]string{fmt.Sprint(i): "x"})
+			})
+			if err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := store.Get(ctx, "log|build")
+	if err != nil || got == nil {
+		t.Fatalf("FileStore.Get() = %v, %v", got, err)
+	}
+	if got.Count != 20 || len(got.Handles) != 20 {
+		t.Errorf("Update() count = %v with %v handles, want 20", got.Count, len(got.Handles))
+	}
+	if len(locks) != 0 {
+		t.Errorf("Update() left %v locks", len(locks))
+	}
+}
+
+func TestState_Next(t *testing.T) {
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	later := first.Add(time.Hour)
+	firing := &State{Fingerprint: "f", Notification: "n", Status: StatusFiring, FirstSeen: first, LastSeen: first, Count: 2, Handles: map[string]string{"ts": "1"}}
+	resolved := &State{Fingerprint: "f", Notification: "n", Status: StatusResolved, FirstSeen: first, LastSeen: first, Count: 3, Handles: map[string]string{"ts": "1"}}
+	tests := []struct {
+		name    string
+		state   *State
+		status  string
+		handles map[string]string
+		want    State
+	}{
+		{
+			name:   "first notification",
+			status: StatusFiring,
+			want:   State{Fingerprint: "f", Notification: "n", Status: StatusFiring, FirstSeen: later, LastSeen: later, Count: 1, Handles: map[string]string{}},
+		},
+		{
+			name:    "still firing",
+			state:   firing,
+			status:  StatusFiring,
+			handles: map[string]string{"other": "2"},
+			want:    State{Fingerprint: "f", Notification: "n", Status: StatusFiring, FirstSeen: first, LastSeen: later, Count: 3, Handles: map[string]string{"ts": "1", "other": "2"}},
+		},
+		{
+			name:   "resolved",
+			state:  firing,
+			status: StatusResolved,
+			want:   State{Fingerprint: "f", Notification: "n", Status: StatusResolved, FirstSeen: first, LastSeen: later, Count: 3, Handles: map[string]string{"ts": "1"}},
+		},
+		{
+			name:   "firing again after resolved",
+			state:  resolved,
+			status: StatusFiring,
+			want:   State{Fingerprint: "f", Notification: "n", Status: StatusFiring, FirstSeen: later, LastSeen: later, Count: 1, Handles: map[string]string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.Next("f", "n", tt.status, later, tt.handles); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("State.Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
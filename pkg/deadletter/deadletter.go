@@ -0,0 +1,175 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Entry is a notification that failed to be sent
+type Entry struct {
+	Id           string                   `json:"id" yaml:"id"`
+	Time         time.Time                `json:"time" yaml:"time"`
+	Source       string                   `json:"source,omitempty" yaml:"source,omitempty"`
+	Notification string                   `json:"notification" yaml:"notification"`
+	Error        string                   `json:"error,omitempty" yaml:"error,omitempty"`
+	Attempts     int                      `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	Data         message.NotificationData `json:"data" yaml:"data"`
+}
+
+// NewEntry creates an entry with a new id for the failed notification
+func NewEntry(source string, notification string, errorMessage string, attempts int, data message.NotificationData) Entry {
+	return Entry{
+		Id:           uuid.NewV4().String(),
+		Time:         time.Now().UTC(),
+		Source:       source,
+		Notification: notification,
+		Error:        errorMessage,
+		Attempts:     attempts,
+		Data:         data,
+	}
+}
+
+// Store persists the notifications that failed to be sent
+type Store interface {
+	// Add stores the entry, replacing any existing entry with the same id
+	Add(ctx context.Context, entry Entry) error
+	// List returns all entries, oldest first
+	List(ctx context.Context) ([]Entry, error)
+	// Get returns the entry or an error when the entry does not exist
+	Get(ctx context.Context, id string) (*Entry, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// NewStore creates the store of the type of the configuration
+func NewStore(cfg *config.DeadLetterConfiguration) (Store, error) {
+	switch cfg.Type {
+	case "", config.DeadLetterStoreFile:
+		if cfg.Directory == "" {
+			return nil, fmt.Errorf("the dead letter directory was not specified")
+		}
+		return NewFileStore(cfg.Directory)
+	default:
+		return nil, fmt.Errorf("invalid dead letter store type '%s', the type must be file", cfg.Type)
+	}
+}
+
+var validId = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+// FileStore stores each entry as a json file in a directory
+type FileStore struct {
+	Directory string
+}
+
+func NewFileStore(directory string) (*FileStore, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the dead letter directory '%s' - %v", directory, err)
+	}
+	return &FileStore{
+		Directory: directory,
+	}, nil
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if !validId.MatchString(id) {
+		return "", fmt.Errorf("invalid dead letter id '%s'", id)
+	}
+	return filepath.Join(s.Directory, id+".json"), nil
+}
+
+func (s *FileStore) Add(ctx context.Context, entry Entry) error {
+	path, err := s.path(entry.Id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the dead letter entry '%s' - %v", entry.Id, err)
+	}
+	// write to a temporary file first so a partially written entry is never read
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write the dead letter entry '%s' - %v", entry.Id, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Entry, error) {
+	files, err := os.ReadDir(s.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the dead letter directory '%s' - %v", s.Directory, err)
+	}
+	entries := []Entry{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		entry, err := s.Get(ctx, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Entry, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("dead letter entry '%s' does not exist", id)
+		}
+		return nil, fmt.Errorf("failed to read the dead letter entry '%s' - %v", id, err)
+	}
+	entry := &Entry{}
+	err = json.Unmarshal(data, entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the dead letter entry '%s' - %v", id, err)
+	}
+	return entry, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete the dead letter entry '%s' - %v", id, err)
+	}
+	return nil
+}
+
+type ctxStoreKey struct{}
+
+// FromCtx returns the dead letter store stored in the context or nil when dead lettering is not enabled
+func FromCtx(ctx context.Context) Store {
+	if s, ok := ctx.Value(ctxStoreKey{}).(Store); ok {
+		return s
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, s Store) context.Context {
+	return context.WithValue(ctx, ctxStoreKey{}, s)
+}
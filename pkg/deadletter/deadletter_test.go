@@ -0,0 +1,112 @@
+package deadletter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	first := NewEntry("pubsub", "log", "failed", 3, message.NotificationData{
+		Data:       map[string]interface{}{"test": "123"},
+		Attributes: map[string]string{"att": "val"},
+		ID:         "1",
+	})
+	second := NewEntry("cloudevents", "github", "failed", 1, message.NotificationData{ID: "2"})
+	second.Time = first.Time.Add(time.Second)
+
+	for _, entry := range []Entry{second, first} {
+		err = store.Add(ctx, entry)
+		if err != nil {
+			t.Fatalf("FileStore.Add() error = %v", err)
+		}
+	}
+
+	entries, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("FileStore.List() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Id != first.Id || entries[1].Id != second.Id {
+		t.Fatalf("FileStore.List() = %v, want the entries ordered by time", entries)
+	}
+
+	got, err := store.Get(ctx, first.Id)
+	if err != nil {
+		t.Fatalf("FileStore.Get() error = %v", err)
+	}
+	if !got.Time.Equal(first.Time) || !reflect.DeepEqual(got.Data, first.Data) || got.Notification != "log" || got.Attempts != 3 {
+		t.Errorf("FileStore.Get() = %v, want %v", got, first)
+	}
+
+	err = store.Delete(ctx, first.Id)
+	if err != nil {
+		t.Fatalf("FileStore.Delete() error = %v", err)
+	}
+	_, err = store.Get(ctx, first.Id)
+	if err == nil {
+		t.Errorf("FileStore.Get() of a deleted entry should return an error")
+	}
+
+	_, err = store.Get(ctx, "../config")
+	if err == nil {
+		t.Errorf("FileStore.Get() with an invalid id should return an error")
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	directory := t.TempDir()
+	tests := []struct {
+		name    string
+		cfg     *config.DeadLetterConfiguration
+		want    Store
+		wantErr string
+	}{
+		{
+			name: "default",
+			cfg:  &config.DeadLetterConfiguration{Directory: directory},
+			want: &FileStore{Directory: directory},
+		},
+		{
+			name: "file",
+			cfg:  &config.DeadLetterConfiguration{Type: config.DeadLetterStoreFile, Directory: directory},
+			want: &FileStore{Directory: directory},
+		},
+		{
+			name:    "file without directory",
+			cfg:     &config.DeadLetterConfiguration{Type: config.DeadLetterStoreFile},
+			wantErr: "the dead letter directory was not specified",
+		},
+		{
+			name:    "invalid type",
+			cfg:     &config.DeadLetterConfiguration{Type: "database", Directory: directory},
+			wantErr: "invalid dead letter store type 'database', the type must be file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewStore(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("NewStore() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewStore() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewStore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
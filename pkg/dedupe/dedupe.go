@@ -0,0 +1,118 @@
+package dedupe
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+)
+
+// Store remembers the messages that were delivered to a notification. Shared stores can be implemented to deduplicate
+// messages across multiple instances
+type Store interface {
+	// Add records the key for the duration of the ttl. It returns false when the key was already recorded and has not
+	// expired. Adding a key must be atomic so concurrent deliveries of the same message are only sent once
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Remove forgets the key, it is used when the delivery failed so the message can be delivered again
+	Remove(ctx context.Context, key string) error
+}
+
+// NewStore creates the store of the type of the configuration
+func NewStore(cfg *config.DeduplicationConfiguration) (Store, error) {
+	switch cfg.Type {
+	case "", config.DeduplicationStoreMemory:
+		return NewMemoryStore(cfg.GetMaxEntries()), nil
+	case config.DeduplicationStoreFile:
+		if cfg.Directory == "" {
+			return nil, fmt.Errorf("the deduplication directory was not specified")
+		}
+		return NewFileStore(cfg.Directory)
+	default:
+		return nil, fmt.Errorf("invalid deduplication store type '%s', the type must be memory or file", cfg.Type)
+	}
+}
+
+// Key returns the deduplication key of a message delivered to a notification
+func Key(source string, id string, notification string) string {
+	return strings.Join([]string{source, id, notification}, "|")
+}
+
+type memoryEntry struct {
+	key     string
+	expires time.Time
+}
+
+// MemoryStore is a least recently used cache. When the cache is full, the least recently added key is removed
+type MemoryStore struct {
+	maxEntries int
+	mutex      sync.Mutex
+	entries    *list.List
+	keys       map[string]*list.Element
+	now        func() time.Time
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    list.New(),
+		keys:       map[string]*list.Element{},
+		now:        time.Now,
+	}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if element, exists := s.keys[key]; exists {
+		entry := element.Value.(*memoryEntry)
+		if now.Before(entry.expires) {
+			return false, nil
+		}
+		entry.expires = now.Add(ttl)
+		s.entries.MoveToFront(element)
+		return true, nil
+	}
+	s.keys[key] = s.entries.PushFront(&memoryEntry{key: key, expires: now.Add(ttl)})
+	for s.maxEntries > 0 && s.entries.Len() > s.maxEntries {
+		oldest := s.entries.Back()
+		s.entries.Remove(oldest)
+		delete(s.keys, oldest.Value.(*memoryEntry).key)
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) Remove(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if element, exists := s.keys[key]; exists {
+		s.entries.Remove(element)
+		delete(s.keys, key)
+	}
+	return nil
+}
+
+// Len returns the number of keys in the store, including expired keys that were not removed yet
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.entries.Len()
+}
+
+type ctxStoreKey struct{}
+
+// FromCtx returns the deduplication store stored in the context or nil when deduplication is not enabled
+func FromCtx(ctx context.Context) Store {
+	if s, ok := ctx.Value(ctxStoreKey{}).(Store); ok {
+		return s
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, s Store) context.Context {
+	return context.WithValue(ctx, ctxStoreKey{}, s)
+}
@@ -0,0 +1,108 @@
+package dedupe
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(2)
+	store.now = func() time.Time {
+		return now
+	}
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		key     string
+		remove  bool
+		want    bool
+	}{
+		{name: "first delivery", key: "a", want: true},
+		{name: "duplicate", key: "a", want: false},
+		{name: "other key", key: "b", want: true},
+		{name: "expired", advance: 2 * time.Minute, key: "a", want: true},
+		{name: "evicts the oldest key", key: "c", want: true},
+		{name: "evicted key is added again", key: "b", want: true},
+		{name: "removed", key: "c", remove: true},
+		{name: "removed key is added again", key: "c", want: true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.remove {
+			err := store.Remove(ctx, step.key)
+			if err != nil {
+				t.Errorf("%s: MemoryStore.Remove() error = %v", step.name, err)
+			}
+			continue
+		}
+		got, err := store.Add(ctx, step.key, time.Minute)
+		if err != nil {
+			t.Errorf("%s: MemoryStore.Add() error = %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: MemoryStore.Add() = %v, want %v", step.name, got, step.want)
+		}
+	}
+	if store.Len() != 2 {
+		t.Errorf("MemoryStore.Len() = %v, want 2", store.Len())
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *config.DeduplicationConfiguration
+		want    string
+		wantErr string
+	}{
+		{
+			name: "default",
+			cfg:  &config.DeduplicationConfiguration{},
+			want: "*dedupe.MemoryStore",
+		},
+		{
+			name: "memory",
+			cfg:  &config.DeduplicationConfiguration{Type: config.DeduplicationStoreMemory},
+			want: "*dedupe.MemoryStore",
+		},
+		{
+			name: "file",
+			cfg:  &config.DeduplicationConfiguration{Type: config.DeduplicationStoreFile, Directory: t.TempDir()},
+			want: "*dedupe.FileStore",
+		},
+		{
+			name:    "file without directory",
+			cfg:     &config.DeduplicationConfiguration{Type: config.DeduplicationStoreFile},
+			wantErr: "the deduplication directory was not specified",
+		},
+		{
+			name:    "invalid type",
+			cfg:     &config.DeduplicationConfiguration{Type: "redis"},
+			wantErr: "invalid deduplication store type 'redis', the type must be memory or file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewStore(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("NewStore() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewStore() error = %v", err)
+			}
+			if fmt.Sprintf("%T", got) != tt.want {
+				t.Errorf("NewStore() = %T, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package dedupe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// sweepInterval is how often the file store removes the expired keys
+const sweepInterval = time.Minute
+
+// FileStore records each key as a file containing its expiry, named with the hash of the key. The directory can be
+// shared by multiple instances, such as a shared volume, to deduplicate the messages across them. The files are
+// created exclusively so concurrent deliveries of the same message are only sent once
+type FileStore struct {
+	Directory string
+	mutex     sync.Mutex
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewFileStore(directory string) (*FileStore, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the deduplication directory '%s' - %v", directory, err)
+	}
+	return &FileStore{
+		Directory: directory,
+		now:       time.Now,
+	}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(s.Directory, hex.EncodeToString(hash[:])+".key")
+}
+
+func (s *FileStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	now := s.now()
+	s.sweep(now)
+	path := s.path(key)
+	// the key is created again once when it expired
+	for attempt := 0; attempt < 2; attempt++ {
+		created, err := create(path, now.Add(ttl))
+		if err != nil {
+			return false, fmt.Errorf("failed to add the deduplication key '%s' - %v", key, err)
+		}
+		if created {
+			return true, nil
+		}
+		removed, err := removeExpired(path, now)
+		if err != nil {
+			return false, fmt.Errorf("failed to replace the expired deduplication key '%s' - %v", key, err)
+		}
+		if !removed {
+			return false, nil
+		}
+	}
+	// another instance added the key in the meantime
+	return false, nil
+}
+
+func (s *FileStore) Remove(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the deduplication key '%s' - %v", key, err)
+	}
+	return nil
+}
+
+// sweep removes the expired keys, at most once per sweep interval, so the keys of the messages that are not received
+// again do not accumulate
+func (s *FileStore) sweep(now time.Time) {
+	s.mutex.Lock()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		s.mutex.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mutex.Unlock()
+	entries, err := os.ReadDir(s.Directory)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".key") {
+			removeExpired(filepath.Join(s.Directory, entry.Name()), now)
+		}
+	}
+}
+
+// create creates the file of the key with its expiry. It returns false when the file already exists
+func create(path string, expires time.Time) (bool, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = file.WriteString(expires.Format(time.RFC3339Nano))
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return false, err
+	}
+	return true, nil
+}
+
+// expired returns true when the expiry in the file passed. A file that is being written is not expired
+func expired(path string, now time.Time) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	expires, err := time.Parse(time.RFC3339Nano, string(data))
+	if err != nil {
+		return false, nil
+	}
+	return !now.Before(expires), nil
+}
+
+// removeExpired removes the file of the key when it expired. It returns true when the key no longer exists. The file
+// is first moved to a unique name so only one instance removes it, and it is moved back when another instance added the
+// key again in the meantime
+func removeExpired(path string, now time.Time) (bool, error) {
+	isExpired, err := expired(path, now)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !isExpired {
+		return false, nil
+	}
+	claimed := path + "." + uuid.NewV4().String()
+	err = os.Rename(path, claimed)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer os.Remove(claimed)
+	isExpired, err = expired(claimed, now)
+	if err != nil || isExpired {
+		return err == nil, err
+	}
+	// the key was added again, it is restored unless it was added once more
+	os.Link(claimed, path)
+	return false, nil
+}
@@ -0,0 +1,99 @@
+package dedupe
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	directory := t.TempDir()
+	store, err := NewFileStore(directory)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	store.now = func() time.Time {
+		return now
+	}
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		key     string
+		remove  bool
+		want    bool
+	}{
+		{name: "first delivery", key: "a", want: true},
+		{name: "duplicate", key: "a", want: false},
+		{name: "other key", key: "b", want: true},
+		{name: "expired", advance: 2 * time.Minute, key: "a", want: true},
+		{name: "expired key is a duplicate again", key: "a", want: false},
+		{name: "removed", key: "a", remove: true},
+		{name: "removed key is added again", key: "a", want: true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.remove {
+			err := store.Remove(ctx, step.key)
+			if err != nil {
+				t.Errorf("%s: FileStore.Remove() error = %v", step.name, err)
+			}
+			continue
+		}
+		got, err := store.Add(ctx, step.key, time.Minute)
+		if err != nil {
+			t.Errorf("%s: FileStore.Add() error = %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: FileStore.Add() = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// the expired key b is removed by the sweep
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("FileStore has %v files, want 1", len(entries))
+	}
+}
+
+func TestFileStore_Shared(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	stores := []*FileStore{}
+	for i := 0; i < 2; i++ {
+		store, err := NewFileStore(directory)
+		if err != nil {
+			t.Fatalf("NewFileStore() error = %v", err)
+		}
+		stores = append(stores, store)
+	}
+
+	// the same message delivered concurrently to both instances is only added once
+	var added int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(store *FileStore) {
+			defer wg.Done()
+			ok, err := store.Add(ctx, "pub/sub|1|log", time.Minute)
+			if err != nil {
+				t.Errorf("FileStore.Add() error = %v", err)
+			}
+			if ok {
+				atomic.AddInt32(&added, 1)
+			}
+		}(stores[i%2])
+	}
+	wg.Wait()
+	if added != 1 {
+		t.Errorf("FileStore.Add() added the key %v times, want 1", added)
+	}
+}
@@ -0,0 +1,101 @@
+package digest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+// FlushFunc sends the messages collected for a key
+type FlushFunc func(key string, messages []*message.NotificationData)
+
+type group struct {
+	messages []*message.NotificationData
+	flush    FlushFunc
+	timer    *time.Timer
+}
+
+// Aggregator collects messages per key until the window of the key ends or the maximum number of messages is reached
+type Aggregator struct {
+	mutex  sync.Mutex
+	groups map[string]*group
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		groups: map[string]*group{},
+	}
+}
+
+// Add collects the message. The window of the key starts with its first message. When maxMessages is greater than 0
+// and is reached, the messages are flushed right away
+func (a *Aggregator) Add(key string, data *message.NotificationData, window time.Duration, maxMessages int, flush FlushFunc) {
+	a.mutex.Lock()
+	g, exists := a.groups[key]
+	if !exists {
+		g = &group{flush: flush}
+		a.groups[key] = g
+		g.timer = time.AfterFunc(window, func() {
+			a.flush(key, g)
+		})
+	}
+	g.messages = append(g.messages, data)
+	full := maxMessages > 0 && len(g.messages) >= maxMessages
+	a.mutex.Unlock()
+
+	if full {
+		a.flush(key, g)
+	}
+}
+
+// flush sends the messages of the group unless the group was already flushed
+func (a *Aggregator) flush(key string, g *group) {
+	a.mutex.Lock()
+	if a.groups[key] != g {
+		a.mutex.Unlock()
+		return
+	}
+	delete(a.groups, key)
+	g.timer.Stop()
+	a.mutex.Unlock()
+	g.flush(key, g.messages)
+}
+
+// Flush sends every pending digest without waiting for the windows to end
+func (a *Aggregator) Flush() {
+	a.mutex.Lock()
+	groups := map[string]*group{}
+	for key, g := range a.groups {
+		groups[key] = g
+	}
+	a.mutex.Unlock()
+	for key, g := range groups {
+		a.flush(key, g)
+	}
+}
+
+// Pending returns the number of messages collected for the key
+func (a *Aggregator) Pending(key string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if g, exists := a.groups[key]; exists {
+		return len(g.messages)
+	}
+	return 0
+}
+
+type ctxAggregatorKey struct{}
+
+// FromCtx returns the aggregator stored in the context or nil when no notification uses a digest
+func FromCtx(ctx context.Context) *Aggregator {
+	if a, ok := ctx.Value(ctxAggregatorKey{}).(*Aggregator); ok {
+		return a
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, a *Aggregator) context.Context {
+	return context.WithValue(ctx, ctxAggregatorKey{}, a)
+}
@@ -0,0 +1,65 @@
+package digest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+type flushed struct {
+	mutex  sync.Mutex
+	keys   []string
+	counts []int
+}
+
+func (f *flushed) flush(key string, messages []*message.NotificationData) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.keys = append(f.keys, key)
+	f.counts = append(f.counts, len(messages))
+}
+
+func (f *flushed) len() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.keys)
+}
+
+func TestAggregator_MaxMessages(t *testing.T) {
+	a := NewAggregator()
+	f := &flushed{}
+	for _, id := range []string{"1", "2", "3"} {
+		a.Add("a", &message.NotificationData{ID: id}, time.Hour, 2, f.flush)
+	}
+	if f.len() != 1 || f.counts[0] != 2 {
+		t.Errorf("Aggregator.Add() flushed %v, want one digest of 2 messages", f.counts)
+	}
+	if a.Pending("a") != 1 {
+		t.Errorf("Aggregator.Pending() = %v, want 1", a.Pending("a"))
+	}
+	a.Flush()
+	if f.len() != 2 || f.counts[1] != 1 || a.Pending("a") != 0 {
+		t.Errorf("Aggregator.Flush() flushed %v, want the remaining message", f.counts)
+	}
+}
+
+func TestAggregator_Window(t *testing.T) {
+	a := NewAggregator()
+	f := &flushed{}
+	a.Add("a", &message.NotificationData{ID: "1"}, 50*time.Millisecond, 0, f.flush)
+	a.Add("b", &message.NotificationData{ID: "2"}, time.Hour, 0, f.flush)
+	a.Add("a", &message.NotificationData{ID: "3"}, 50*time.Millisecond, 0, f.flush)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.len() != 1 || f.keys[0] != "a" || f.counts[0] != 2 {
+		t.Errorf("Aggregator.Add() flushed %v %v, want the 2 messages of key 'a' when the window ends", f.keys, f.counts)
+	}
+	if a.Pending("b") != 1 {
+		t.Errorf("Aggregator.Pending() = %v, want 1", a.Pending("b"))
+	}
+}
@@ -0,0 +1,72 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kcloutie/knot/pkg/clock"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/correlation"
+	"github.com/kcloutie/knot/pkg/matcher"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// correlate loads the state of the fingerprint of the notification. It returns a copy of the message with the state as
+// previous, an empty previous when the fingerprint has no state yet. The message is returned as is when the notification
+// is not correlated. A fingerprint that fails to render is an error, the messages would share the same state otherwise
+func correlate(ctx context.Context, not config.Notification, notifyData *message.NotificationData) (*message.NotificationData, string, error) {
+	store := correlation.FromCtx(ctx)
+	if not.Correlation == nil || store == nil {
+		return notifyData, "", nil
+	}
+	fingerprint, err := renderTemplateKey(ctx, not, not.Correlation.Fingerprint, notifyData)
+	if err != nil {
+		return notifyData, "", fmt.Errorf("failed to render the fingerprint - %v", err)
+	}
+	state, err := store.Get(ctx, fingerprint)
+	if err != nil {
+		return notifyData, "", err
+	}
+	correlated := *notifyData
+	correlated.Previous = map[string]interface{}{}
+	if state != nil {
+		correlated.Previous = state.AsMap()
+	}
+	return &correlated, fingerprint, nil
+}
+
+// correlatesBeforeMatching returns true when the filter of the notification uses previous. The state is otherwise only
+// loaded once the notification matches so the other messages do not read the store
+func correlatesBeforeMatching(not config.Notification) bool {
+	if not.Correlation == nil || not.CelExpressionFilter == "" {
+		return false
+	}
+	// an invalid filter is reported when the message is matched
+	uses, _ := message.CelEnvironment().References(not.CelExpressionFilter, "previous")
+	return uses
+}
+
+// track updates the state of the fingerprint once the notification was sent, with the handles recorded by the provider.
+// The state is read again as other messages with the same fingerprint may have been sent since it was loaded
+func track(ctx context.Context, log *zap.Logger, not config.Notification, fingerprint string, notifyData *message.NotificationData) {
+	store := correlation.FromCtx(ctx)
+	if fingerprint == "" || store == nil {
+		return
+	}
+	status := correlation.StatusFiring
+	resolved, err := matcher.MatchesExpression(ctx, not.Correlation.ResolvedExpression, notifyData)
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to evaluate the resolved expression of notification '%s', the message is considered firing - %v", not.Name, err))
+	} else if resolved && not.Correlation.ResolvedExpression != "" {
+		status = correlation.StatusResolved
+	}
+	now := clock.Now(ctx).UTC()
+	handles := correlation.GetHandles(ctx)
+	err = correlation.Update(ctx, store, fingerprint, func(state *correlation.State) correlation.State {
+		return state.Next(fingerprint, not.Name, status, now, handles)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to store the state of fingerprint '%s' - %v", fingerprint, err))
+	}
+}
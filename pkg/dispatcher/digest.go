@@ -0,0 +1,53 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/digest"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// collect adds the message to the digest of the notification. The digest is delivered once its window ends or the
+// maximum number of messages is reached
+func collect(ctx context.Context, log *zap.Logger, source Source, not config.Notification, notifyData *message.NotificationData) error {
+	aggregator := digest.FromCtx(ctx)
+	if aggregator == nil {
+		return fmt.Errorf("digests are not enabled")
+	}
+	window, err := not.Digest.GetWindow()
+	if err != nil {
+		return err
+	}
+	key := renderKey(ctx, log, not, not.Digest.Key, notifyData)
+	aggregator.Add(key, notifyData, window, not.Digest.MaxMessages, func(key string, messages []*message.NotificationData) {
+		digestData := NewDigestData(key, messages)
+		result := deliver(ctx, log, source, not, digestData)
+		log.Debug(fmt.Sprintf("digest of notification '%s' with %v messages delivered", not.Name, len(messages)), zap.Any("result", result))
+	})
+	log.Sugar().Debugf("message '%s' collected in the digest '%s'", notifyData.ID, key)
+	return nil
+}
+
+// NewDigestData creates the message of a digest. The collected messages are available in .data.messages
+func NewDigestData(key string, messages []*message.NotificationData) *message.NotificationData {
+	collected := []interface{}{}
+	for _, m := range messages {
+		collected = append(collected, m.AsMap())
+	}
+	id := key
+	if len(messages) > 0 {
+		id = fmt.Sprintf("%s/%s", key, messages[0].ID)
+	}
+	return &message.NotificationData{
+		ID: id,
+		Data: map[string]interface{}{
+			"messages": collected,
+			"count":    len(messages),
+			"key":      key,
+		},
+		Attributes: map[string]string{},
+	}
+}
@@ -0,0 +1,512 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/adapter"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/correlation"
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/dedupe"
+	"github.com/kcloutie/knot/pkg/listener"
+	"github.com/kcloutie/knot/pkg/matcher"
+	"github.com/kcloutie/knot/pkg/message"
+	"github.com/kcloutie/knot/pkg/provider"
+	"github.com/kcloutie/knot/pkg/ratelimit"
+	"github.com/kcloutie/knot/pkg/retry"
+	"github.com/kcloutie/knot/pkg/template"
+	"go.uber.org/zap"
+)
+
+// Source is where a message was received from, typically a listener
+type Source interface {
+	GetName() string
+	GetApiPath() string
+}
+
+const (
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+	// StatusDuplicate is used when the message was already delivered to the notification
+	StatusDuplicate = "duplicate"
+	// StatusThrottled is used when the message was over the rate limit of the notification and was not sent
+	StatusThrottled = "throttled"
+	// StatusDigested is used when the message was collected in a digest that is sent later
+	StatusDigested = "digested"
+	// StatusSilenced is used when the notification was muted by an active silence
+	StatusSilenced = "silenced"
+)
+
+const (
+	// ResponsePolicyAllSucceeded returns a 200 when no notification failed, otherwise a 500
+	ResponsePolicyAllSucceeded = config.ResponsePolicyAllSucceeded
+	// ResponsePolicyAnySucceeded returns a 200 when at least one notification was sent or none failed, otherwise a 500
+	ResponsePolicyAnySucceeded = config.ResponsePolicyAnySucceeded
+	// ResponsePolicyMultiStatus returns a 200 when no notification failed, a 207 when some failed and a 500 when all failed
+	ResponsePolicyMultiStatus = config.ResponsePolicyMultiStatus
+	// ResponsePolicyAlwaysOk always returns a 200, failures are only reported in the response body
+	ResponsePolicyAlwaysOk = config.ResponsePolicyAlwaysOk
+)
+
+// NotificationResult is the outcome of a notification that matched a message
+type NotificationResult struct {
+	Name   string `json:"name" yaml:"name"`
+	Status string `json:"status" yaml:"status"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+	// Attempts is the number of times the provider was called
+	Attempts int `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	// Fallbacks are the results of the fallback notifications sent because the notification failed
+	Fallbacks []NotificationResult `json:"fallbacks,omitempty" yaml:"fallbacks,omitempty"`
+}
+
+// Dispatch matches the message against the configured notifications, or the receivers selected by the routing tree,
+// and sends every notification that matches. Each notification is attempted independently so a failing notification
+// does not prevent the others from being sent. The results of the matching notifications are returned
+func Dispatch(ctx context.Context, log *zap.Logger, source Source, notifyData *message.NotificationData) []NotificationResult {
+	cfg := config.FromCtx(ctx)
+	slog := log.Sugar()
+
+	if len(cfg.Notifications) == 0 {
+		slog.Warnf("no notifications configured for listener '%s'", source.GetName())
+	}
+
+	notifyData = enrich(ctx, log, notifyData)
+	observe(ctx, log, notifyData)
+	notifications, results := getNotifications(ctx, log, notifyData)
+	restrictor, isRestricted := source.(listener.NotificationRestrictor)
+	for _, not := range notifications {
+		if isRestricted && !restrictor.AllowsNotification(not.Name) {
+			slog.Debugf("listener '%s' is not allowed to trigger notification '%s'", source.GetName(), not.Name)
+			continue
+		}
+		// the message of the notification (meta, correlation state, variables) shadows the received message
+		notifyData := notifyData.WithNotification(not.Name)
+		fingerprint := ""
+		correlated := correlatesBeforeMatching(not)
+		if correlated {
+			var err error
+			notifyData, fingerprint, err = correlate(ctx, not, notifyData)
+			if err != nil {
+				results = append(results, correlationFailed(log, not, err))
+				continue
+			}
+		}
+		matches, err := matcher.Matches(ctx, not, notifyData)
+		if err != nil {
+			err = fmt.Errorf("failed to match the message against the '%s' notification - %v", not.Name, err)
+			log.Error(err.Error())
+			results = append(results, NotificationResult{Name: not.Name, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+		if !matches {
+			slog.Debugf("notification '%s' does not match message", not.Name)
+			continue
+		}
+		if !correlated {
+			notifyData, fingerprint, err = correlate(ctx, not, notifyData)
+			if err != nil {
+				results = append(results, correlationFailed(log, not, err))
+				continue
+			}
+		}
+		notifyData, err = evaluateVariables(ctx, not, notifyData)
+		if err != nil {
+			err = fmt.Errorf("failed to evaluate the variables of the '%s' notification - %v", not.Name, err)
+			log.Error(err.Error())
+			results = append(results, NotificationResult{Name: not.Name, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+		silenced, err := matcher.Silenced(ctx, not, notifyData)
+		if err != nil {
+			err = fmt.Errorf("failed to evaluate the silences of the '%s' notification - %v", not.Name, err)
+			log.Error(err.Error())
+			results = append(results, NotificationResult{Name: not.Name, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+		if silenced != nil {
+			slog.Infof("notification '%s' is silenced by silence '%s', skipping", not.Name, silenced.Id)
+			results = append(results, NotificationResult{Name: not.Name, Status: StatusSilenced})
+			continue
+		}
+		dedupeKey, isDuplicate := reserve(ctx, log, source, not, notifyData)
+		if isDuplicate {
+			slog.Infof("message '%s' was already delivered to notification '%s', skipping", notifyData.ID, not.Name)
+			results = append(results, NotificationResult{Name: not.Name, Status: StatusDuplicate})
+			continue
+		}
+		if not.Digest != nil {
+			err := collect(ctx, log, source, not, notifyData)
+			if err == nil {
+				results = append(results, NotificationResult{Name: not.Name, Status: StatusDigested})
+				continue
+			}
+			slog.Errorf("failed to collect the message in the digest of notification '%s', sending it right away - %v", not.Name, err)
+		}
+		sendCtx := ctx
+		if fingerprint != "" {
+			sendCtx = correlation.WithHandles(ctx)
+		}
+		esc, escalatedData := startEscalation(ctx, log, not, notifyData)
+		result := deliver(sendCtx, log, source, not, escalatedData)
+		if result.Status != StatusSent {
+			if !FallbackSent(result) {
+				release(ctx, log, dedupeKey)
+			}
+		} else {
+			track(sendCtx, log, not, fingerprint, notifyData)
+			escalate(ctx, log, not, esc, notifyData)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func correlationFailed(log *zap.Logger, not config.Notification, err error) NotificationResult {
+	err = fmt.Errorf("failed to correlate the '%s' notification - %v", not.Name, err)
+	log.Error(err.Error())
+	return NotificationResult{Name: not.Name, Status: StatusFailed, Error: err.Error()}
+}
+
+// deliver applies the rate limit of the notification and sends it. When the notification fails, its fallbacks are sent
+// and, when none of them is sent, the notification is stored in the dead letter store
+func deliver(ctx context.Context, log *zap.Logger, source Source, not config.Notification, notifyData *message.NotificationData) NotificationResult {
+	if !allow(ctx, log, not, notifyData) {
+		return NotificationResult{Name: not.Name, Status: StatusThrottled}
+	}
+	result := send(ctx, log, not, notifyData)
+	if result.Status == StatusFailed {
+		result.Fallbacks = sendFallbacks(ctx, log, not, result, notifyData)
+		if !FallbackSent(result) {
+			deadLetter(ctx, log, source, result, notifyData)
+		}
+	}
+	return result
+}
+
+// sendFallbacks sends the fallbacks of the failed notification, in order, until one of them is sent. The failure is
+// available to the templates of the fallbacks as .failure
+func sendFallbacks(ctx context.Context, log *zap.Logger, not config.Notification, failed NotificationResult, notifyData *message.NotificationData) []NotificationResult {
+	if len(not.Fallbacks) == 0 {
+		return nil
+	}
+	results := []NotificationResult{}
+	fallbackData := *notifyData
+	fallbackData.Failure = map[string]interface{}{
+		"notification": not.Name,
+		"error":        failed.Error,
+		"attempts":     failed.Attempts,
+	}
+	for _, name := range not.Fallbacks {
+		fallback, exists := config.FromCtx(ctx).GetNotification(name)
+		if !exists {
+			err := fmt.Errorf("fallback notification '%s' of notification '%s' does not exist", name, not.Name)
+			log.Error(err.Error())
+			results = append(results, NotificationResult{Name: name, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+		log.Sugar().Infof("notification '%s' failed, sending fallback notification '%s'", not.Name, name)
+		if !allow(ctx, log, fallback, &fallbackData) {
+			results = append(results, NotificationResult{Name: name, Status: StatusThrottled})
+			continue
+		}
+		result := send(ctx, log, fallback, &fallbackData)
+		results = append(results, result)
+		if result.Status == StatusSent {
+			break
+		}
+	}
+	return results
+}
+
+// FallbackSent returns true when one of the fallbacks of the failed notification was sent
+func FallbackSent(result NotificationResult) bool {
+	for _, fallback := range result.Fallbacks {
+		if fallback.Status == StatusSent {
+			return true
+		}
+	}
+	return false
+}
+
+// renderKey renders the key template with the message. The key is prefixed with the notification name so each
+// notification has its own keys. When the template is empty or fails to render, the notification name is returned
+func renderKey(ctx context.Context, log *zap.Logger, not config.Notification, keyTemplate string, notifyData *message.NotificationData) string {
+	if keyTemplate == "" {
+		return not.Name
+	}
+	key, err := renderTemplateKey(ctx, not, keyTemplate, notifyData)
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to render the key of notification '%s', using the notification name - %v", not.Name, err))
+		return not.Name
+	}
+	return key
+}
+
+// renderTemplateKey renders the key template with the message and prefixes it with the notification name
+func renderTemplateKey(ctx context.Context, not config.Notification, keyTemplate string, notifyData *message.NotificationData) (string, error) {
+	renderedKey, err := template.RenderTemplateValues(ctx, keyTemplate, fmt.Sprintf("%s_%s/key", notifyData.ID, not.Name), notifyData.AsMap(), []string{}, template.NewRenderTemplateOptions())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s|%s", not.Name, string(renderedKey)), nil
+}
+
+// reserve records the delivery of the message to the notification when deduplication is enabled. It returns the
+// deduplication key and whether the message was already delivered to the notification
+func reserve(ctx context.Context, log *zap.Logger, source Source, not config.Notification, notifyData *message.NotificationData) (string, bool) {
+	store := dedupe.FromCtx(ctx)
+	if store == nil || notifyData.ID == "" {
+		return "", false
+	}
+	ttl := time.Hour
+	if cfg := config.FromCtx(ctx).Deduplication; cfg != nil {
+		var err error
+		ttl, err = cfg.GetTTL()
+		if err != nil {
+			log.Error(fmt.Sprintf("invalid deduplication ttl, using the default - %v", err))
+			ttl = time.Hour
+		}
+	}
+	key := dedupe.Key(source.GetName(), notifyData.ID, not.Name)
+	added, err := store.Add(ctx, key, ttl)
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to check if the message was already delivered, sending it anyway - %v", err))
+		return "", false
+	}
+	return key, !added
+}
+
+// allow applies the rate limit of the notification. It returns false when the message must not be sent. With the
+// delay action, it waits until the message can be sent
+func allow(ctx context.Context, log *zap.Logger, not config.Notification, notifyData *message.NotificationData) bool {
+	limiter := ratelimit.FromCtx(ctx)
+	if not.RateLimit == nil || limiter == nil {
+		return true
+	}
+	slog := log.Sugar()
+	rl := not.RateLimit
+	period, err := rl.GetPeriod()
+	if err == nil && (rl.Limit <= 0 || period <= 0) {
+		err = fmt.Errorf("the limit and period must be greater than 0")
+	}
+	if err != nil {
+		slog.Errorf("invalid rate limit for notification '%s', the rate limit is ignored - %v", not.Name, err)
+		return true
+	}
+	maxDelay := time.Duration(0)
+	if rl.GetAction() == config.RateLimitActionDelay {
+		maxDelay, err = rl.GetMaxDelay()
+		if err != nil {
+			slog.Errorf("invalid rate limit for notification '%s', the rate limit is ignored - %v", not.Name, err)
+			return true
+		}
+	}
+
+	key := renderKey(ctx, log, not, rl.Key, notifyData)
+
+	allowed, wait := limiter.Reserve(key, rl.Limit, period, maxDelay)
+	if allowed {
+		if wait == 0 {
+			limiter.Count(not.Name, func(c *ratelimit.Counters) { c.Allowed++ })
+			return true
+		}
+		limiter.Count(not.Name, func(c *ratelimit.Counters) { c.Delayed++ })
+		slog.Infof("notification '%s' is over the rate limit, delaying the message by %v", not.Name, wait)
+		select {
+		case <-ctx.Done():
+			limiter.Cancel(key)
+			return false
+		case <-time.After(wait):
+			return true
+		}
+	}
+
+	if rl.GetAction() == config.RateLimitActionSummarize && rl.SummaryNotification != "" {
+		limiter.Count(not.Name, func(c *ratelimit.Counters) { c.Suppressed++ })
+		slog.Infof("notification '%s' is over the rate limit, the message will be summarized", not.Name)
+		limiter.Suppress(key, notifyData, wait, func(count int, last *message.NotificationData) {
+			sendSummary(ctx, log, not, key, count, last)
+		})
+		return false
+	}
+	limiter.Count(not.Name, func(c *ratelimit.Counters) { c.Dropped++ })
+	slog.Infof("notification '%s' is over the rate limit, dropping the message", not.Name)
+	return false
+}
+
+// sendSummary sends the summary notification of the messages suppressed by the rate limit of the notification
+func sendSummary(ctx context.Context, log *zap.Logger, not config.Notification, key string, count int, last *message.NotificationData) {
+	summaryNot, exists := config.FromCtx(ctx).GetNotification(not.RateLimit.SummaryNotification)
+	if !exists {
+		log.Error(fmt.Sprintf("the summary notification '%s' of notification '%s' does not exist", not.RateLimit.SummaryNotification, not.Name))
+		return
+	}
+	summaryData := &message.NotificationData{
+		ID: fmt.Sprintf("%s/suppressed/%v", last.ID, count),
+		Data: map[string]interface{}{
+			"suppressed":   count,
+			"key":          key,
+			"notification": not.Name,
+			"message":      last.AsMap(),
+		},
+		Attributes: last.Attributes,
+	}
+	result := send(ctx, log, summaryNot, summaryData)
+	if result.Status == StatusSent {
+		ratelimit.FromCtx(ctx).Count(not.Name, func(c *ratelimit.Counters) { c.Summaries++ })
+	}
+}
+
+// release removes the deduplication key of a failed delivery so the message can be delivered again
+func release(ctx context.Context, log *zap.Logger, key string) {
+	if key == "" {
+		return
+	}
+	err := dedupe.FromCtx(ctx).Remove(ctx, key)
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to remove the deduplication key '%s' - %v", key, err))
+	}
+}
+
+// deadLetter stores the failed notification when a dead letter store is configured so it can be replayed later
+func deadLetter(ctx context.Context, log *zap.Logger, source Source, result NotificationResult, notifyData *message.NotificationData) {
+	store := deadletter.FromCtx(ctx)
+	if store == nil {
+		return
+	}
+	entry := deadletter.NewEntry(source.GetName(), result.Name, result.Error, result.Attempts, *notifyData)
+	err := store.Add(ctx, entry)
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to store the '%s' notification in the dead letter store - %v", result.Name, err))
+		return
+	}
+	log.Info(fmt.Sprintf("notification '%s' stored in the dead letter store", result.Name), zap.String("deadLetterId", entry.Id))
+}
+
+// Replay sends a dead letter entry again using the notification of the current configuration with the same name. The
+// notification filter is not evaluated again. The entry is removed from the store when the notification is sent,
+// otherwise the error and attempts of the entry are updated
+func Replay(ctx context.Context, log *zap.Logger, store deadletter.Store, entry deadletter.Entry) NotificationResult {
+	log = log.With(zap.String("deadLetterId", entry.Id))
+	var result NotificationResult
+	not, exists := config.FromCtx(ctx).GetNotification(entry.Notification)
+	if !exists {
+		err := fmt.Errorf("notification '%s' does not exist in the current configuration", entry.Notification)
+		log.Error(err.Error())
+		result = NotificationResult{Name: entry.Notification, Status: StatusFailed, Error: err.Error()}
+	} else {
+		result = send(ctx, log, not, &entry.Data)
+	}
+
+	if result.Status == StatusSent {
+		err := store.Delete(ctx, entry.Id)
+		if err != nil {
+			log.Error(err.Error())
+		}
+		return result
+	}
+	entry.Error = result.Error
+	entry.Attempts += result.Attempts
+	err := store.Add(ctx, entry)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	return result
+}
+
+func send(ctx context.Context, log *zap.Logger, not config.Notification, notifyData *message.NotificationData) NotificationResult {
+	slog := log.Sugar()
+	notifyData = notifyData.WithNotification(not.Name)
+	proNewFunc, exists := adapter.GetProviders()[not.Type]
+	if !exists {
+		err := fmt.Errorf("notification type of '%s' does not exist. Check the notification type of the '%s' notification", not.Type, not.Name)
+		log.Error(err.Error())
+		return NotificationResult{Name: not.Name, Status: StatusFailed, Error: err.Error()}
+	}
+	policy, err := getRetryPolicy(not)
+	if err != nil {
+		err = fmt.Errorf("invalid retry policy for the '%s' notification - %v", not.Name, err)
+		log.Error(err.Error())
+		return NotificationResult{Name: not.Name, Status: StatusFailed, Error: err.Error()}
+	}
+	// the steps that succeeded are shared by the attempts so a retry does not repeat them
+	attempts, err := retry.Do(provider.WithSteps(ctx), policy, provider.IsRetryable, func(ctx context.Context) error {
+		pro := proNewFunc(log, not)
+		slog.Debugf("sending notification '%s' to provider '%s'", not.Name, pro.GetName())
+		err := pro.SendNotification(ctx, notifyData)
+		if err != nil {
+			slog.Warnf("failed to send notification '%s' - %v", not.Name, err)
+		}
+		return err
+	})
+	if err != nil {
+		log.Error(err.Error(), zap.Int("attempts", attempts))
+		return NotificationResult{Name: not.Name, Status: StatusFailed, Error: err.Error(), Attempts: attempts}
+	}
+	slog.Debugf("notification '%s' sent after %v attempt(s)", not.Name, attempts)
+	return NotificationResult{Name: not.Name, Status: StatusSent, Attempts: attempts}
+}
+
+// getRetryPolicy returns the retry policy of the notification. Notifications without a retry policy are attempted once
+func getRetryPolicy(not config.Notification) (retry.Policy, error) {
+	if not.Retry == nil {
+		return retry.Policy{MaxAttempts: 1}, nil
+	}
+	initialBackoff, err := not.Retry.GetInitialBackoff()
+	if err != nil {
+		return retry.Policy{}, err
+	}
+	maxBackoff, err := not.Retry.GetMaxBackoff()
+	if err != nil {
+		return retry.Policy{}, err
+	}
+	deadline, err := not.Retry.GetDeadline()
+	if err != nil {
+		return retry.Policy{}, err
+	}
+	return retry.Policy{
+		MaxAttempts:    not.Retry.GetMaxAttempts(),
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+		Jitter:         not.Retry.GetJitter(),
+		Deadline:       deadline,
+	}, nil
+}
+
+// CountResults returns the number of notifications that were sent and that failed. A failed notification with a
+// fallback that was sent is counted as sent
+func CountResults(results []NotificationResult) (int, int) {
+	sent := 0
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Status == StatusSent || (result.Status == StatusFailed && FallbackSent(result)):
+			sent++
+		case result.Status == StatusFailed:
+			failed++
+		}
+	}
+	return sent, failed
+}
+
+// GetResponseStatus returns the http status to respond with based on the response policy
+func GetResponseStatus(policy string, sent int, failed int) int {
+	if failed == 0 {
+		return 200
+	}
+	switch policy {
+	case ResponsePolicyAlwaysOk:
+		return 200
+	case ResponsePolicyAnySucceeded:
+		if sent > 0 {
+			return 200
+		}
+	case ResponsePolicyMultiStatus:
+		if sent > 0 {
+			return 207
+		}
+	}
+	return 500
+}
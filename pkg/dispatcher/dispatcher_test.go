@@ -0,0 +1,611 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/correlation"
+	"github.com/kcloutie/knot/pkg/deadletter"
+	"github.com/kcloutie/knot/pkg/dedupe"
+	"github.com/kcloutie/knot/pkg/digest"
+	"github.com/kcloutie/knot/pkg/message"
+	"github.com/kcloutie/knot/pkg/ratelimit"
+	"github.com/kcloutie/knot/pkg/retry"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestGetResponseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		sent   int
+		failed int
+		want   int
+	}{
+		{name: "no failures", policy: ResponsePolicyAllSucceeded, sent: 2, want: 200},
+		{name: "nothing matched", policy: ResponsePolicyAllSucceeded, want: 200},
+		{name: "all succeeded with a failure", policy: ResponsePolicyAllSucceeded, sent: 1, failed: 1, want: 500},
+		{name: "any succeeded with a failure", policy: ResponsePolicyAnySucceeded, sent: 1, failed: 1, want: 200},
+		{name: "any succeeded all failed", policy: ResponsePolicyAnySucceeded, failed: 2, want: 500},
+		{name: "multi status partial failure", policy: ResponsePolicyMultiStatus, sent: 1, failed: 1, want: 207},
+		{name: "multi status all failed", policy: ResponsePolicyMultiStatus, failed: 1, want: 500},
+		{name: "always ok", policy: ResponsePolicyAlwaysOk, failed: 1, want: 200},
+		{name: "unknown policy", policy: "dude", sent: 1, failed: 1, want: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetResponseStatus(tt.policy, tt.sent, tt.failed); got != tt.want {
+				t.Errorf("GetResponseStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	jitter := 0.5
+	tests := []struct {
+		name    string
+		retry   *config.RetryPolicy
+		want    retry.Policy
+		wantErr bool
+	}{
+		{
+			name: "no retry policy",
+			want: retry.Policy{MaxAttempts: 1},
+		},
+		{
+			name:  "defaults",
+			retry: &config.RetryPolicy{},
+			want:  retry.Policy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second, Jitter: 0.2},
+		},
+		{
+			name:  "configured",
+			retry: &config.RetryPolicy{MaxAttempts: 5, InitialBackoff: "100ms", MaxBackoff: "2s", Jitter: &jitter, Deadline: "1m"},
+			want:  retry.Policy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second, Jitter: 0.5, Deadline: time.Minute},
+		},
+		{
+			name:    "invalid duration",
+			retry:   &config.RetryPolicy{MaxBackoff: "dude"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getRetryPolicy(config.Notification{Name: "test", Retry: tt.retry})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getRetryPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getRetryPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadLetterAndReplay(t *testing.T) {
+	mess := "hello {{ .data.test }}"
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name: "broken",
+				Type: "does-not-exist",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+			},
+		},
+	}
+	store, err := deadletter.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	ctx := deadletter.WithCtx(config.WithCtx(context.Background(), cfg), store)
+	log := zaptest.NewLogger(t)
+	data := &message.NotificationData{
+		ID:         "1",
+		Data:       map[string]interface{}{"test": "123"},
+		Attributes: map[string]string{},
+	}
+
+	results := Dispatch(ctx, log, testSource{}, data)
+	if len(results) != 1 || results[0].Status != StatusFailed {
+		t.Fatalf("Dispatch() = %v, want a failed result", results)
+	}
+	entries, _ := store.List(ctx)
+	if len(entries) != 1 || entries[0].Notification != "broken" || entries[0].Source != "test" || entries[0].Data.ID != "1" {
+		t.Fatalf("Dispatch() dead letter entries = %v, want the failed notification", entries)
+	}
+
+	result := Replay(ctx, log, store, entries[0])
+	if result.Status != StatusFailed {
+		t.Errorf("Replay() = %v, want a failed result while the notification is broken", result)
+	}
+	entries, _ = store.List(ctx)
+	if len(entries) != 1 {
+		t.Fatalf("Replay() should keep the failed entry, got %v", entries)
+	}
+
+	cfg.Notifications[0].Type = "log"
+	result = Replay(ctx, log, store, entries[0])
+	if result.Status != StatusSent {
+		t.Errorf("Replay() = %v, want a sent result", result)
+	}
+	entries, _ = store.List(ctx)
+	if len(entries) != 0 {
+		t.Errorf("Replay() should remove the sent entry, got %v", entries)
+	}
+}
+
+func TestDispatchDeduplication(t *testing.T) {
+	mess := "hello {{ .data.test }}"
+	cfg := &config.ServerConfiguration{
+		Deduplication: &config.DeduplicationConfiguration{TTL: "1m"},
+		Notifications: []config.Notification{
+			{
+				Name: "log",
+				Type: "log",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+			},
+			{
+				Name: "broken",
+				Type: "does-not-exist",
+			},
+		},
+	}
+	ctx := dedupe.WithCtx(config.WithCtx(context.Background(), cfg), dedupe.NewMemoryStore(10))
+	log := zaptest.NewLogger(t)
+	newData := func(id string) *message.NotificationData {
+		return &message.NotificationData{ID: id, Data: map[string]interface{}{"test": "123"}, Attributes: map[string]string{}}
+	}
+
+	tests := []struct {
+		name string
+		data *message.NotificationData
+		want []string
+	}{
+		{name: "first delivery", data: newData("1"), want: []string{StatusSent, StatusFailed}},
+		{name: "redelivery skips sent notifications", data: newData("1"), want: []string{StatusDuplicate, StatusFailed}},
+		{name: "other message", data: newData("2"), want: []string{StatusSent, StatusFailed}},
+		{name: "messages without an id are not deduplicated", data: newData(""), want: []string{StatusSent, StatusFailed}},
+		{name: "messages without an id are not deduplicated again", data: newData(""), want: []string{StatusSent, StatusFailed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := Dispatch(ctx, log, testSource{}, tt.data)
+			got := []string{}
+			for _, result := range results {
+				got = append(got, result.Status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Dispatch() statuses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatchRateLimit(t *testing.T) {
+	mess := "hello {{ .data.test }}"
+	summary := "{{ .data.suppressed }} more messages for {{ .data.key }}"
+	props := map[string]config.PropertyAndValue{
+		"message": {Value: &mess},
+	}
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name:       "drop",
+				Type:       "log",
+				Properties: props,
+				RateLimit:  &config.RateLimit{Limit: 1, Period: "1h", Key: "{{ .data.test }}"},
+			},
+			{
+				Name:       "summarize",
+				Type:       "log",
+				Properties: props,
+				RateLimit:  &config.RateLimit{Limit: 1, Period: "100ms", Action: "summarize", SummaryNotification: "summary"},
+			},
+			{
+				Name:                "summary",
+				Type:                "log",
+				CelExpressionFilter: "false",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &summary},
+				},
+			},
+		},
+	}
+	limiter := ratelimit.NewLimiter()
+	ctx := ratelimit.WithCtx(config.WithCtx(context.Background(), cfg), limiter)
+	log := zaptest.NewLogger(t)
+	newData := func(test string) *message.NotificationData {
+		return &message.NotificationData{ID: "1", Data: map[string]interface{}{"test": test}, Attributes: map[string]string{}}
+	}
+
+	tests := []struct {
+		name string
+		data *message.NotificationData
+		want []string
+	}{
+		{name: "first message", data: newData("a"), want: []string{StatusSent, StatusSent}},
+		{name: "over the limit", data: newData("a"), want: []string{StatusThrottled, StatusThrottled}},
+		{name: "other key", data: newData("b"), want: []string{StatusSent, StatusThrottled}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := Dispatch(ctx, log, testSource{}, tt.data)
+			got := []string{}
+			for _, result := range results {
+				got = append(got, result.Status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Dispatch() statuses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for limiter.Stats()["summarize"].Summaries == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := map[string]ratelimit.Counters{
+		"drop":      {Allowed: 2, Dropped: 1},
+		"summarize": {Allowed: 1, Suppressed: 2, Summaries: 1},
+	}
+	if got := limiter.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Limiter.Stats() = %v, want %v", got, want)
+	}
+}
+
+func TestDispatchDigest(t *testing.T) {
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name:   "digest",
+				Type:   "does-not-exist",
+				Digest: &config.Digest{MaxMessages: 2, Key: "{{ .data.repo }}"},
+			},
+		},
+	}
+	store, err := deadletter.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	ctx = digest.WithCtx(deadletter.WithCtx(ctx, store), digest.NewAggregator())
+	log := zaptest.NewLogger(t)
+
+	for i, repo := range []string{"a", "b", "a"} {
+		results := Dispatch(ctx, log, testSource{}, &message.NotificationData{
+			ID:         fmt.Sprint(i),
+			Data:       map[string]interface{}{"repo": repo},
+			Attributes: map[string]string{},
+		})
+		if len(results) != 1 || results[0].Status != StatusDigested {
+			t.Fatalf("Dispatch() = %v, want a digested result", results)
+		}
+	}
+
+	// the digest notification fails so the delivered digest ends up in the dead letter store
+	entries, _ := store.List(ctx)
+	if len(entries) != 1 {
+		t.Fatalf("Dispatch() delivered %v digests, want 1", len(entries))
+	}
+	data := entries[0].Data
+	if data.ID != "digest|a/0" || data.Data["key"] != "digest|a" || data.Data["count"] != float64(2) {
+		t.Errorf("Dispatch() digest = %v", data)
+	}
+	messages := data.Data["messages"].([]interface{})
+	if len(messages) != 2 || messages[1].(map[string]interface{})["id"] != "2" {
+		t.Errorf("Dispatch() digest messages = %v", messages)
+	}
+}
+
+func TestDispatchRoutes(t *testing.T) {
+	mess := "hello {{ .data.test }}"
+	cfg := &config.ServerConfiguration{
+		Route: &config.Route{
+			Receivers: []string{"default"},
+			Routes: []config.Route{
+				{
+					Name:      "unknown",
+					Matcher:   "data.test == 'unknown'",
+					Receivers: []string{"does-not-exist", "log"},
+				},
+			},
+		},
+		Notifications: []config.Notification{
+			{
+				Name: "log",
+				Type: "log",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+			},
+			{
+				Name: "default",
+				Type: "log",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+			},
+		},
+	}
+	ctx := config.WithCtx(context.Background(), cfg)
+	log := zaptest.NewLogger(t)
+
+	tests := []struct {
+		name string
+		test string
+		want []string
+	}{
+		{name: "default receiver", test: "123", want: []string{"default:sent"}},
+		{name: "unknown receiver", test: "unknown", want: []string{"does-not-exist:failed", "log:sent"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := Dispatch(ctx, log, testSource{}, &message.NotificationData{ID: "1", Data: map[string]interface{}{"test": tt.test}, Attributes: map[string]string{}})
+			got := []string{}
+			for _, result := range results {
+				got = append(got, result.Name+":"+result.Status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Dispatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatchCorrelation(t *testing.T) {
+	mess := "pipeline {{ .data.pipeline }} is {{ .data.status }}"
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name: "log",
+				Type: "log",
+				// only notify the first failure and the recovery
+				CelExpressionFilter: `!("status" in previous) || previous.status == "resolved" || data.status == "success"`,
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+				Correlation: &config.Correlation{
+					Fingerprint:        "{{ .data.pipeline }}",
+					ResolvedExpression: `data.status == "success"`,
+				},
+			},
+		},
+	}
+	store := correlation.NewMemoryStore()
+	ctx := correlation.WithCtx(config.WithCtx(context.Background(), cfg), store)
+	log := zaptest.NewLogger(t)
+
+	tests := []struct {
+		status     string
+		wantSent   bool
+		wantStatus string
+		wantCount  int
+	}{
+		{status: "failure", wantSent: true, wantStatus: correlation.StatusFiring, wantCount: 1},
+		{status: "failure", wantSent: false, wantStatus: correlation.StatusFiring, wantCount: 1},
+		{status: "success", wantSent: true, wantStatus: correlation.StatusResolved, wantCount: 2},
+		{status: "failure", wantSent: true, wantStatus: correlation.StatusFiring, wantCount: 1},
+	}
+	for i, tt := range tests {
+		results := Dispatch(ctx, log, testSource{}, &message.NotificationData{
+			ID:         fmt.Sprint(i),
+			Data:       map[string]interface{}{"pipeline": "build", "status": tt.status},
+			Attributes: map[string]string{},
+		})
+		if tt.wantSent != (len(results) == 1 && results[0].Status == StatusSent) {
+			t.Fatalf("message %v: Dispatch() = %v, want sent %v", i, results, tt.wantSent)
+		}
+		state, _ := store.Get(ctx, "log|build")
+		if state == nil || state.Status != tt.wantStatus || state.Count != tt.wantCount {
+			t.Fatalf("message %v: state = %v, want %v with count %v", i, state, tt.wantStatus, tt.wantCount)
+		}
+	}
+}
+
+func TestDispatchCorrelationFingerprintError(t *testing.T) {
+	mess := "pipeline failed"
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name: "log",
+				Type: "log",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+				Correlation: &config.Correlation{
+					Fingerprint: "{{ .data.pipeline ",
+				},
+			},
+		},
+	}
+	store := correlation.NewMemoryStore()
+	ctx := correlation.WithCtx(config.WithCtx(context.Background(), cfg), store)
+	log := zaptest.NewLogger(t)
+
+	results := Dispatch(ctx, log, testSource{}, &message.NotificationData{ID: "1", Data: map[string]interface{}{"pipeline": "build"}, Attributes: map[string]string{}})
+	if len(results) != 1 || results[0].Status != StatusFailed {
+		t.Fatalf("Dispatch() = %v, want failed", results)
+	}
+	// the state is not stored under the notification name
+	state, _ := store.Get(ctx, "log")
+	if state != nil {
+		t.Errorf("state = %v, want no state", state)
+	}
+}
+
+// countingStore counts the reads of the correlation state
+type countingStore struct {
+	*correlation.MemoryStore
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, fingerprint string) (*correlation.State, error) {
+	s.gets++
+	return s.MemoryStore.Get(ctx, fingerprint)
+}
+
+func TestDispatchCorrelationAfterMatching(t *testing.T) {
+	mess := "pipeline {{ .data.pipeline }} failed"
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name:                "log",
+				Type:                "log",
+				CelExpressionFilter: `data.status == "failure"`,
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+				Correlation: &config.Correlation{
+					Fingerprint: "{{ .data.pipeline }}",
+				},
+			},
+		},
+	}
+	store := &countingStore{MemoryStore: correlation.NewMemoryStore()}
+	ctx := correlation.WithCtx(config.WithCtx(context.Background(), cfg), store)
+	log := zaptest.NewLogger(t)
+
+	Dispatch(ctx, log, testSource{}, &message.NotificationData{ID: "1", Data: map[string]interface{}{"pipeline": "build", "status": "success"}, Attributes: map[string]string{}})
+	if store.gets != 0 {
+		t.Errorf("Dispatch() read the state %v times for a message that does not match, want 0", store.gets)
+	}
+	results := Dispatch(ctx, log, testSource{}, &message.NotificationData{ID: "2", Data: map[string]interface{}{"pipeline": "build", "status": "failure"}, Attributes: map[string]string{}})
+	if len(results) != 1 || results[0].Status != StatusSent {
+		t.Fatalf("Dispatch() = %v, want sent", results)
+	}
+	state, _ := store.MemoryStore.Get(ctx, "log|build")
+	if state == nil || state.Count != 1 {
+		t.Errorf("state = %v, want a count of 1", state)
+	}
+}
+
+func TestDispatchFallbacks(t *testing.T) {
+	// the template fails when the failure is not available
+	mess := "{{ .failure.notification }} failed after {{ .failure.attempts }} attempt(s) - {{ .failure.error }}"
+	cfg := &config.ServerConfiguration{
+		Notifications: []config.Notification{
+			{
+				Name:      "primary",
+				Type:      "does-not-exist",
+				Fallbacks: []string{"broken", "log"},
+			},
+			{
+				Name:      "all-broken",
+				Type:      "does-not-exist",
+				Fallbacks: []string{"broken"},
+			},
+			{
+				Name:                "broken",
+				Type:                "does-not-exist",
+				CelExpressionFilter: "false",
+			},
+			{
+				Name:                "log",
+				Type:                "log",
+				CelExpressionFilter: "false",
+				Properties: map[string]config.PropertyAndValue{
+					"message": {Value: &mess},
+				},
+			},
+		},
+	}
+	store, err := deadletter.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	ctx := deadletter.WithCtx(config.WithCtx(context.Background(), cfg), store)
+	log := zaptest.NewLogger(t)
+
+	results := Dispatch(ctx, log, testSource{}, &message.NotificationData{ID: "1", Data: map[string]interface{}{}, Attributes: map[string]string{}})
+	if len(results) != 2 {
+		t.Fatalf("Dispatch() = %v, want 2 results", results)
+	}
+	primary := results[0]
+	if primary.Status != StatusFailed || len(primary.Fallbacks) != 2 || primary.Fallbacks[0].Status != StatusFailed || primary.Fallbacks[1].Status != StatusSent {
+		t.Errorf("Dispatch() primary = %+v, want a failed notification sent by the second fallback", primary)
+	}
+	allBroken := results[1]
+	if allBroken.Status != StatusFailed || len(allBroken.Fallbacks) != 1 || FallbackSent(allBroken) {
+		t.Errorf("Dispatch() all-broken = %+v, want a failed notification with a failed fallback", allBroken)
+	}
+	sent, failed := CountResults(results)
+	if sent != 1 || failed != 1 {
+		t.Errorf("CountResults() = %v, %v, want 1, 1", sent, failed)
+	}
+
+	// only the notification without a sent fallback is dead lettered
+	entries, _ := store.List(ctx)
+	if len(entries) != 1 || entries[0].Notification != "all-broken" {
+		t.Errorf("dead letter entries = %v, want the all-broken notification", entries)
+	}
+}
+
+func TestEvaluateVariables(t *testing.T) {
+	data := &message.NotificationData{
+		ID: "1",
+		Data: map[string]interface{}{
+			"jobs": []interface{}{
+				map[string]interface{}{"name": "build", "status": "success"},
+				map[string]interface{}{"name": "test", "status": "failure"},
+			},
+		},
+		Attributes: map[string]string{"repo": "knot"},
+	}
+	tests := []struct {
+		name      string
+		variables []config.Variable
+		want      map[string]interface{}
+		wantErr   bool
+	}{
+		{
+			name: "typed values",
+			variables: []config.Variable{
+				{Name: "failed", Expression: `data.jobs.filter(j, j.status == "failure").map(j, j.name)`},
+				{Name: "count", Expression: `size(data.jobs)`},
+				{Name: "title", Expression: `attributes.repo + ": " + string(size(vars.failed)) + " failed"`},
+				{Name: "ok", Expression: `size(vars.failed) == 0`},
+			},
+			want: map[string]interface{}{
+				"failed": []interface{}{"test"},
+				"count":  int64(2),
+				"title":  "knot: 1 failed",
+				"ok":     false,
+			},
+		},
+		{
+			name:      "invalid expression",
+			variables: []config.Variable{{Name: "bad", Expression: "data."}},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown variable",
+			variables: []config.Variable{{Name: "bad", Expression: "vars.missing"}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evaluateVariables(context.Background(), config.Notification{Name: "n1", Variables: tt.variables}, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluateVariables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.Vars, tt.want) {
+				t.Errorf("evaluateVariables() = %#v, want %#v", got.Vars, tt.want)
+			}
+			if data.Vars != nil {
+				t.Errorf("evaluateVariables() should not change the received message")
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package dispatcher
+
+import (
+	"context"
+
+	"github.com/kcloutie/knot/pkg/enrichment"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// enrich joins the lookup tables to the message when enrichment is configured. The failed tables are logged and
+// joined as empty rows so the notifications are still evaluated
+func enrich(ctx context.Context, log *zap.Logger, notifyData *message.NotificationData) *message.NotificationData {
+	enricher := enrichment.FromCtx(ctx)
+	if enricher == nil {
+		return notifyData
+	}
+	enriched, err := enricher.Enrich(ctx, notifyData)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	return enriched
+}
@@ -0,0 +1,104 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/clock"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/escalation"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// escalationSource is the source of the notifications sent by the steps of the escalations
+type escalationSource struct{}
+
+func (s escalationSource) GetName() string {
+	return "escalation"
+}
+
+func (s escalationSource) GetApiPath() string {
+	return ""
+}
+
+// startEscalation creates the escalation of the notification and returns a copy of the message with the escalation
+// values. The escalation is nil when the notification is not escalated
+func startEscalation(ctx context.Context, log *zap.Logger, not config.Notification, notifyData *message.NotificationData) (*escalation.Escalation, *message.NotificationData) {
+	if not.Escalation == "" || escalation.FromCtx(ctx) == nil {
+		return nil, notifyData
+	}
+	esc := escalation.New(not.Name, not.Escalation, 0, clock.Now(ctx).UTC())
+	return &esc, withEscalation(ctx, log, esc, 0, notifyData)
+}
+
+// withEscalation returns a copy of the message with the escalation values available to the templates as .escalation
+func withEscalation(ctx context.Context, log *zap.Logger, esc escalation.Escalation, step int, notifyData *message.NotificationData) *message.NotificationData {
+	escalated := *notifyData
+	escalated.Escalation = map[string]interface{}{
+		"id":     esc.Id,
+		"policy": esc.Policy,
+		"step":   step,
+	}
+	ackUrl, expires, err := getAckUrl(ctx, log, esc.Id)
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to create the acknowledgement link of escalation '%s' - %v", esc.Id, err))
+	} else {
+		escalated.Escalation["ackUrl"] = ackUrl
+		escalated.Escalation["ackExpiresAt"] = expires.Format(time.RFC3339)
+	}
+	return &escalated
+}
+
+func getAckUrl(ctx context.Context, log *zap.Logger, id string) (string, time.Time, error) {
+	cfg := config.FromCtx(ctx).Escalation
+	if cfg == nil || cfg.SigningKey == nil {
+		return "", time.Time{}, fmt.Errorf("the escalation signing key is not configured")
+	}
+	key, err := cfg.SigningKey.GetValue(ctx, log, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ttl, err := cfg.GetLinkTTL()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := clock.Now(ctx).Add(ttl).UTC().Truncate(time.Second)
+	return escalation.AckUrl(cfg.BaseUrl, key, id, expires), expires, nil
+}
+
+// escalate schedules the steps of the escalation policy of the sent notification
+func escalate(ctx context.Context, log *zap.Logger, not config.Notification, esc *escalation.Escalation, notifyData *message.NotificationData) {
+	scheduler := escalation.FromCtx(ctx)
+	if esc == nil || scheduler == nil {
+		return
+	}
+	policy, exists := config.FromCtx(ctx).EscalationPolicies[not.Escalation]
+	if !exists {
+		log.Error(fmt.Sprintf("the escalation policy '%s' of notification '%s' does not exist", not.Escalation, not.Name))
+		return
+	}
+	delays := []time.Duration{}
+	for i, step := range policy.Steps {
+		after, err := step.GetAfter()
+		if err != nil {
+			log.Error(fmt.Sprintf("invalid step %v of escalation policy '%s', the notification is not escalated - %v", i, not.Escalation, err))
+			return
+		}
+		delays = append(delays, after)
+	}
+	scheduler.Start(*esc, delays, func(current escalation.Escalation, step int) {
+		log.Sugar().Infof("escalation '%s' of notification '%s' was not acknowledged, sending step %v", current.Id, not.Name, step)
+		stepData := withEscalation(ctx, log, current, step, notifyData)
+		for _, name := range policy.Steps[step-1].Notifications {
+			stepNot, exists := config.FromCtx(ctx).GetNotification(name)
+			if !exists {
+				log.Error(fmt.Sprintf("the notification '%s' of step %v of escalation policy '%s' does not exist", name, step, not.Escalation))
+				continue
+			}
+			result := deliver(ctx, log, escalationSource{}, stepNot, stepData)
+			log.Debug(fmt.Sprintf("step %v of escalation '%s' delivered", step, current.Id), zap.Any("result", result))
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/heartbeat"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// heartbeatSource is the source of the notifications sent for late heartbeats
+type heartbeatSource struct{}
+
+func (s heartbeatSource) GetName() string {
+	return "heartbeat"
+}
+
+func (s heartbeatSource) GetApiPath() string {
+	return ""
+}
+
+// observe records the message for the heartbeats it matches
+func observe(ctx context.Context, log *zap.Logger, notifyData *message.NotificationData) {
+	monitor := heartbeat.FromCtx(ctx)
+	if monitor == nil {
+		return
+	}
+	err := monitor.Observe(ctx, notifyData)
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// CheckHeartbeats sends the notifications of the late heartbeats
+func CheckHeartbeats(ctx context.Context, log *zap.Logger) error {
+	monitor := heartbeat.FromCtx(ctx)
+	if monitor == nil {
+		return nil
+	}
+	return monitor.Check(ctx, func(hb config.Heartbeat, notifyData *message.NotificationData) error {
+		not, exists := config.FromCtx(ctx).GetNotification(hb.Notification)
+		if !exists {
+			return fmt.Errorf("notification '%s' does not exist", hb.Notification)
+		}
+		log.Sugar().Infof("heartbeat '%s' is late, sending notification '%s'", hb.Name, not.Name)
+		result := deliver(ctx, log, heartbeatSource{}, not, notifyData)
+		if result.Status == StatusFailed {
+			return fmt.Errorf("failed to send notification '%s' - %s", not.Name, result.Error)
+		}
+		return nil
+	})
+}
+
+// StartHeartbeats checks the heartbeats every check interval until the context is cancelled
+func StartHeartbeats(ctx context.Context, log *zap.Logger) {
+	cfg := config.FromCtx(ctx)
+	if heartbeat.FromCtx(ctx) == nil || cfg.Heartbeats == nil {
+		return
+	}
+	interval, err := cfg.Heartbeats.GetCheckInterval()
+	if err != nil {
+		log.Error(fmt.Sprintf("invalid heartbeat configuration, the heartbeats are not checked - %v", err))
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := CheckHeartbeats(ctx, log)
+				if err != nil {
+					log.Error(err.Error())
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+package dispatcher
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// Job is a message waiting to be dispatched by the worker pool
+type Job struct {
+	DeliveryId string
+	Log        *zap.Logger
+	Source     Source
+	Data       *message.NotificationData
+}
+
+// WorkerPool dispatches queued messages using a fixed number of workers
+type WorkerPool struct {
+	workers int
+	jobs    chan Job
+	mutex   sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func NewWorkerPool(workers int, queueSize int) *WorkerPool {
+	return &WorkerPool{
+		workers: workers,
+		jobs:    make(chan Job, queueSize),
+	}
+}
+
+// Start starts the workers. The context is used when dispatching the messages
+func (p *WorkerPool) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func(worker int) {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				log := job.Log.With(zap.String("deliveryId", job.DeliveryId), zap.Int("worker", worker))
+				results := Dispatch(ctx, log, job.Source, job.Data)
+				log.Debug("message dispatched", zap.Any("results", results))
+			}
+		}(i)
+	}
+}
+
+// SubmitAll queues all of the jobs. When there is not enough room in the queue for every job, none of them are queued
+// and false is returned
+func (p *WorkerPool) SubmitAll(jobs []Job) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stopped || cap(p.jobs)-len(p.jobs) < len(jobs) {
+		return false
+	}
+	for _, job := range jobs {
+		p.jobs <- job
+	}
+	return true
+}
+
+// Capacity returns the number of jobs the queue can hold. Submitting more jobs at once can never succeed
+func (p *WorkerPool) Capacity() int {
+	return cap(p.jobs)
+}
+
+// Stop stops accepting new jobs and waits for the queued jobs to be dispatched
+func (p *WorkerPool) Stop() {
+	p.mutex.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.jobs)
+	}
+	p.mutex.Unlock()
+	p.wg.Wait()
+}
+
+type ctxPoolKey struct{}
+
+// FromCtx returns the worker pool stored in the context or nil when messages are dispatched synchronously
+func FromCtx(ctx context.Context) *WorkerPool {
+	if p, ok := ctx.Value(ctxPoolKey{}).(*WorkerPool); ok {
+		return p
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, p *WorkerPool) context.Context {
+	if pp, ok := ctx.Value(ctxPoolKey{}).(*WorkerPool); ok {
+		if pp == p {
+			return ctx
+		}
+	}
+	return context.WithValue(ctx, ctxPoolKey{}, p)
+}
@@ -0,0 +1,70 @@
+package dispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap/zaptest"
+)
+
+type testSource struct{}
+
+func (s testSource) GetName() string {
+	return "test"
+}
+
+func (s testSource) GetApiPath() string {
+	return "test"
+}
+
+func newTestJob(t *testing.T, id string) Job {
+	return Job{
+		DeliveryId: id,
+		Log:        zaptest.NewLogger(t),
+		Source:     testSource{},
+		Data: &message.NotificationData{
+			ID:         id,
+			Data:       map[string]interface{}{},
+			Attributes: map[string]string{},
+		},
+	}
+}
+
+func TestWorkerPool_SubmitAll(t *testing.T) {
+	pool := NewWorkerPool(1, 2)
+
+	if !pool.SubmitAll([]Job{newTestJob(t, "1")}) {
+		t.Errorf("WorkerPool.SubmitAll() = false, want true when the queue has room")
+	}
+	if pool.SubmitAll([]Job{newTestJob(t, "2"), newTestJob(t, "3")}) {
+		t.Errorf("WorkerPool.SubmitAll() = true, want false when the queue does not have room for every job")
+	}
+	if len(pool.jobs) != 1 {
+		t.Errorf("WorkerPool.SubmitAll() queued %v jobs, want 1", len(pool.jobs))
+	}
+	if !pool.SubmitAll([]Job{newTestJob(t, "2")}) {
+		t.Errorf("WorkerPool.SubmitAll() = false, want true when the queue has room")
+	}
+
+	pool.Start(context.Background())
+	pool.Stop()
+
+	if len(pool.jobs) != 0 {
+		t.Errorf("WorkerPool.Stop() left %v jobs in the queue, want 0", len(pool.jobs))
+	}
+	if pool.SubmitAll([]Job{newTestJob(t, "4")}) {
+		t.Errorf("WorkerPool.SubmitAll() = true, want false when the pool is stopped")
+	}
+}
+
+func TestFromCtx(t *testing.T) {
+	if got := FromCtx(context.Background()); got != nil {
+		t.Errorf("FromCtx() = %v, want nil", got)
+	}
+	pool := NewWorkerPool(1, 1)
+	ctx := WithCtx(context.Background(), pool)
+	if got := FromCtx(ctx); got != pool {
+		t.Errorf("FromCtx() = %v, want %v", got, pool)
+	}
+}
@@ -0,0 +1,41 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+	"github.com/kcloutie/knot/pkg/routing"
+	"go.uber.org/zap"
+)
+
+// getNotifications returns the notifications to evaluate for the message. When a routing tree is configured, only the
+// receivers selected by the tree are returned, otherwise every notification is returned. Routes that could not be
+// evaluated and receivers that do not exist are returned as failed results
+func getNotifications(ctx context.Context, log *zap.Logger, notifyData *message.NotificationData) ([]config.Notification, []NotificationResult) {
+	cfg := config.FromCtx(ctx)
+	if cfg.Route == nil {
+		return cfg.Notifications, []NotificationResult{}
+	}
+	results := []NotificationResult{}
+	selections, errs := routing.Match(ctx, cfg.Route, notifyData)
+	for _, routeErr := range errs {
+		log.Error(routeErr.Error())
+		results = append(results, NotificationResult{Name: "route/" + routeErr.Route, Status: StatusFailed, Error: routeErr.Error()})
+	}
+
+	notifications := []config.Notification{}
+	for _, selection := range selections {
+		not, exists := cfg.GetNotification(selection.Receiver)
+		if !exists {
+			err := fmt.Errorf("the receiver '%s' of route '%s' does not exist", selection.Receiver, selection.Route)
+			log.Error(err.Error())
+			results = append(results, NotificationResult{Name: selection.Receiver, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+		log.Sugar().Debugf("route '%s' selected receiver '%s'", selection.Route, selection.Receiver)
+		notifications = append(notifications, routing.Apply(not, selection))
+	}
+	return notifications, results
+}
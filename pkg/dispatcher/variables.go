@@ -0,0 +1,32 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kcloutie/knot/pkg/cel"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+// evaluateVariables returns a copy of the message with the values of the variables of the notification. The variables
+// are evaluated in order so a variable can use the ones declared before it
+func evaluateVariables(ctx context.Context, not config.Notification, notifyData *message.NotificationData) (*message.NotificationData, error) {
+	if len(not.Variables) == 0 {
+		return notifyData, nil
+	}
+	evaluated := *notifyData
+	evaluated.Vars = map[string]interface{}{}
+	for _, variable := range not.Variables {
+		val, err := message.CelEnvironment().Evaluate(ctx, variable.Expression, evaluated.AsMap())
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate variable '%s' - %v", variable.Name, err)
+		}
+		value, err := cel.GetNativeValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate variable '%s' - %v", variable.Name, err)
+		}
+		evaluated.Vars[variable.Name] = value
+	}
+	return &evaluated, nil
+}
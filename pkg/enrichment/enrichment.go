@@ -0,0 +1,223 @@
+package enrichment
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/cel"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/logger"
+	"github.com/kcloutie/knot/pkg/message"
+	"gopkg.in/yaml.v3"
+)
+
+// checkInterval is how often the file is checked for changes once it was read
+const checkInterval = time.Second
+
+// Table is a lookup table read from a file. The file is read again when its modification time or size changes. When
+// the file cannot be read again, the rows read before are kept
+type Table struct {
+	cfg     config.Enrichment
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	checked time.Time
+	rows    map[string]map[string]interface{}
+	now     func() time.Time
+}
+
+func NewTable(cfg config.Enrichment) *Table {
+	return &Table{
+		cfg: cfg,
+		now: time.Now,
+	}
+}
+
+// Lookup returns the row of the key or nil when the key does not exist
+func (t *Table) Lookup(ctx context.Context, key string) (map[string]interface{}, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	err := t.load()
+	if err != nil {
+		if t.rows == nil {
+			return nil, err
+		}
+		logger.FromCtx(ctx).Warn(fmt.Sprintf("%v, using the rows read before", err))
+	}
+	return t.rows[key], nil
+}
+
+// load reads the file when it changed since it was last read. Once the file was read, it is checked at most every
+// checkInterval. The lock must be held
+func (t *Table) load() error {
+	now := t.now()
+	if t.rows != nil && now.Sub(t.checked) < checkInterval {
+		return nil
+	}
+	t.checked = now
+	info, err := os.Stat(t.cfg.File)
+	if err != nil {
+		return fmt.Errorf("failed to read the enrichment file '%s' - %v", t.cfg.File, err)
+	}
+	if t.rows != nil && info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return nil
+	}
+	data, err := os.ReadFile(t.cfg.File)
+	if err != nil {
+		return fmt.Errorf("failed to read the enrichment file '%s' - %v", t.cfg.File, err)
+	}
+	rows, err := parse(t.cfg, data)
+	if err != nil {
+		return fmt.Errorf("failed to parse the enrichment file '%s' - %v", t.cfg.File, err)
+	}
+	t.rows = rows
+	t.modTime = info.ModTime()
+	t.size = info.Size()
+	return nil
+}
+
+func parse(cfg config.Enrichment, data []byte) (map[string]map[string]interface{}, error) {
+	if cfg.GetFormat() == config.EnrichmentFormatCsv {
+		return parseCsv(cfg, data)
+	}
+	var content interface{}
+	var err error
+	if cfg.GetFormat() == config.EnrichmentFormatJson {
+		err = json.Unmarshal(data, &content)
+	} else {
+		err = yaml.Unmarshal(data, &content)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows := map[string]map[string]interface{}{}
+	switch c := content.(type) {
+	case map[string]interface{}:
+		for key, value := range c {
+			row, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("the value of key '%s' is not an object", key)
+			}
+			rows[key] = row
+		}
+	case []interface{}:
+		if cfg.KeyField == "" {
+			return nil, fmt.Errorf("the key field is required when the file contains a list")
+		}
+		for i, value := range c {
+			row, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("item %v is not an object", i)
+			}
+			key, exists := row[cfg.KeyField]
+			if !exists {
+				return nil, fmt.Errorf("item %v does not contain the key field '%s'", i, cfg.KeyField)
+			}
+			rows[fmt.Sprint(key)] = row
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("the file must contain an object or a list")
+	}
+	return rows, nil
+}
+
+func parseCsv(cfg config.Enrichment, data []byte) (map[string]map[string]interface{}, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rows := map[string]map[string]interface{}{}
+	if len(records) == 0 {
+		return rows, nil
+	}
+	header := records[0]
+	keyIndex := 0
+	if cfg.KeyField != "" {
+		keyIndex = -1
+		for i, name := range header {
+			if name == cfg.KeyField {
+				keyIndex = i
+			}
+		}
+		if keyIndex < 0 {
+			return nil, fmt.Errorf("the header does not contain the key field '%s'", cfg.KeyField)
+		}
+	}
+	for _, record := range records[1:] {
+		row := map[string]interface{}{}
+		for i, name := range header {
+			if i < len(record) {
+				row[name] = record[i]
+			}
+		}
+		rows[record[keyIndex]] = row
+	}
+	return rows, nil
+}
+
+// Enricher joins the lookup tables to the messages
+type Enricher struct {
+	tables []*Table
+}
+
+func NewEnricher(enrichments []config.Enrichment) *Enricher {
+	e := &Enricher{}
+	for _, cfg := range enrichments {
+		e.tables = append(e.tables, NewTable(cfg))
+	}
+	return e
+}
+
+// Enrich returns a copy of the message with the row of each table matching the key expression in enrichment.<name>.
+// An empty row is used when the key does not exist. The errors of the tables are returned together, the other
+// tables are still joined
+func (e *Enricher) Enrich(ctx context.Context, data *message.NotificationData) (*message.NotificationData, error) {
+	enriched := *data
+	enriched.Enrichment = map[string]interface{}{}
+	var errs []error
+	for _, table := range e.tables {
+		row := map[string]interface{}{}
+		enriched.Enrichment[table.cfg.Name] = row
+		val, err := message.CelEnvironment().Evaluate(ctx, table.cfg.KeyExpression, data.AsMap())
+		if err == nil {
+			var key interface{}
+			key, err = cel.GetNativeValue(val)
+			if err == nil {
+				var found map[string]interface{}
+				found, err = table.Lookup(ctx, fmt.Sprint(key))
+				if found != nil {
+					enriched.Enrichment[table.cfg.Name] = found
+				}
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("enrichment '%s' - %v", table.cfg.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return &enriched, fmt.Errorf("failed to enrich the message - %v", errs)
+	}
+	return &enriched, nil
+}
+
+type ctxEnricherKey struct{}
+
+// FromCtx returns the enricher stored in the context or nil when no enrichment is configured
+func FromCtx(ctx context.Context) *Enricher {
+	if e, ok := ctx.Value(ctxEnricherKey{}).(*Enricher); ok {
+		return e
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, e *Enricher) context.Context {
+	return context.WithValue(ctx, ctxEnricherKey{}, e)
+}
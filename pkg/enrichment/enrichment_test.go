@@ -0,0 +1,131 @@
+package enrichment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+func TestEnricher_Enrich(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"owners.csv":  "service,team,channel\napi,platform,#platform\nweb,frontend,#frontend\n",
+		"owners.yaml": "api:\n  team: platform\nweb:\n  team: frontend\n",
+		"owners.json": `[{"id": 1, "team": "platform"}, {"id": 2, "team": "frontend"}]`,
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name       string
+		enrichment config.Enrichment
+		data       map[string]interface{}
+		want       map[string]interface{}
+		wantErr    bool
+	}{
+		{
+			name:       "csv",
+			enrichment: config.Enrichment{Name: "owners", File: filepath.Join(dir, "owners.csv"), KeyExpression: "data.service"},
+			data:       map[string]interface{}{"service": "api"},
+			want:       map[string]interface{}{"service": "api", "team": "platform", "channel": "#platform"},
+		},
+		{
+			name:       "csv with key field",
+			enrichment: config.Enrichment{Name: "owners", File: filepath.Join(dir, "owners.csv"), KeyExpression: "data.team", KeyField: "team"},
+			data:       map[string]interface{}{"team": "frontend"},
+			want:       map[string]interface{}{"service": "web", "team": "frontend", "channel": "#frontend"},
+		},
+		{
+			name:       "yaml",
+			enrichment: config.Enrichment{Name: "owners", File: filepath.Join(dir, "owners.yaml"), KeyExpression: "data.service"},
+			data:       map[string]interface{}{"service": "web"},
+			want:       map[string]interface{}{"team": "frontend"},
+		},
+		{
+			name:       "json list with numeric key",
+			enrichment: config.Enrichment{Name: "owners", File: filepath.Join(dir, "owners.json"), KeyExpression: "data.id", KeyField: "id"},
+			data:       map[string]interface{}{"id": 2},
+			want:       map[string]interface{}{"id": float64(2), "team": "frontend"},
+		},
+		{
+			name:       "missing key",
+			enrichment: config.Enrichment{Name: "owners", File: filepath.Join(dir, "owners.csv"), KeyExpression: "data.service"},
+			data:       map[string]interface{}{"service": "db"},
+			want:       map[string]interface{}{},
+		},
+		{
+			name:       "missing file",
+			enrichment: config.Enrichment{Name: "owners", File: filepath.Join(dir, "missing.csv"), KeyExpression: "data.service"},
+			data:       map[string]interface{}{"service": "api"},
+			want:       map[string]interface{}{},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnricher([]config.Enrichment{tt.enrichment})
+			got, err := e.Enrich(context.Background(), &message.NotificationData{ID: "1", Data: tt.data})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Enricher.Enrich() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got.Enrichment["owners"], tt.want) {
+				t.Errorf("Enricher.Enrich() = %v, want %v", got.Enrichment["owners"], tt.want)
+			}
+		})
+	}
+}
+
+func TestTable_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "owners.yaml")
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		err := os.WriteFile(file, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(file, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	write("api:\n  team: platform\n", modTime)
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := NewTable(config.Enrichment{Name: "owners", File: file, KeyExpression: "data.service"})
+	table.now = func() time.Time {
+		return now
+	}
+	row, err := table.Lookup(ctx, "api")
+	if err != nil || row["team"] != "platform" {
+		t.Fatalf("Table.Lookup() = %v, %v, want team platform", row, err)
+	}
+
+	write("api:\n  team: payments\n", modTime.Add(time.Minute))
+	row, err = table.Lookup(ctx, "api")
+	if err != nil || row["team"] != "platform" {
+		t.Fatalf("Table.Lookup() before the check interval = %v, %v, want team platform", row, err)
+	}
+	now = now.Add(checkInterval)
+	row, err = table.Lookup(ctx, "api")
+	if err != nil || row["team"] != "payments" {
+		t.Fatalf("Table.Lookup() after change = %v, %v, want team payments", row, err)
+	}
+
+	// the rows read before are kept when the file becomes invalid
+	write("api: [", modTime.Add(2*time.Minute))
+	now = now.Add(checkInterval)
+	row, err = table.Lookup(ctx, "api")
+	if err != nil || row["team"] != "payments" {
+		t.Fatalf("Table.Lookup() after an invalid change = %v, %v, want team payments", row, err)
+	}
+}
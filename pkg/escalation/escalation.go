@@ -0,0 +1,200 @@
+package escalation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// StatusPending is used while steps remain to be sent
+	StatusPending = "pending"
+	// StatusAcknowledged is used once the escalation was acknowledged, the remaining steps are cancelled
+	StatusAcknowledged = "acknowledged"
+	// StatusCompleted is used once every step was sent without being acknowledged
+	StatusCompleted = "completed"
+)
+
+// Escalation is a notification being escalated with the steps of its policy
+type Escalation struct {
+	Id           string `json:"id" yaml:"id"`
+	Notification string `json:"notification" yaml:"notification"`
+	Policy       string `json:"policy" yaml:"policy"`
+	Status       string `json:"status" yaml:"status"`
+	// Step is the last step sent, 0 being the notification itself
+	Step           int       `json:"step" yaml:"step"`
+	Steps          int       `json:"steps" yaml:"steps"`
+	StartedAt      time.Time `json:"startedAt" yaml:"startedAt"`
+	AcknowledgedAt time.Time `json:"acknowledgedAt,omitempty" yaml:"acknowledgedAt,omitempty"`
+	AcknowledgedBy string    `json:"acknowledgedBy,omitempty" yaml:"acknowledgedBy,omitempty"`
+}
+
+// New creates a pending escalation with a new id
+func New(notification string, policy string, steps int, now time.Time) Escalation {
+	return Escalation{
+		Id:           uuid.NewV4().String(),
+		Notification: notification,
+		Policy:       policy,
+		Status:       StatusPending,
+		Steps:        steps,
+		StartedAt:    now,
+	}
+}
+
+// StepFunc sends the notifications of the step
+type StepFunc func(escalation Escalation, step int)
+
+// Scheduler sends the steps of the escalations until they are acknowledged. Escalations are removed once they are
+// finished for longer than the retention. The escalations are only kept in memory: when the server restarts, the
+// pending steps are not sent and the acknowledgement links already sent return a 404
+type Scheduler struct {
+	mutex       sync.Mutex
+	escalations map[string]*Escalation
+	retention   time.Duration
+	now         func() time.Time
+	afterFunc   func(d time.Duration, f func())
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		escalations: map[string]*Escalation{},
+		retention:   24 * time.Hour,
+		now:         time.Now,
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+// Start schedules the steps of the escalation. Each step is sent after its delay, starting from the previous step,
+// unless the escalation was acknowledged
+func (s *Scheduler) Start(escalation Escalation, delays []time.Duration, send StepFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prune()
+	escalation.Steps = len(delays)
+	if len(delays) == 0 {
+		escalation.Status = StatusCompleted
+	}
+	s.escalations[escalation.Id] = &escalation
+	if len(delays) > 0 {
+		s.schedule(escalation.Id, 1, delays, send)
+	}
+}
+
+// schedule sends the step after its delay. The lock must be held
+func (s *Scheduler) schedule(id string, step int, delays []time.Duration, send StepFunc) {
+	s.afterFunc(delays[step-1], func() {
+		s.mutex.Lock()
+		escalation, exists := s.escalations[id]
+		if !exists || escalation.Status != StatusPending {
+			s.mutex.Unlock()
+			return
+		}
+		escalation.Step = step
+		if step == len(delays) {
+			escalation.Status = StatusCompleted
+		} else {
+			s.schedule(id, step+1, delays, send)
+		}
+		current := *escalation
+		s.mutex.Unlock()
+		send(current, step)
+	})
+}
+
+// prune removes the escalations finished for longer than the retention. The lock must be held
+func (s *Scheduler) prune() {
+	limit := s.now().Add(-s.retention)
+	for id, escalation := range s.escalations {
+		if escalation.Status != StatusPending && escalation.StartedAt.Before(limit) {
+			delete(s.escalations, id)
+		}
+	}
+}
+
+// Acknowledge cancels the remaining steps of the escalation
+func (s *Scheduler) Acknowledge(id string, by string) (*Escalation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	escalation, exists := s.escalations[id]
+	if !exists {
+		return nil, fmt.Errorf("escalation '%s' does not exist, it finished more than the retention ago or the server restarted since it started", id)
+	}
+	if escalation.Status != StatusAcknowledged {
+		escalation.Status = StatusAcknowledged
+		escalation.AcknowledgedAt = s.now().UTC()
+		escalation.AcknowledgedBy = by
+	}
+	current := *escalation
+	return &current, nil
+}
+
+// List returns the escalations, the most recent first
+func (s *Scheduler) List() []Escalation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := []Escalation{}
+	for _, escalation := range s.escalations {
+		result = append(result, *escalation)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartedAt.After(result[j].StartedAt)
+	})
+	return result
+}
+
+// Sign returns the signature of the acknowledgement link of the escalation
+func Sign(key string, id string, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(fmt.Sprintf("%s|%v", id, expires.Unix())))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns an error when the signature of the acknowledgement link is invalid or the link expired
+func Verify(key string, id string, expires string, signature string, now time.Time) error {
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiration '%s'", expires)
+	}
+	expected := Sign(key, id, time.Unix(expiresUnix, 0))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+	if now.After(time.Unix(expiresUnix, 0)) {
+		return fmt.Errorf("the acknowledgement link expired")
+	}
+	return nil
+}
+
+// AckUrl returns the signed acknowledgement link of the escalation
+func AckUrl(baseUrl string, key string, id string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("expires", fmt.Sprint(expires.Unix()))
+	query.Set("signature", Sign(key, id, expires))
+	return fmt.Sprintf("%s/api/v1/escalations/%s/ack?%s", strings.TrimSuffix(baseUrl, "/"), url.PathEscape(id), query.Encode())
+}
+
+type ctxSchedulerKey struct{}
+
+// FromCtx returns the escalation scheduler stored in the context or nil when no notification is escalated
+func FromCtx(ctx context.Context) *Scheduler {
+	if s, ok := ctx.Value(ctxSchedulerKey{}).(*Scheduler); ok {
+		return s
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, s *Scheduler) context.Context {
+	return context.WithValue(ctx, ctxSchedulerKey{}, s)
+}
@@ -0,0 +1,137 @@
+package escalation
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	signature := Sign("key", "id1", expires)
+	tests := []struct {
+		name      string
+		id        string
+		expires   string
+		signature string
+		now       time.Time
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			id:        "id1",
+			expires:   fmt.Sprint(expires.Unix()),
+			signature: signature,
+			now:       now,
+		},
+		{
+			name:      "other escalation",
+			id:        "id2",
+			expires:   fmt.Sprint(expires.Unix()),
+			signature: signature,
+			now:       now,
+			wantErr:   true,
+		},
+		{
+			name:      "extended expiration",
+			id:        "id1",
+			expires:   fmt.Sprint(expires.Add(time.Hour).Unix()),
+			signature: signature,
+			now:       now,
+			wantErr:   true,
+		},
+		{
+			name:      "expired",
+			id:        "id1",
+			expires:   fmt.Sprint(expires.Unix()),
+			signature: signature,
+			now:       expires.Add(time.Second),
+			wantErr:   true,
+		},
+		{
+			name:    "missing signature",
+			id:      "id1",
+			expires: fmt.Sprint(expires.Unix()),
+			now:     now,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify("key", tt.id, tt.expires, tt.signature, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAckUrl(t *testing.T) {
+	expires := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ackUrl := AckUrl("https://knot.example.com/", "key", "id1", expires)
+	parsed, err := url.Parse(ackUrl)
+	if err != nil {
+		t.Fatalf("AckUrl() = %v is not a valid url - %v", ackUrl, err)
+	}
+	if parsed.Path != "/api/v1/escalations/id1/ack" {
+		t.Errorf("AckUrl() path = %v", parsed.Path)
+	}
+	err = Verify("key", "id1", parsed.Query().Get("expires"), parsed.Query().Get("signature"), expires)
+	if err != nil {
+		t.Errorf("AckUrl() = %v is not valid - %v", ackUrl, err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	scheduler := NewScheduler()
+	pending := []func(){}
+	scheduler.afterFunc = func(d time.Duration, f func()) {
+		pending = append(pending, f)
+	}
+	runNext := func() {
+		f := pending[0]
+		pending = pending[1:]
+		f()
+	}
+	sent := []int{}
+	send := func(escalation Escalation, step int) {
+		sent = append(sent, step)
+	}
+
+	completed := New("n1", "p1", 0, time.Now())
+	scheduler.Start(completed, []time.Duration{time.Minute, time.Minute}, send)
+	runNext()
+	runNext()
+	if fmt.Sprint(sent) != "[1 2]" || len(pending) != 0 {
+		t.Fatalf("Scheduler sent steps %v, want [1 2]", sent)
+	}
+
+	acknowledged := New("n1", "p1", 0, time.Now())
+	scheduler.Start(acknowledged, []time.Duration{time.Minute, time.Minute}, send)
+	runNext()
+	esc, err := scheduler.Acknowledge(acknowledged.Id, "me")
+	if err != nil || esc.Status != StatusAcknowledged || esc.AcknowledgedBy != "me" || esc.Step != 1 {
+		t.Fatalf("Scheduler.Acknowledge() = %v, %v", esc, err)
+	}
+	for len(pending) > 0 {
+		runNext()
+	}
+	if fmt.Sprint(sent) != "[1 2 1]" {
+		t.Errorf("Scheduler sent steps %v after the acknowledgement, want [1 2 1]", sent)
+	}
+
+	statuses := map[string]string{}
+	for _, e := range scheduler.List() {
+		statuses[e.Id] = e.Status
+	}
+	if statuses[completed.Id] != StatusCompleted || statuses[acknowledged.Id] != StatusAcknowledged {
+		t.Errorf("Scheduler.List() statuses = %v", statuses)
+	}
+
+	_, err = scheduler.Acknowledge("missing", "me")
+	if err == nil {
+		t.Errorf("Scheduler.Acknowledge() of a missing escalation should return an error")
+	}
+}
@@ -113,6 +113,16 @@ func (c *GitHubConfiguration) WritePullRequestComment(body string) (*github.Issu
 
 }
 
+// ApiError is returned when a github api call fails. StatusCode is 0 when no response was received
+type ApiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ApiError) Error() string {
+	return e.Message
+}
+
 func (c *GitHubConfiguration) checkHttpResponse(_ interface{}, resp *github.Response, err error) (string, error) {
 	respBodyString := ""
 	if err != nil {
@@ -124,11 +134,15 @@ func (c *GitHubConfiguration) checkHttpResponse(_ interface{}, resp *github.Resp
 			respBodyString = err.Error()
 		}
 
-		return respBodyString, fmt.Errorf("github api error. Response Body: %v", respBodyString)
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		return respBodyString, &ApiError{StatusCode: statusCode, Message: fmt.Sprintf("github api error. Response Body: %v", respBodyString)}
 	}
 
 	if resp.StatusCode <= 199 || resp.StatusCode >= 400 {
-		return respBodyString, fmt.Errorf("github API call failed. Status Code: %v. Response Body: %v", resp.StatusCode, respBodyString)
+		return respBodyString, &ApiError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("github API call failed. Status Code: %v. Response Body: %v", resp.StatusCode, respBodyString)}
 	}
 
 	return respBodyString, nil
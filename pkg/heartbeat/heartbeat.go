@@ -0,0 +1,312 @@
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/clock"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/matcher"
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+const (
+	// StatusOk is used when the expected message arrived in time
+	StatusOk = "ok"
+	// StatusPending is used when no message arrived since the heartbeat started being monitored and it is not late yet
+	StatusPending = "pending"
+	// StatusLate is used when the expected message did not arrive in time
+	StatusLate = "late"
+)
+
+// State is what is persisted for each heartbeat
+type State struct {
+	// Since is when the heartbeat started being monitored
+	Since time.Time `json:"since" yaml:"since"`
+	// LastSeen is when the last matching message arrived
+	LastSeen time.Time `json:"lastSeen,omitempty" yaml:"lastSeen,omitempty"`
+	// LastMessageId is the id of the last matching message
+	LastMessageId string `json:"lastMessageId,omitempty" yaml:"lastMessageId,omitempty"`
+	// LastFired is when the notification was last sent
+	LastFired time.Time `json:"lastFired,omitempty" yaml:"lastFired,omitempty"`
+}
+
+// Status is the status of a heartbeat
+type Status struct {
+	Name   string `json:"name" yaml:"name"`
+	Status string `json:"status" yaml:"status"`
+	State  `yaml:",inline"`
+	// ExpectedBy is when the notification is sent if no matching message arrives
+	ExpectedBy time.Time `json:"expectedBy" yaml:"expectedBy"`
+}
+
+// FireFunc sends the notification of the late heartbeat with the data
+type FireFunc func(heartbeat config.Heartbeat, data *message.NotificationData) error
+
+// Monitor tracks the messages expected by the heartbeats. The state is persisted in a json file when a heartbeat
+// receives its first message or recovers, and otherwise when the heartbeats are checked
+type Monitor struct {
+	cfg    *config.HeartbeatConfiguration
+	mutex  sync.Mutex
+	states map[string]State
+	// dirty is true when the states changed since they were saved
+	dirty bool
+}
+
+// NewMonitor creates the monitor and loads the persisted state
+func NewMonitor(cfg *config.HeartbeatConfiguration) (*Monitor, error) {
+	m := &Monitor{
+		cfg:    cfg,
+		states: map[string]State{},
+	}
+	if cfg.File == "" {
+		return m, nil
+	}
+	err := os.MkdirAll(filepath.Dir(cfg.File), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory of the heartbeat file '%s' - %v", cfg.File, err)
+	}
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("failed to read the heartbeat file '%s' - %v", cfg.File, err)
+	}
+	err = json.Unmarshal(data, &m.states)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the heartbeat file '%s' - %v", cfg.File, err)
+	}
+	return m, nil
+}
+
+// save writes the state to the file. The lock must be held
+func (m *Monitor) save() error {
+	if m.cfg.File == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal the heartbeat state - %v", err)
+	}
+	// write to a temporary file first so a partially written file is never read
+	tmp := m.cfg.File + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write the heartbeat file '%s' - %v", m.cfg.File, err)
+	}
+	err = os.Rename(tmp, m.cfg.File)
+	if err != nil {
+		return fmt.Errorf("failed to write the heartbeat file '%s' - %v", m.cfg.File, err)
+	}
+	m.dirty = false
+	return nil
+}
+
+// Flush saves the states that changed since they were last saved
+func (m *Monitor) Flush() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	return m.save()
+}
+
+// state returns the state of the heartbeat, starting to monitor it when it has no state. The lock must be held
+func (m *Monitor) state(name string, now time.Time) State {
+	state, exists := m.states[name]
+	if !exists {
+		state = State{Since: now}
+		m.states[name] = state
+		m.dirty = true
+	}
+	return state
+}
+
+// Observe records the message for the heartbeats it matches. The matchers are evaluated without holding the lock.
+// The state is saved right away when a heartbeat receives its first message or was late, the other messages are
+// saved when the heartbeats are checked
+func (m *Monitor) Observe(ctx context.Context, data *message.NotificationData) error {
+	now := clock.Now(ctx).UTC()
+	var errs []error
+	matched := []config.Heartbeat{}
+	for _, heartbeat := range m.cfg.Monitors {
+		matches, err := matcher.MatchesExpression(ctx, heartbeat.Matcher, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("heartbeat '%s' - %v", heartbeat.Name, err))
+			continue
+		}
+		if matches {
+			matched = append(matched, heartbeat)
+		}
+	}
+
+	m.mutex.Lock()
+	recovered := false
+	for _, heartbeat := range matched {
+		state := m.state(heartbeat.Name, now)
+		status, _, _ := getStatus(heartbeat, state, now)
+		recovered = recovered || status != StatusOk
+		state.LastSeen = now
+		state.LastMessageId = data.ID
+		m.states[heartbeat.Name] = state
+		m.dirty = true
+	}
+	if recovered {
+		err := m.save()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	m.mutex.Unlock()
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to observe the message for the heartbeats - %v", errs)
+	}
+	return nil
+}
+
+// Check sends the notification of the heartbeats that are late. The notification is sent again every interval until
+// a matching message arrives. The lock is not held while the notifications are sent so the messages can still be
+// observed while a notification is retried or delayed
+func (m *Monitor) Check(ctx context.Context, fire FireFunc) error {
+	type lateHeartbeat struct {
+		heartbeat config.Heartbeat
+		data      *message.NotificationData
+	}
+	now := clock.Now(ctx).UTC()
+	var errs []error
+	late := []lateHeartbeat{}
+
+	m.mutex.Lock()
+	for _, heartbeat := range m.cfg.Monitors {
+		state := m.state(heartbeat.Name, now)
+		status, expectedBy, err := getStatus(heartbeat, state, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("heartbeat '%s' - %v", heartbeat.Name, err))
+			continue
+		}
+		if status != StatusLate {
+			continue
+		}
+		interval, _ := heartbeat.GetInterval()
+		if state.LastFired.After(expectedBy) && now.Before(state.LastFired.Add(interval)) {
+			// the notification was already sent since the heartbeat is late
+			continue
+		}
+		lastSeen := ""
+		if !state.LastSeen.IsZero() {
+			lastSeen = state.LastSeen.Format(time.RFC3339)
+		}
+		late = append(late, lateHeartbeat{
+			heartbeat: heartbeat,
+			data: &message.NotificationData{
+				ID: fmt.Sprintf("heartbeat/%s/%v", heartbeat.Name, now.Unix()),
+				Data: map[string]interface{}{
+					"heartbeat":     heartbeat.Name,
+					"lastSeen":      lastSeen,
+					"lastMessageId": state.LastMessageId,
+					"expectedBy":    expectedBy.Format(time.RFC3339),
+				},
+				Attributes: map[string]string{},
+			},
+		})
+	}
+	m.mutex.Unlock()
+
+	fired := []string{}
+	for _, l := range late {
+		err := fire(l.heartbeat, l.data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("heartbeat '%s' - %v", l.heartbeat.Name, err))
+			continue
+		}
+		fired = append(fired, l.heartbeat.Name)
+	}
+
+	m.mutex.Lock()
+	for _, name := range fired {
+		// the state is read again as messages may have been observed while the notifications were sent
+		state := m.state(name, now)
+		state.LastFired = now
+		m.states[name] = state
+		m.dirty = true
+	}
+	var err error
+	if m.dirty {
+		err = m.save()
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to check the heartbeats - %v", errs)
+	}
+	return nil
+}
+
+// Status returns the status of every heartbeat, ordered by name
+func (m *Monitor) Status(ctx context.Context) []Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := clock.Now(ctx).UTC()
+	result := []Status{}
+	for _, heartbeat := range m.cfg.Monitors {
+		state := m.state(heartbeat.Name, now)
+		status, expectedBy, _ := getStatus(heartbeat, state, now)
+		result = append(result, Status{
+			Name:       heartbeat.Name,
+			Status:     status,
+			State:      state,
+			ExpectedBy: expectedBy,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func getStatus(heartbeat config.Heartbeat, state State, now time.Time) (string, time.Time, error) {
+	interval, err := heartbeat.GetInterval()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	gracePeriod, err := heartbeat.GetGracePeriod()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	last := state.LastSeen
+	if last.IsZero() {
+		last = state.Since
+	}
+	expectedBy := last.Add(interval).Add(gracePeriod)
+	if now.After(expectedBy) {
+		return StatusLate, expectedBy, nil
+	}
+	if state.LastSeen.IsZero() {
+		return StatusPending, expectedBy, nil
+	}
+	return StatusOk, expectedBy, nil
+}
+
+type ctxMonitorKey struct{}
+
+// FromCtx returns the heartbeat monitor stored in the context or nil when heartbeats are not enabled
+func FromCtx(ctx context.Context) *Monitor {
+	if m, ok := ctx.Value(ctxMonitorKey{}).(*Monitor); ok {
+		return m
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, m *Monitor) context.Context {
+	return context.WithValue(ctx, ctxMonitorKey{}, m)
+}
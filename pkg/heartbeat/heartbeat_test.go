@@ -0,0 +1,165 @@
+package heartbeat
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/clock"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+func TestMonitor(t *testing.T) {
+	cfg := &config.HeartbeatConfiguration{
+		File: filepath.Join(t.TempDir(), "heartbeats.json"),
+		Monitors: []config.Heartbeat{
+			{
+				Name:         "nightly",
+				Matcher:      `data.pipeline == "nightly"`,
+				Interval:     "24h",
+				GracePeriod:  "1h",
+				Notification: "log",
+			},
+		},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := clock.WithCtx(context.Background(), func() time.Time { return now })
+	fired := []*message.NotificationData{}
+	fire := func(heartbeat config.Heartbeat, data *message.NotificationData) error {
+		fired = append(fired, data)
+		return nil
+	}
+	monitor, err := NewMonitor(cfg)
+	if err != nil {
+		t.Fatalf("NewMonitor() error = %v", err)
+	}
+
+	check := func(wantStatus string, wantFired int) {
+		t.Helper()
+		err := monitor.Check(ctx, fire)
+		if err != nil {
+			t.Fatalf("Monitor.Check() error = %v", err)
+		}
+		if len(fired) != wantFired {
+			t.Fatalf("Monitor.Check() fired %v notifications at %v, want %v", len(fired), now, wantFired)
+		}
+		status := monitor.Status(ctx)
+		if len(status) != 1 || status[0].Status != wantStatus {
+			t.Fatalf("Monitor.Status() = %v at %v, want %v", status, now, wantStatus)
+		}
+	}
+
+	check(StatusPending, 0)
+
+	now = now.Add(12 * time.Hour)
+	err = monitor.Observe(ctx, &message.NotificationData{ID: "1", Data: map[string]interface{}{"pipeline": "nightly"}})
+	if err != nil {
+		t.Fatalf("Monitor.Observe() error = %v", err)
+	}
+	err = monitor.Observe(ctx, &message.NotificationData{ID: "2", Data: map[string]interface{}{"pipeline": "other"}})
+	if err != nil {
+		t.Fatalf("Monitor.Observe() error = %v", err)
+	}
+	check(StatusOk, 0)
+
+	// within the grace period
+	now = now.Add(24*time.Hour + 30*time.Minute)
+	check(StatusOk, 0)
+
+	now = now.Add(time.Hour)
+	check(StatusLate, 1)
+	if fired[0].Data["lastMessageId"] != "1" || fired[0].Data["expectedBy"] != "2024-01-02T13:00:00Z" {
+		t.Errorf("Monitor.Check() data = %v", fired[0].Data)
+	}
+
+	// the notification is not sent again before the interval
+	now = now.Add(time.Hour)
+	check(StatusLate, 1)
+
+	// the state is persisted
+	monitor, err = NewMonitor(cfg)
+	if err != nil {
+		t.Fatalf("NewMonitor() error = %v", err)
+	}
+	now = now.Add(24 * time.Hour)
+	check(StatusLate, 2)
+
+	// messages are observed while the notification is sent
+	now = now.Add(24 * time.Hour)
+	err = monitor.Check(ctx, func(heartbeat config.Heartbeat, data *message.NotificationData) error {
+		return monitor.Observe(ctx, &message.NotificationData{ID: "3", Data: map[string]interface{}{"pipeline": "nightly"}})
+	})
+	if err != nil {
+		t.Fatalf("Monitor.Check() error = %v", err)
+	}
+	status := monitor.Status(ctx)
+	if status[0].Status != StatusOk || status[0].State.LastMessageId != "3" || !status[0].State.LastFired.Equal(now) {
+		t.Errorf("Monitor.Status() = %v, want the message observed while firing and the fired time", status)
+	}
+}
+
+func TestMonitor_Save(t *testing.T) {
+	cfg := &config.HeartbeatConfiguration{
+		File: filepath.Join(t.TempDir(), "heartbeats.json"),
+		Monitors: []config.Heartbeat{
+			{Name: "nightly", Matcher: `data.pipeline == "nightly"`, Interval: "24h"},
+		},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := clock.WithCtx(context.Background(), func() time.Time { return now })
+	monitor, err := NewMonitor(cfg)
+	if err != nil {
+		t.Fatalf("NewMonitor() error = %v", err)
+	}
+	saved := func() string {
+		t.Helper()
+		loaded, err := NewMonitor(cfg)
+		if err != nil {
+			t.Fatalf("NewMonitor() error = %v", err)
+		}
+		return loaded.states["nightly"].LastMessageId
+	}
+	observe := func(id string) {
+		t.Helper()
+		err := monitor.Observe(ctx, &message.NotificationData{ID: id, Data: map[string]interface{}{"pipeline": "nightly"}})
+		if err != nil {
+			t.Fatalf("Monitor.Observe() error = %v", err)
+		}
+	}
+
+	// the first message is saved right away
+	observe("1")
+	if got := saved(); got != "1" {
+		t.Fatalf("saved message = %v, want 1", got)
+	}
+	// the next messages are saved when the heartbeats are checked
+	now = now.Add(time.Hour)
+	observe("2")
+	if got := saved(); got != "1" {
+		t.Fatalf("saved message = %v, want 1", got)
+	}
+	err = monitor.Check(ctx, func(heartbeat config.Heartbeat, data *message.NotificationData) error { return nil })
+	if err != nil {
+		t.Fatalf("Monitor.Check() error = %v", err)
+	}
+	if got := saved(); got != "2" {
+		t.Fatalf("saved message = %v, want 2", got)
+	}
+	// a late heartbeat that recovers is saved right away
+	now = now.Add(48 * time.Hour)
+	observe("3")
+	if got := saved(); got != "3" {
+		t.Fatalf("saved message = %v, want 3", got)
+	}
+	now = now.Add(time.Hour)
+	observe("4")
+	err = monitor.Flush()
+	if err != nil {
+		t.Fatalf("Monitor.Flush() error = %v", err)
+	}
+	if got := saved(); got != "4" {
+		t.Fatalf("saved message = %v, want 4", got)
+	}
+}
@@ -0,0 +1,216 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	gohttp "net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/http"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+const (
+	StructuredContentType = "application/cloudevents+json"
+	BatchContentType      = "application/cloudevents-batch+json"
+	BinaryHeaderPrefix    = "ce-"
+
+	dataAttribute       = "data"
+	dataBase64Attribute = "data_base64"
+)
+
+var requiredAttributes = []string{"specversion", "id", "source", "type"}
+
+type Listener struct {
+	Name    string
+	ApiPath string
+}
+
+func New() *Listener {
+	return &Listener{
+		Name:    "cloudevents",
+		ApiPath: "cloudevents",
+	}
+}
+
+func (v *Listener) Initialize(ctx context.Context) error {
+	return nil
+}
+
+func (v *Listener) GetName() string {
+	return v.Name
+}
+
+func (v *Listener) GetApiPath() string {
+	return v.ApiPath
+}
+
+// ParsePayload parses a single structured mode cloud event
+// https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/formats/json-format.md
+func (v *Listener) ParsePayload(ctx context.Context, log *zap.Logger, payload []byte) (*message.NotificationData, *http.ErrorDetail) {
+	event := map[string]interface{}{}
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, v.newErrorDetail(log, "unmarshal-body-data", "Unmarshal Body Data", fmt.Sprintf("Failed to unmarshal body to a structured cloud event. Error: %v", err))
+	}
+	return v.structuredToNotificationData(log, event)
+}
+
+// ParseRequest parses binary, structured and batched mode cloud events. The mode is determined by the content type
+// of the request
+// https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/bindings/http-protocol-binding.md
+func (v *Listener) ParseRequest(ctx context.Context, log *zap.Logger, request *gohttp.Request, payload []byte) ([]*message.NotificationData, *http.ErrorDetail) {
+	contentType := getMediaType(request.Header.Get("Content-Type"))
+	switch contentType {
+	case StructuredContentType:
+		notifyData, errD := v.ParsePayload(ctx, log, payload)
+		if errD != nil {
+			return nil, errD
+		}
+		return []*message.NotificationData{notifyData}, nil
+
+	case BatchContentType:
+		events := []map[string]interface{}{}
+		err := json.Unmarshal(payload, &events)
+		if err != nil {
+			return nil, v.newErrorDetail(log, "unmarshal-body-data", "Unmarshal Body Data", fmt.Sprintf("Failed to unmarshal body to a batch of structured cloud events. Error: %v", err))
+		}
+		results := []*message.NotificationData{}
+		for _, event := range events {
+			notifyData, errD := v.structuredToNotificationData(log, event)
+			if errD != nil {
+				return nil, errD
+			}
+			results = append(results, notifyData)
+		}
+		return results, nil
+	}
+
+	notifyData, errD := v.binaryToNotificationData(log, request.Header, payload)
+	if errD != nil {
+		return nil, errD
+	}
+	return []*message.NotificationData{notifyData}, nil
+}
+
+func (v *Listener) structuredToNotificationData(log *zap.Logger, event map[string]interface{}) (*message.NotificationData, *http.ErrorDetail) {
+	attributes := map[string]string{}
+	for key, val := range event {
+		if key == dataAttribute || key == dataBase64Attribute || val == nil {
+			continue
+		}
+		if strVal, ok := val.(string); ok {
+			attributes[key] = strVal
+			continue
+		}
+		attributes[key] = fmt.Sprintf("%v", val)
+	}
+
+	var data []byte
+	if base64Data, exists := event[dataBase64Attribute]; exists {
+		strVal, _ := base64Data.(string)
+		decoded, err := base64.StdEncoding.DecodeString(strVal)
+		if err != nil {
+			return nil, v.newErrorDetail(log, "convert-cloudevent", "Convert Cloud Event", fmt.Sprintf("failed to decode the data_base64 property of the cloud event - %v", err))
+		}
+		data = decoded
+	} else if rawData, exists := event[dataAttribute]; exists && rawData != nil {
+		if strVal, ok := rawData.(string); ok && !isJsonContentType(attributes["datacontenttype"]) {
+			data = []byte(strVal)
+		} else {
+			data, _ = json.Marshal(rawData)
+		}
+	}
+	return v.toNotificationData(log, attributes, data)
+}
+
+func (v *Listener) binaryToNotificationData(log *zap.Logger, headers gohttp.Header, payload []byte) (*message.NotificationData, *http.ErrorDetail) {
+	attributes := map[string]string{}
+	for name, values := range headers {
+		lowerName := strings.ToLower(name)
+		if !strings.HasPrefix(lowerName, BinaryHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+		attributes[strings.TrimPrefix(lowerName, BinaryHeaderPrefix)] = values[0]
+	}
+	if contentType := headers.Get("Content-Type"); contentType != "" {
+		attributes["datacontenttype"] = contentType
+	}
+	return v.toNotificationData(log, attributes, payload)
+}
+
+func (v *Listener) toNotificationData(log *zap.Logger, attributes map[string]string, data []byte) (*message.NotificationData, *http.ErrorDetail) {
+	missing := []string{}
+	for _, name := range requiredAttributes {
+		if attributes[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, v.newErrorDetail(log, "convert-cloudevent", "Convert Cloud Event", fmt.Sprintf("the cloud event is missing the following required attributes: %s", strings.Join(missing, ", ")))
+	}
+
+	notifyData := &message.NotificationData{
+		Attributes: attributes,
+		ID:         attributes["id"],
+		Data:       map[string]interface{}{},
+	}
+	if publishTime, err := time.Parse(time.RFC3339Nano, attributes["time"]); err == nil {
+		notifyData.Meta = &message.Meta{PublishTime: publishTime}
+	}
+	if len(data) == 0 {
+		return notifyData, nil
+	}
+
+	if !isJsonContentType(attributes["datacontenttype"]) {
+		notifyData.Data[message.DataValueKey] = string(data)
+		return notifyData, nil
+	}
+
+	var decoded interface{}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return nil, v.newErrorDetail(log, "convert-cloudevent", "Convert Cloud Event", fmt.Sprintf("failed to unmarshal the data of the cloud event - %v", err))
+	}
+	if mapVal, ok := decoded.(map[string]interface{}); ok {
+		notifyData.Data = mapVal
+	} else {
+		notifyData.Data[message.DataValueKey] = decoded
+	}
+	return notifyData, nil
+}
+
+func (v *Listener) newErrorDetail(log *zap.Logger, errType string, title string, detail string) *http.ErrorDetail {
+	log.Error(detail)
+	return &http.ErrorDetail{
+		Type:     errType,
+		Title:    title,
+		Status:   400,
+		Detail:   detail,
+		Instance: v.GetApiPath(),
+	}
+}
+
+func getMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// isJsonContentType returns true when the data content type is empty (JSON is implied) or describes JSON
+func isJsonContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType := getMediaType(contentType)
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
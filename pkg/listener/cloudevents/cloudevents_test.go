@@ -0,0 +1,232 @@
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestListener_ParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		payload string
+		want    []*message.NotificationData
+		wantErr string
+	}{
+		{
+			name: "binary mode",
+			headers: map[string]string{
+				"Content-Type":   "application/json",
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "1",
+				"Ce-Source":      "/tekton/pipelines",
+				"Ce-Type":        "dev.tekton.event.pipelinerun.successful.v1",
+				"Ce-Subject":     "my-pipeline-run",
+				"X-Other":        "ignored",
+			},
+			payload: `{"test":"123"}`,
+			want: []*message.NotificationData{
+				{
+					ID: "1",
+					Attributes: map[string]string{
+						"specversion":     "1.0",
+						"id":              "1",
+						"source":          "/tekton/pipelines",
+						"type":            "dev.tekton.event.pipelinerun.successful.v1",
+						"subject":         "my-pipeline-run",
+						"datacontenttype": "application/json",
+					},
+					Data: map[string]interface{}{
+						"test": "123",
+					},
+				},
+			},
+		},
+		{
+			name: "binary mode with time",
+			headers: map[string]string{
+				"Content-Type":   "text/plain",
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "1",
+				"Ce-Source":      "source",
+				"Ce-Type":        "type",
+				"Ce-Time":        "2024-01-02T03:04:05Z",
+			},
+			payload: `hello`,
+			want: []*message.NotificationData{
+				{
+					ID: "1",
+					Attributes: map[string]string{
+						"specversion":     "1.0",
+						"id":              "1",
+						"source":          "source",
+						"type":            "type",
+						"time":            "2024-01-02T03:04:05Z",
+						"datacontenttype": "text/plain",
+					},
+					Data: map[string]interface{}{
+						"value": "hello",
+					},
+					Meta: &message.Meta{PublishTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+				},
+			},
+		},
+		{
+			name: "binary mode with non json data",
+			headers: map[string]string{
+				"Content-Type":   "text/plain",
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "1",
+				"Ce-Source":      "source",
+				"Ce-Type":        "type",
+			},
+			payload: `hello`,
+			want: []*message.NotificationData{
+				{
+					ID: "1",
+					Attributes: map[string]string{
+						"specversion":     "1.0",
+						"id":              "1",
+						"source":          "source",
+						"type":            "type",
+						"datacontenttype": "text/plain",
+					},
+					Data: map[string]interface{}{
+						"value": "hello",
+					},
+				},
+			},
+		},
+		{
+			name: "binary mode missing attributes",
+			headers: map[string]string{
+				"Content-Type":   "application/json",
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "1",
+			},
+			payload: `{"test":"123"}`,
+			wantErr: "the cloud event is missing the following required attributes: source, type",
+		},
+		{
+			name: "structured mode",
+			headers: map[string]string{
+				"Content-Type": "application/cloudevents+json; charset=UTF-8",
+			},
+			payload: `{"specversion":"1.0","id":"2","source":"eventarc","type":"google.cloud.storage.object.v1.finalized","subject":"objects/file.txt","data":{"bucket":"b1"}}`,
+			want: []*message.NotificationData{
+				{
+					ID: "2",
+					Attributes: map[string]string{
+						"specversion": "1.0",
+						"id":          "2",
+						"source":      "eventarc",
+						"type":        "google.cloud.storage.object.v1.finalized",
+						"subject":     "objects/file.txt",
+					},
+					Data: map[string]interface{}{
+						"bucket": "b1",
+					},
+				},
+			},
+		},
+		{
+			name: "structured mode with base64 data",
+			headers: map[string]string{
+				"Content-Type": "application/cloudevents+json",
+			},
+			payload: `{"specversion":"1.0","id":"3","source":"s","type":"t","data_base64":"eyJ0ZXN0IjoiMTIzIn0="}`,
+			want: []*message.NotificationData{
+				{
+					ID: "3",
+					Attributes: map[string]string{
+						"specversion": "1.0",
+						"id":          "3",
+						"source":      "s",
+						"type":        "t",
+					},
+					Data: map[string]interface{}{
+						"test": "123",
+					},
+				},
+			},
+		},
+		{
+			name: "structured mode invalid json",
+			headers: map[string]string{
+				"Content-Type": "application/cloudevents+json",
+			},
+			payload: `dude`,
+			wantErr: "Failed to unmarshal body to a structured cloud event. Error: invalid character 'd' looking for beginning of value",
+		},
+		{
+			name: "batched mode",
+			headers: map[string]string{
+				"Content-Type": "application/cloudevents-batch+json",
+			},
+			payload: `[{"specversion":"1.0","id":"1","source":"s","type":"t"},{"specversion":"1.0","id":"2","source":"s","type":"t","data":{"test":"123"}}]`,
+			want: []*message.NotificationData{
+				{
+					ID: "1",
+					Attributes: map[string]string{
+						"specversion": "1.0",
+						"id":          "1",
+						"source":      "s",
+						"type":        "t",
+					},
+					Data: map[string]interface{}{},
+				},
+				{
+					ID: "2",
+					Attributes: map[string]string{
+						"specversion": "1.0",
+						"id":          "2",
+						"source":      "s",
+						"type":        "t",
+					},
+					Data: map[string]interface{}{
+						"test": "123",
+					},
+				},
+			},
+		},
+		{
+			name: "batched mode with invalid event",
+			headers: map[string]string{
+				"Content-Type": "application/cloudevents-batch+json",
+			},
+			payload: `[{"specversion":"1.0","id":"1","source":"s","type":"t"},{"specversion":"1.0","source":"s","type":"t"}]`,
+			wantErr: "the cloud event is missing the following required attributes: id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testLogger := zaptest.NewLogger(t)
+			request, _ := http.NewRequest("POST", "/api/v1/cloudevents", bytes.NewReader([]byte(tt.payload)))
+			for key, val := range tt.headers {
+				request.Header.Set(key, val)
+			}
+
+			l := New()
+			got, errD := l.ParseRequest(context.Background(), testLogger, request, []byte(tt.payload))
+
+			if errD == nil {
+				if tt.wantErr != "" {
+					t.Errorf("Listener.ParseRequest() err = nil, want %v", tt.wantErr)
+				}
+			} else if errD.Detail != tt.wantErr {
+				t.Errorf("Listener.ParseRequest() err = %v, want %v", errD.Detail, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Listener.ParseRequest() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package cloudmonitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gohttp "net/http"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/http"
+	"github.com/kcloutie/knot/pkg/maps"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// Payload is the body of a google cloud monitoring webhook notification
+// https://cloud.google.com/monitoring/support/notification-options#webhooks
+type Payload struct {
+	Incident *Incident `json:"incident,omitempty"`
+	Version  string    `json:"version,omitempty"`
+}
+
+type Incident struct {
+	IncidentId       string            `json:"incident_id,omitempty"`
+	ScopingProjectId string            `json:"scoping_project_id,omitempty"`
+	Url              string            `json:"url,omitempty"`
+	State            string            `json:"state,omitempty"`
+	Summary          string            `json:"summary,omitempty"`
+	PolicyName       string            `json:"policy_name,omitempty"`
+	ConditionName    string            `json:"condition_name,omitempty"`
+	Resource         *LabeledType      `json:"resource,omitempty"`
+	Metric           *LabeledType      `json:"metric,omitempty"`
+	PolicyUserLabels map[string]string `json:"policy_user_labels,omitempty"`
+}
+
+type LabeledType struct {
+	Type        string            `json:"type,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type Listener struct {
+	Name    string
+	ApiPath string
+	Config  config.AlertListenerConfiguration
+}
+
+func New(cfg config.AlertListenerConfiguration) *Listener {
+	return &Listener{
+		Name:    "cloudmonitoring",
+		ApiPath: "cloudmonitoring",
+		Config:  cfg,
+	}
+}
+
+func (v *Listener) Initialize(ctx context.Context) error {
+	return nil
+}
+
+func (v *Listener) GetName() string {
+	return v.Name
+}
+
+func (v *Listener) GetApiPath() string {
+	return v.ApiPath
+}
+
+func (v *Listener) Authenticate(ctx context.Context, log *zap.Logger, request *gohttp.Request) *http.ErrorDetail {
+	err := v.Config.Authentication.Authenticate(ctx, log, request)
+	if err != nil {
+		return &http.ErrorDetail{
+			Type:     "authenticate-request",
+			Title:    "Authenticate Request",
+			Status:   401,
+			Detail:   err.Error(),
+			Instance: v.GetApiPath(),
+		}
+	}
+	return nil
+}
+
+func (v *Listener) ParsePayload(ctx context.Context, log *zap.Logger, payload []byte) (*message.NotificationData, *http.ErrorDetail) {
+	request := Payload{}
+	err := json.Unmarshal(payload, &request)
+	if err == nil && request.Incident == nil {
+		err = fmt.Errorf("the incident property is missing")
+	}
+	if err != nil {
+		mess := fmt.Sprintf("Failed to unmarshal body to a cloud monitoring alert payload. Error: %v", err)
+		log.Error(mess)
+		return nil, &http.ErrorDetail{
+			Type:     "unmarshal-body-data",
+			Title:    "Unmarshal Body Data",
+			Status:   400,
+			Detail:   mess,
+			Instance: v.GetApiPath(),
+		}
+	}
+	raw := map[string]interface{}{}
+	_ = json.Unmarshal(payload, &raw)
+
+	notifyData := ToAlert(request).ToNotificationData(v.Name, fmt.Sprintf("%s/%s", request.Incident.IncidentId, request.Incident.State), raw)
+	return &notifyData, nil
+}
+
+// ToAlert normalizes the cloud monitoring payload. The labels are the resource, metric and policy user labels merged
+// together
+func ToAlert(payload Payload) message.Alert {
+	incident := payload.Incident
+	labels := map[string]string{}
+	if incident.Resource != nil {
+		labels = maps.MergeStringMaps(labels, incident.Resource.Labels)
+	}
+	if incident.Metric != nil {
+		labels = maps.MergeStringMaps(labels, incident.Metric.Labels)
+	}
+	labels = maps.MergeStringMaps(labels, incident.PolicyUserLabels)
+
+	status := message.AlertStatusFiring
+	if incident.State == "closed" {
+		status = message.AlertStatusResolved
+	}
+	title := incident.PolicyName
+	if title == "" {
+		title = incident.Summary
+	}
+	return message.Alert{
+		Status:     status,
+		Title:      title,
+		Labels:     labels,
+		Url:        incident.Url,
+		IncidentId: incident.IncidentId,
+	}
+}
@@ -0,0 +1,123 @@
+package cloudmonitoring
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap/zaptest"
+)
+
+const openPayload = `{
+  "incident": {
+    "incident_id": "0.abc",
+    "scoping_project_id": "my-project",
+    "url": "https://console.cloud.google.com/monitoring/alerting/incidents/0.abc",
+    "state": "open",
+    "summary": "CPU utilization for my-instance is above the threshold",
+    "policy_name": "High CPU",
+    "condition_name": "CPU above 90%",
+    "resource": {"type": "gce_instance", "labels": {"instance_id": "1", "zone": "us-east1-b"}},
+    "metric": {"type": "compute.googleapis.com/instance/cpu/utilization", "displayName": "CPU", "labels": {"instance_name": "my-instance"}},
+    "policy_user_labels": {"team": "a"}
+  },
+  "version": "1.2"
+}`
+
+func TestListener_ParsePayload(t *testing.T) {
+	token := "secret"
+	auth := &config.ListenerAuthentication{
+		Token: &config.PropertyAndValue{Value: &token},
+	}
+	tests := []struct {
+		name     string
+		auth     *config.ListenerAuthentication
+		url      string
+		payload  string
+		want     *message.Alert
+		wantId   string
+		wantErr  string
+		wantCode int64
+	}{
+		{
+			name:    "open incident",
+			auth:    auth,
+			url:     "/api/v1/cloudmonitoring?token=secret",
+			payload: openPayload,
+			want: &message.Alert{
+				Status:     "firing",
+				Title:      "High CPU",
+				Labels:     map[string]string{"instance_id": "1", "zone": "us-east1-b", "instance_name": "my-instance", "team": "a"},
+				Url:        "https://console.cloud.google.com/monitoring/alerting/incidents/0.abc",
+				IncidentId: "0.abc",
+			},
+			wantId: "0.abc/open",
+		},
+		{
+			name:    "closed incident",
+			url:     "/api/v1/cloudmonitoring",
+			payload: `{"incident":{"incident_id":"0.abc","state":"closed","summary":"recovered"}}`,
+			want: &message.Alert{
+				Status:     "resolved",
+				Title:      "recovered",
+				Labels:     map[string]string{},
+				IncidentId: "0.abc",
+			},
+			wantId: "0.abc/closed",
+		},
+		{
+			name:     "missing token",
+			auth:     auth,
+			url:      "/api/v1/cloudmonitoring",
+			payload:  openPayload,
+			wantErr:  "the request did not contain valid credentials",
+			wantCode: 401,
+		},
+		{
+			name:     "wrong token",
+			auth:     auth,
+			url:      "/api/v1/cloudmonitoring?token=wrong",
+			payload:  openPayload,
+			wantErr:  "the request did not contain valid credentials",
+			wantCode: 401,
+		},
+		{
+			name:     "not a cloud monitoring payload",
+			url:      "/api/v1/cloudmonitoring",
+			payload:  `{"test":"123"}`,
+			wantErr:  "Failed to unmarshal body to a cloud monitoring alert payload. Error: the incident property is missing",
+			wantCode: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testLogger := zaptest.NewLogger(t)
+			request, _ := http.NewRequest("POST", tt.url, bytes.NewReader([]byte(tt.payload)))
+
+			l := New(config.AlertListenerConfiguration{Authentication: tt.auth})
+			var got *message.NotificationData
+			errD := l.Authenticate(context.Background(), testLogger, request)
+			if errD == nil {
+				got, errD = l.ParsePayload(context.Background(), testLogger, []byte(tt.payload))
+			}
+
+			if errD != nil {
+				if errD.Detail != tt.wantErr || errD.Status != tt.wantCode {
+					t.Errorf("Listener.ParsePayload() err = %v (%v), want %v (%v)", errD.Detail, errD.Status, tt.wantErr, tt.wantCode)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				t.Fatalf("Listener.ParsePayload() err = nil, want %v", tt.wantErr)
+			}
+			want := tt.want.ToNotificationData("cloudmonitoring", tt.wantId, got.Data["payload"].(map[string]interface{}))
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("Listener.ParsePayload() got = %v, want %v", got, want)
+			}
+		})
+	}
+}
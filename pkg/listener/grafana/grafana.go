@@ -0,0 +1,136 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gohttp "net/http"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/http"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+)
+
+// Payload is the body of a grafana unified alerting webhook notification
+// https://grafana.com/docs/grafana/latest/alerting/configure-notifications/manage-contact-points/integrations/webhook-notifier/
+type Payload struct {
+	Receiver          string            `json:"receiver,omitempty"`
+	Status            string            `json:"status,omitempty"`
+	OrgId             int64             `json:"orgId,omitempty"`
+	Alerts            []Alert           `json:"alerts,omitempty"`
+	GroupLabels       map[string]string `json:"groupLabels,omitempty"`
+	CommonLabels      map[string]string `json:"commonLabels,omitempty"`
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+	ExternalURL       string            `json:"externalURL,omitempty"`
+	Version           string            `json:"version,omitempty"`
+	GroupKey          string            `json:"groupKey,omitempty"`
+	TruncatedAlerts   int               `json:"truncatedAlerts,omitempty"`
+	Title             string            `json:"title,omitempty"`
+	State             string            `json:"state,omitempty"`
+	Message           string            `json:"message,omitempty"`
+}
+
+type Alert struct {
+	Status       string            `json:"status,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     string            `json:"startsAt,omitempty"`
+	EndsAt       string            `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+	Fingerprint  string            `json:"fingerprint,omitempty"`
+	SilenceURL   string            `json:"silenceURL,omitempty"`
+	DashboardURL string            `json:"dashboardURL,omitempty"`
+	PanelURL     string            `json:"panelURL,omitempty"`
+}
+
+type Listener struct {
+	Name    string
+	ApiPath string
+	Config  config.AlertListenerConfiguration
+}
+
+func New(cfg config.AlertListenerConfiguration) *Listener {
+	return &Listener{
+		Name:    "grafana",
+		ApiPath: "grafana",
+		Config:  cfg,
+	}
+}
+
+func (v *Listener) Initialize(ctx context.Context) error {
+	return nil
+}
+
+func (v *Listener) GetName() string {
+	return v.Name
+}
+
+func (v *Listener) GetApiPath() string {
+	return v.ApiPath
+}
+
+func (v *Listener) Authenticate(ctx context.Context, log *zap.Logger, request *gohttp.Request) *http.ErrorDetail {
+	err := v.Config.Authentication.Authenticate(ctx, log, request)
+	if err != nil {
+		return &http.ErrorDetail{
+			Type:     "authenticate-request",
+			Title:    "Authenticate Request",
+			Status:   401,
+			Detail:   err.Error(),
+			Instance: v.GetApiPath(),
+		}
+	}
+	return nil
+}
+
+func (v *Listener) ParsePayload(ctx context.Context, log *zap.Logger, payload []byte) (*message.NotificationData, *http.ErrorDetail) {
+	request := Payload{}
+	err := json.Unmarshal(payload, &request)
+	if err == nil && request.Status == "" {
+		err = fmt.Errorf("the status property is missing")
+	}
+	if err != nil {
+		mess := fmt.Sprintf("Failed to unmarshal body to a grafana alert payload. Error: %v", err)
+		log.Error(mess)
+		return nil, &http.ErrorDetail{
+			Type:     "unmarshal-body-data",
+			Title:    "Unmarshal Body Data",
+			Status:   400,
+			Detail:   mess,
+			Instance: v.GetApiPath(),
+		}
+	}
+	raw := map[string]interface{}{}
+	_ = json.Unmarshal(payload, &raw)
+
+	notifyData := ToAlert(request).ToNotificationData(v.Name, fmt.Sprintf("%s/%s", request.GroupKey, request.Status), raw)
+	return &notifyData, nil
+}
+
+// ToAlert normalizes the grafana payload
+func ToAlert(payload Payload) message.Alert {
+	title := payload.Title
+	if title == "" {
+		title = payload.CommonLabels["alertname"]
+	}
+	url := payload.ExternalURL
+	if len(payload.Alerts) > 0 && payload.Alerts[0].GeneratorURL != "" {
+		url = payload.Alerts[0].GeneratorURL
+	}
+	status := message.AlertStatusFiring
+	if payload.Status == "resolved" {
+		status = message.AlertStatusResolved
+	}
+	labels := payload.CommonLabels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return message.Alert{
+		Status:     status,
+		Title:      title,
+		Labels:     labels,
+		Url:        url,
+		IncidentId: payload.GroupKey,
+	}
+}
@@ -0,0 +1,133 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap/zaptest"
+)
+
+const firingPayload = `{
+  "receiver": "knot",
+  "status": "firing",
+  "orgId": 1,
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "HighCPU", "team": "a"},
+      "annotations": {"summary": "cpu is high"},
+      "startsAt": "2023-01-01T00:00:00Z",
+      "generatorURL": "https://grafana/alerting/grafana/abc/view",
+      "fingerprint": "123"
+    }
+  ],
+  "groupLabels": {"alertname": "HighCPU"},
+  "commonLabels": {"alertname": "HighCPU", "team": "a"},
+  "externalURL": "https://grafana/",
+  "version": "1",
+  "groupKey": "{}:{alertname=\"HighCPU\"}",
+  "title": "[FIRING:1] HighCPU"
+}`
+
+func TestListener_ParsePayload(t *testing.T) {
+	password := "secret"
+	username := "knot"
+	auth := &config.ListenerAuthentication{
+		BasicAuth: &config.BasicAuth{
+			Username: config.PropertyAndValue{Value: &username},
+			Password: config.PropertyAndValue{Value: &password},
+		},
+	}
+	tests := []struct {
+		name     string
+		auth     *config.ListenerAuthentication
+		username string
+		password string
+		payload  string
+		want     *message.Alert
+		wantId   string
+		wantErr  string
+		wantCode int64
+	}{
+		{
+			name:     "firing alert",
+			auth:     auth,
+			username: "knot",
+			password: "secret",
+			payload:  firingPayload,
+			want: &message.Alert{
+				Status:     "firing",
+				Title:      "[FIRING:1] HighCPU",
+				Labels:     map[string]string{"alertname": "HighCPU", "team": "a"},
+				Url:        "https://grafana/alerting/grafana/abc/view",
+				IncidentId: "{}:{alertname=\"HighCPU\"}",
+			},
+			wantId: "{}:{alertname=\"HighCPU\"}/firing",
+		},
+		{
+			name:    "resolved alert without authentication",
+			payload: `{"status":"resolved","commonLabels":{"alertname":"HighCPU"},"externalURL":"https://grafana/","groupKey":"key"}`,
+			want: &message.Alert{
+				Status:     "resolved",
+				Title:      "HighCPU",
+				Labels:     map[string]string{"alertname": "HighCPU"},
+				Url:        "https://grafana/",
+				IncidentId: "key",
+			},
+			wantId: "key/resolved",
+		},
+		{
+			name:     "bad credentials",
+			auth:     auth,
+			username: "knot",
+			password: "wrong",
+			payload:  firingPayload,
+			wantErr:  "the request did not contain valid credentials",
+			wantCode: 401,
+		},
+		{
+			name:     "not a grafana payload",
+			payload:  `{"test":"123"}`,
+			wantErr:  "Failed to unmarshal body to a grafana alert payload. Error: the status property is missing",
+			wantCode: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testLogger := zaptest.NewLogger(t)
+			request, _ := http.NewRequest("POST", "/api/v1/grafana", bytes.NewReader([]byte(tt.payload)))
+			if tt.username != "" {
+				request.SetBasicAuth(tt.username, tt.password)
+			}
+
+			l := New(config.AlertListenerConfiguration{Authentication: tt.auth})
+			var got *message.NotificationData
+			errD := l.Authenticate(context.Background(), testLogger, request)
+			if errD == nil {
+				got, errD = l.ParsePayload(context.Background(), testLogger, []byte(tt.payload))
+			}
+
+			if errD != nil {
+				if errD.Detail != tt.wantErr || errD.Status != tt.wantCode {
+					t.Errorf("Listener.ParsePayload() err = %v (%v), want %v (%v)", errD.Detail, errD.Status, tt.wantErr, tt.wantCode)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				t.Fatalf("Listener.ParsePayload() err = nil, want %v", tt.wantErr)
+			}
+			if got.ID != tt.wantId {
+				t.Errorf("Listener.ParsePayload() id = %v, want %v", got.ID, tt.wantId)
+			}
+			want := tt.want.ToNotificationData("grafana", tt.wantId, got.Data["payload"].(map[string]interface{}))
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("Listener.ParsePayload() got = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package listener
 
 import (
 	"context"
+	gohttp "net/http"
 
 	"github.com/kcloutie/knot/pkg/http"
 	"github.com/kcloutie/knot/pkg/message"
@@ -14,3 +15,27 @@ type ListenerInterface interface {
 	GetApiPath() string
 	ParsePayload(ctx context.Context, log *zap.Logger, payload []byte) (*message.NotificationData, *http.ErrorDetail)
 }
+
+// RequestListenerInterface is implemented by listeners that need more than the request body (headers, content type,
+// query parameters...) to build the notification data. A single request can result in more than one message
+type RequestListenerInterface interface {
+	ListenerInterface
+	ParseRequest(ctx context.Context, log *zap.Logger, request *gohttp.Request, payload []byte) ([]*message.NotificationData, *http.ErrorDetail)
+}
+
+// NotificationRestrictor is implemented by listeners that are only allowed to trigger a subset of the configured notifications
+type NotificationRestrictor interface {
+	AllowsNotification(name string) bool
+}
+
+// WatcherInterface is implemented by listeners that are not triggered by http requests but watch a source for changes
+type WatcherInterface interface {
+	Initialize(ctx context.Context) error
+	GetName() string
+	Watch(ctx context.Context, dispatch func(ctx context.Context, data *message.NotificationData)) error
+}
+
+// Authenticator is implemented by listeners that authenticate requests before the payload is parsed
+type Authenticator interface {
+	Authenticate(ctx context.Context, log *zap.Logger, request *gohttp.Request) *http.ErrorDetail
+}
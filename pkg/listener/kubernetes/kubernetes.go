@@ -0,0 +1,214 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/logger"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	EventTypeAdded    = "ADDED"
+	EventTypeModified = "MODIFIED"
+	EventTypeDeleted  = "DELETED"
+	// EventTypeResync is used when an informer resync delivers an object that has not changed
+	EventTypeResync = "RESYNC"
+)
+
+type Watcher struct {
+	Name          string
+	Config        config.KubernetesWatcherConfiguration
+	Clientset     clientset.Interface
+	DynamicClient dynamic.Interface
+}
+
+func New(cfg config.KubernetesWatcherConfiguration) *Watcher {
+	return &Watcher{
+		Name:   "kubernetes",
+		Config: cfg,
+	}
+}
+
+func (w *Watcher) GetName() string {
+	return w.Name
+}
+
+// Initialize creates the kubernetes clients when they were not already supplied
+func (w *Watcher) Initialize(ctx context.Context) error {
+	if w.Clientset != nil && w.DynamicClient != nil {
+		return nil
+	}
+	var restConfig *rest.Config
+	var err error
+	if w.Config.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", w.Config.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the kubernetes client configuration - %v", err)
+	}
+	if w.Clientset == nil {
+		w.Clientset, err = clientset.NewForConfig(restConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create the kubernetes client - %v", err)
+		}
+	}
+	if w.DynamicClient == nil {
+		w.DynamicClient, err = dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create the kubernetes dynamic client - %v", err)
+		}
+	}
+	return nil
+}
+
+// Watch starts the informers and returns once their caches have synced, or with an error when they did not sync within
+// the sync timeout. The objects that already exist when the watch starts are not sent. Changes are sent to the
+// dispatch function until the context is cancelled
+func (w *Watcher) Watch(ctx context.Context, dispatch func(ctx context.Context, data *message.NotificationData)) (err error) {
+	log := logger.FromCtx(ctx).With(zap.String("watcher", w.Name))
+	// the informers are stopped when the watch fails so they do not keep listing the resources in the background
+	informerCtx, stopInformers := context.WithCancel(ctx)
+	defer func() {
+		if err != nil {
+			stopInformers()
+		}
+	}()
+	resync := time.Duration(w.Config.ResyncSeconds) * time.Second
+	synced := []cache.InformerSynced{}
+
+	if w.Config.Events != nil {
+		eventsCfg := w.Config.Events
+		factory := informers.NewSharedInformerFactoryWithOptions(w.Clientset, resync,
+			informers.WithNamespace(eventsCfg.Namespace),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.FieldSelector = eventsCfg.FieldSelector
+			}),
+		)
+		informer := factory.Core().V1().Events().Informer()
+		_, err = informer.AddEventHandler(w.newEventHandler(ctx, log, corev1.SchemeGroupVersion.WithKind("Event"), dispatch))
+		if err != nil {
+			return fmt.Errorf("failed to add the kubernetes events handler - %v", err)
+		}
+		synced = append(synced, informer.HasSynced)
+		factory.Start(informerCtx.Done())
+		log.Info("watching kubernetes events")
+	}
+
+	for _, resource := range w.Config.Resources {
+		resource := resource
+		gvk := schema.GroupVersionKind{Group: resource.Group, Version: resource.Version, Kind: resource.Kind}
+		gvr := GetGroupVersionResource(resource)
+		factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.DynamicClient, resync, resource.Namespace, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = resource.LabelSelector
+		})
+		informer := factory.ForResource(gvr).Informer()
+		_, err = informer.AddEventHandler(w.newEventHandler(ctx, log, gvk, dispatch))
+		if err != nil {
+			return fmt.Errorf("failed to add the kubernetes handler for resource '%s' - %v", gvr.String(), err)
+		}
+		synced = append(synced, informer.HasSynced)
+		factory.Start(informerCtx.Done())
+		log.Info(fmt.Sprintf("watching kubernetes resource '%s'", gvr.String()))
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, w.Config.GetSyncTimeout())
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		return fmt.Errorf("failed to sync the kubernetes informer caches within %v, check the watched resources exist and the service account can list and watch them", w.Config.GetSyncTimeout())
+	}
+	return nil
+}
+
+// GetGroupVersionResource returns the resource to watch, guessing the plural resource name from the kind when the
+// resource is not specified
+func GetGroupVersionResource(resource config.KubernetesResourceWatch) schema.GroupVersionResource {
+	if resource.Resource != "" {
+		return schema.GroupVersionResource{Group: resource.Group, Version: resource.Version, Resource: resource.Resource}
+	}
+	gvr, _ := meta.UnsafeGuessKindToResource(schema.GroupVersionKind{Group: resource.Group, Version: resource.Version, Kind: resource.Kind})
+	return gvr
+}
+
+func (w *Watcher) newEventHandler(ctx context.Context, log *zap.Logger, gvk schema.GroupVersionKind, dispatch func(ctx context.Context, data *message.NotificationData)) cache.ResourceEventHandler {
+	handle := func(eventType string, obj interface{}) {
+		notifyData, err := ToNotificationData(eventType, gvk, obj)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+		dispatch(ctx, notifyData)
+	}
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			// the objects listed when the watch starts were already sent before a restart
+			if isInInitialList {
+				return
+			}
+			handle(EventTypeAdded, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, oldErr := meta.Accessor(oldObj)
+			newMeta, newErr := meta.Accessor(newObj)
+			if oldErr == nil && newErr == nil && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				handle(EventTypeResync, newObj)
+				return
+			}
+			handle(EventTypeModified, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			handle(EventTypeDeleted, obj)
+		},
+	}
+}
+
+// ToNotificationData converts a kubernetes object to the notification data. The object is available in the data and
+// the event type, kind, api version, namespace and name are available in the attributes
+func ToNotificationData(eventType string, gvk schema.GroupVersionKind, obj interface{}) (*message.NotificationData, error) {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the metadata of the kubernetes object - %v", err)
+	}
+	runtimeObj, ok := obj.(runtime.Object)
+	if !ok {
+		return nil, fmt.Errorf("the kubernetes object of type %T is not a runtime object", obj)
+	}
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(runtimeObj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the kubernetes object '%s/%s' - %v", objMeta.GetNamespace(), objMeta.GetName(), err)
+	}
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	return &message.NotificationData{
+		Data: data,
+		Attributes: map[string]string{
+			"eventType":       eventType,
+			"apiVersion":      apiVersion,
+			"kind":            kind,
+			"namespace":       objMeta.GetNamespace(),
+			"name":            objMeta.GetName(),
+			"uid":             string(objMeta.GetUID()),
+			"resourceVersion": objMeta.GetResourceVersion(),
+		},
+		ID: fmt.Sprintf("%s/%s/%s", objMeta.GetUID(), objMeta.GetResourceVersion(), eventType),
+	}, nil
+}
@@ -0,0 +1,229 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var pipelineRunGvr = schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "pipelineruns"}
+
+func newPipelineRun(name string, labels map[string]string, status string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "tekton.dev/v1",
+			"kind":       "PipelineRun",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+			},
+			"status": map[string]interface{}{
+				"result": status,
+			},
+		},
+	}
+	obj.SetLabels(labels)
+	return obj
+}
+
+func newTestWatcher(cfg config.KubernetesWatcherConfiguration, objects ...runtime.Object) *Watcher {
+	scheme := runtime.NewScheme()
+	w := New(cfg)
+	w.Clientset = fake.NewSimpleClientset()
+	w.DynamicClient = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, map[schema.GroupVersionResource]string{
+		pipelineRunGvr: "PipelineRunList",
+	}, objects...)
+	return w
+}
+
+func waitForData(t *testing.T, received chan *message.NotificationData) *message.NotificationData {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the watcher to dispatch a message")
+	}
+	return nil
+}
+
+func TestWatcher_WatchResources(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	existing := newPipelineRun("existing", map[string]string{"app": "knot"}, "Running")
+	w := newTestWatcher(config.KubernetesWatcherConfiguration{
+		Resources: []config.KubernetesResourceWatch{
+			{
+				Group:         "tekton.dev",
+				Version:       "v1",
+				Kind:          "PipelineRun",
+				Namespace:     "default",
+				LabelSelector: "app=knot",
+			},
+		},
+	}, existing)
+
+	received := make(chan *message.NotificationData, 10)
+	err := w.Watch(ctx, func(ctx context.Context, data *message.NotificationData) {
+		received <- data
+	})
+	if err != nil {
+		t.Fatalf("Watcher.Watch() error = %v", err)
+	}
+
+	// the existing objects are not sent
+	client := w.DynamicClient.Resource(pipelineRunGvr).Namespace("default")
+	_, err = client.Create(ctx, newPipelineRun("created", map[string]string{"app": "knot"}, "Running"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create the pipeline run - %v", err)
+	}
+	got := waitForData(t, received)
+	if got.Attributes["eventType"] != EventTypeAdded || got.Attributes["name"] != "created" {
+		t.Errorf("Watcher.Watch() got attributes = %v, want ADDED event for 'created'", got.Attributes)
+	}
+
+	updated := existing.DeepCopy()
+	updated.Object["status"] = map[string]interface{}{"result": "Succeeded"}
+	updated.SetResourceVersion("2")
+	_, err = client.Update(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("failed to update the pipeline run - %v", err)
+	}
+
+	got = waitForData(t, received)
+	if got.Attributes["eventType"] != EventTypeModified {
+		t.Errorf("Watcher.Watch() eventType = %v, want %v", got.Attributes["eventType"], EventTypeModified)
+	}
+	if got.Attributes["kind"] != "PipelineRun" || got.Attributes["apiVersion"] != "tekton.dev/v1" || got.Attributes["namespace"] != "default" {
+		t.Errorf("Watcher.Watch() got attributes = %v", got.Attributes)
+	}
+	matches, err := got.GetPropertyValue(ctx, "data.status.result")
+	if err != nil || matches != "Succeeded" {
+		t.Errorf("Watcher.Watch() data.status.result = %v (err %v), want Succeeded", matches, err)
+	}
+
+	err = client.Delete(ctx, "existing", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("failed to delete the pipeline run - %v", err)
+	}
+	got = waitForData(t, received)
+	if got.Attributes["eventType"] != EventTypeDeleted {
+		t.Errorf("Watcher.Watch() eventType = %v, want %v", got.Attributes["eventType"], EventTypeDeleted)
+	}
+}
+
+func TestWatcher_WatchEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := newTestWatcher(config.KubernetesWatcherConfiguration{
+		Events: &config.KubernetesEventsWatch{
+			Namespace: "default",
+		},
+	})
+
+	received := make(chan *message.NotificationData, 10)
+	err := w.Watch(ctx, func(ctx context.Context, data *message.NotificationData) {
+		received <- data
+	})
+	if err != nil {
+		t.Fatalf("Watcher.Watch() error = %v", err)
+	}
+
+	_, err = w.Clientset.CoreV1().Events("default").Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pipeline-failed",
+			Namespace: "default",
+			UID:       "1234",
+		},
+		Reason:  "Failed",
+		Message: "pipeline run failed",
+		InvolvedObject: corev1.ObjectReference{
+			Kind: "PipelineRun",
+			Name: "my-run",
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create the event - %v", err)
+	}
+
+	got := waitForData(t, received)
+	if got.Attributes["kind"] != "Event" || got.Attributes["apiVersion"] != "v1" || got.Attributes["eventType"] != EventTypeAdded {
+		t.Errorf("Watcher.Watch() got attributes = %v", got.Attributes)
+	}
+	reason, err := got.GetPropertyValue(ctx, "data.reason")
+	if err != nil || reason != "Failed" {
+		t.Errorf("Watcher.Watch() data.reason = %v (err %v), want Failed", reason, err)
+	}
+}
+
+func TestGetGroupVersionResource(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource config.KubernetesResourceWatch
+		want     schema.GroupVersionResource
+	}{
+		{
+			name:     "guessed from kind",
+			resource: config.KubernetesResourceWatch{Group: "tekton.dev", Version: "v1", Kind: "PipelineRun"},
+			want:     pipelineRunGvr,
+		},
+		{
+			name:     "explicit resource",
+			resource: config.KubernetesResourceWatch{Group: "tekton.dev", Version: "v1", Kind: "PipelineRun", Resource: "prs"},
+			want:     schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "prs"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetGroupVersionResource(tt.resource); got != tt.want {
+				t.Errorf("GetGroupVersionResource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcher_WatchSyncTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := newTestWatcher(config.KubernetesWatcherConfiguration{
+		SyncTimeoutSeconds: 1,
+		Resources: []config.KubernetesResourceWatch{
+			{Group: "tekton.dev", Version: "v1", Kind: "PipelineRun", Namespace: "default"},
+		},
+	})
+	// the service account is not allowed to list the resource
+	var lists int32
+	w.DynamicClient.(*dynamicfake.FakeDynamicClient).PrependReactor("list", "pipelineruns", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&lists, 1)
+		return true, nil, apierrors.NewForbidden(pipelineRunGvr.GroupResource(), "", fmt.Errorf("forbidden"))
+	})
+	err := w.Watch(ctx, func(ctx context.Context, data *message.NotificationData) {})
+	if err == nil {
+		t.Fatalf("Watcher.Watch() expected a sync timeout error")
+	}
+
+	// the informers stop listing once the watch failed even though the context is not done
+	time.Sleep(100 * time.Millisecond)
+	stopped := atomic.LoadInt32(&lists)
+	time.Sleep(3 * time.Second)
+	if got := atomic.LoadInt32(&lists); got != stopped {
+		t.Errorf("Watcher.Watch() the informers listed the resource %v times after the sync timeout, want 0", got-stopped)
+	}
+}
@@ -1,9 +1,39 @@
 package listener
 
-import "github.com/kcloutie/knot/pkg/listener/pubsub"
+import (
+	"context"
 
-func GetListeners() []ListenerInterface {
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/listener/cloudevents"
+	"github.com/kcloutie/knot/pkg/listener/cloudmonitoring"
+	"github.com/kcloutie/knot/pkg/listener/grafana"
+	"github.com/kcloutie/knot/pkg/listener/kubernetes"
+	"github.com/kcloutie/knot/pkg/listener/pubsub"
+	"github.com/kcloutie/knot/pkg/listener/webhook"
+)
+
+func GetListeners(ctx context.Context) []ListenerInterface {
+	cfg := config.FromCtx(ctx)
 	listeners := []ListenerInterface{}
 	listeners = append(listeners, pubsub.New())
+	listeners = append(listeners, cloudevents.New())
+	if cfg.Grafana != nil {
+		listeners = append(listeners, grafana.New(*cfg.Grafana))
+	}
+	if cfg.CloudMonitoring != nil {
+		listeners = append(listeners, cloudmonitoring.New(*cfg.CloudMonitoring))
+	}
+	for _, endpoint := range cfg.Webhooks {
+		listeners = append(listeners, webhook.New(endpoint))
+	}
 	return listeners
 }
+
+func GetWatchers(ctx context.Context) []WatcherInterface {
+	cfg := config.FromCtx(ctx)
+	watchers := []WatcherInterface{}
+	if cfg.Kubernetes != nil {
+		watchers = append(watchers, kubernetes.New(*cfg.Kubernetes))
+	}
+	return watchers
+}
@@ -1,23 +1,47 @@
 package listener
 
 import (
+	"context"
 	"sort"
 	"testing"
+
+	"github.com/kcloutie/knot/pkg/config"
 )
 
 func TestGetListeners(t *testing.T) {
 	tests := []struct {
 		name string
+		cfg  *config.ServerConfiguration
 		want []string
 	}{
 		{
 			name: "basic",
-			want: []string{"pubsub"},
+			cfg:  config.NewServerConfiguration(),
+			want: []string{"cloudevents", "pubsub"},
+		},
+		{
+			name: "with webhooks",
+			cfg: &config.ServerConfiguration{
+				Webhooks: []config.WebhookEndpoint{
+					{Name: "github"},
+					{Name: "argo"},
+				},
+			},
+			want: []string{"cloudevents", "hooks/argo", "hooks/github", "pubsub"},
+		},
+		{
+			name: "with alert listeners",
+			cfg: &config.ServerConfiguration{
+				Grafana:         &config.AlertListenerConfiguration{},
+				CloudMonitoring: &config.AlertListenerConfiguration{},
+			},
+			want: []string{"cloudevents", "cloudmonitoring", "grafana", "pubsub"},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := GetListeners()
+			ctx := config.WithCtx(context.Background(), tt.cfg)
+			got := GetListeners(ctx)
 
 			sort.Slice(got, func(i, j int) bool {
 				return got[i].GetApiPath() < got[j].GetApiPath()
@@ -25,6 +49,9 @@ func TestGetListeners(t *testing.T) {
 
 			sort.Strings(tt.want)
 
+			if len(got) != len(tt.want) {
+				t.Fatalf("GetListeners() returned %v listeners, want %v", len(got), len(tt.want))
+			}
 			for i := 0; i < len(tt.want); i++ {
 				if tt.want[i] != got[i].GetApiPath() {
 					t.Errorf("GetListeners() = %v, want %v", got[i].GetApiPath(), tt.want[i])
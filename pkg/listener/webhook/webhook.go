@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gohttp "net/http"
+	"regexp"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/http"
+	"github.com/kcloutie/knot/pkg/message"
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	ApiPathPrefix = "hooks"
+)
+
+var validNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-\.]+$`)
+
+type Listener struct {
+	Name     string
+	ApiPath  string
+	Endpoint config.WebhookEndpoint
+}
+
+func New(endpoint config.WebhookEndpoint) *Listener {
+	return &Listener{
+		Name:     fmt.Sprintf("webhook/%s", endpoint.Name),
+		ApiPath:  fmt.Sprintf("%s/%s", ApiPathPrefix, endpoint.Name),
+		Endpoint: endpoint,
+	}
+}
+
+func (v *Listener) Initialize(ctx context.Context) error {
+	if !validNameRegex.MatchString(v.Endpoint.Name) {
+		return fmt.Errorf("invalid webhook name '%s'. The name can only contain letters, numbers, '-', '_' and '.'", v.Endpoint.Name)
+	}
+	return nil
+}
+
+func (v *Listener) GetName() string {
+	return v.Name
+}
+
+func (v *Listener) GetApiPath() string {
+	return v.ApiPath
+}
+
+// AllowsNotification returns true when the webhook endpoint is allowed to trigger the notification
+func (v *Listener) AllowsNotification(name string) bool {
+	if len(v.Endpoint.Notifications) == 0 {
+		return true
+	}
+	for _, allowed := range v.Endpoint.Notifications {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Listener) ParsePayload(ctx context.Context, log *zap.Logger, payload []byte) (*message.NotificationData, *http.ErrorDetail) {
+	return v.toNotificationData(ctx, log, map[string]string{}, payload)
+}
+
+func (v *Listener) ParseRequest(ctx context.Context, log *zap.Logger, request *gohttp.Request, payload []byte) ([]*message.NotificationData, *http.ErrorDetail) {
+	attributes := map[string]string{}
+	for _, header := range v.Endpoint.Headers {
+		val := request.Header.Get(header)
+		if val != "" {
+			attributes[header] = val
+		}
+	}
+	query := request.URL.Query()
+	for _, param := range v.Endpoint.QueryParams {
+		if query.Has(param) {
+			attributes[param] = query.Get(param)
+		}
+	}
+
+	notifyData, errD := v.toNotificationData(ctx, log, attributes, payload)
+	if errD != nil {
+		return nil, errD
+	}
+	return []*message.NotificationData{notifyData}, nil
+}
+
+func (v *Listener) toNotificationData(ctx context.Context, log *zap.Logger, attributes map[string]string, payload []byte) (*message.NotificationData, *http.ErrorDetail) {
+	var decoded interface{}
+	err := json.Unmarshal(payload, &decoded)
+	if err != nil {
+		return nil, v.newErrorDetail(log, "unmarshal-body-data", "Unmarshal Body Data", fmt.Sprintf("Failed to unmarshal the webhook body. Error: %v", err))
+	}
+
+	notifyData := &message.NotificationData{
+		Attributes: attributes,
+		Data:       map[string]interface{}{},
+	}
+	if mapVal, ok := decoded.(map[string]interface{}); ok {
+		notifyData.Data = mapVal
+	} else {
+		notifyData.Data[message.DataValueKey] = decoded
+	}
+
+	if v.Endpoint.IdExpression == "" {
+		notifyData.ID = uuid.NewV4().String()
+		return notifyData, nil
+	}
+
+	id, err := notifyData.GetPropertyValue(ctx, v.Endpoint.IdExpression)
+	if err != nil {
+		return nil, v.newErrorDetail(log, "get-message-id", "Get Message ID", fmt.Sprintf("failed to get the message id using the expression '%s' - %v", v.Endpoint.IdExpression, err))
+	}
+	notifyData.ID = id
+	return notifyData, nil
+}
+
+func (v *Listener) newErrorDetail(log *zap.Logger, errType string, title string, detail string) *http.ErrorDetail {
+	log.Error(detail)
+	return &http.ErrorDetail{
+		Type:     errType,
+		Title:    title,
+		Status:   400,
+		Detail:   detail,
+		Instance: v.GetApiPath(),
+	}
+}
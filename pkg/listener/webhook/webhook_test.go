@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestListener_ParseRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint config.WebhookEndpoint
+		url      string
+		headers  map[string]string
+		payload  string
+		want     []*message.NotificationData
+		wantErr  string
+	}{
+		{
+			name: "headers and query params",
+			endpoint: config.WebhookEndpoint{
+				Name:         "github",
+				Headers:      []string{"X-GitHub-Event", "X-Missing"},
+				QueryParams:  []string{"env"},
+				IdExpression: "attributes['X-GitHub-Event'] + '-' + data.id",
+			},
+			url: "/api/v1/hooks/github?env=prod&other=ignored",
+			headers: map[string]string{
+				"X-GitHub-Event": "push",
+				"X-Other":        "ignored",
+			},
+			payload: `{"id":"123"}`,
+			want: []*message.NotificationData{
+				{
+					ID: "push-123",
+					Attributes: map[string]string{
+						"X-GitHub-Event": "push",
+						"env":            "prod",
+					},
+					Data: map[string]interface{}{
+						"id": "123",
+					},
+				},
+			},
+		},
+		{
+			name: "non object body",
+			endpoint: config.WebhookEndpoint{
+				Name:         "list",
+				IdExpression: "string(size(data.value))",
+			},
+			url:     "/api/v1/hooks/list",
+			payload: `["a","b"]`,
+			want: []*message.NotificationData{
+				{
+					ID:         "2",
+					Attributes: map[string]string{},
+					Data: map[string]interface{}{
+						"value": []interface{}{"a", "b"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid json",
+			endpoint: config.WebhookEndpoint{
+				Name: "github",
+			},
+			url:     "/api/v1/hooks/github",
+			payload: `dude`,
+			wantErr: "Failed to unmarshal the webhook body. Error: invalid character 'd' looking for beginning of value",
+		},
+		{
+			name: "bad id expression",
+			endpoint: config.WebhookEndpoint{
+				Name:         "github",
+				IdExpression: "data.missing",
+			},
+			url:     "/api/v1/hooks/github",
+			payload: `{"id":"123"}`,
+			wantErr: "failed to get the message id using the expression 'data.missing' - expression \"data.missing\" failed to evaluate: no such key: missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testLogger := zaptest.NewLogger(t)
+			request, _ := http.NewRequest("POST", tt.url, bytes.NewReader([]byte(tt.payload)))
+			for key, val := range tt.headers {
+				request.Header.Set(key, val)
+			}
+
+			l := New(tt.endpoint)
+			got, errD := l.ParseRequest(context.Background(), testLogger, request, []byte(tt.payload))
+
+			if errD == nil {
+				if tt.wantErr != "" {
+					t.Errorf("Listener.ParseRequest() err = nil, want %v", tt.wantErr)
+				}
+			} else if errD.Detail != tt.wantErr {
+				t.Errorf("Listener.ParseRequest() err = %v, want %v", errD.Detail, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Listener.ParseRequest() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_GeneratedId(t *testing.T) {
+	testLogger := zaptest.NewLogger(t)
+	l := New(config.WebhookEndpoint{Name: "test"})
+	got, errD := l.ParsePayload(context.Background(), testLogger, []byte(`{}`))
+	if errD != nil {
+		t.Fatalf("Listener.ParsePayload() err = %v", errD.Detail)
+	}
+	if got.ID == "" {
+		t.Errorf("Listener.ParsePayload() id was empty, expected a generated id")
+	}
+}
+
+func TestListener_Initialize(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			hook: "my-hook_1.0",
+		},
+		{
+			name:    "empty",
+			hook:    "",
+			wantErr: true,
+		},
+		{
+			name:    "with slash",
+			hook:    "my/hook",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New(config.WebhookEndpoint{Name: tt.hook}).Initialize(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Listener.Initialize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListener_AllowsNotification(t *testing.T) {
+	tests := []struct {
+		name          string
+		notifications []string
+		notification  string
+		want          bool
+	}{
+		{
+			name:         "no restrictions",
+			notification: "any",
+			want:         true,
+		},
+		{
+			name:          "allowed",
+			notifications: []string{"one", "two"},
+			notification:  "two",
+			want:          true,
+		},
+		{
+			name:          "not allowed",
+			notifications: []string{"one", "two"},
+			notification:  "three",
+			want:          false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(config.WebhookEndpoint{Name: "test", Notifications: tt.notifications})
+			if got := l.AllowsNotification(tt.notification); got != tt.want {
+				t.Errorf("Listener.AllowsNotification() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,32 +2,48 @@ package matcher
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/google/cel-go/common/types"
-	"github.com/kcloutie/knot/pkg/cel"
+	"github.com/kcloutie/knot/pkg/clock"
 	"github.com/kcloutie/knot/pkg/config"
 	"github.com/kcloutie/knot/pkg/logger"
 	"github.com/kcloutie/knot/pkg/message"
+	"github.com/kcloutie/knot/pkg/silence"
 	"go.uber.org/zap"
 )
 
 func Matches(ctx context.Context, notification config.Notification, data *message.NotificationData) (bool, error) {
 	log := logger.FromCtx(ctx).With(zap.String("notificationName", notification.Name))
-	if notification.Disabled {
+	if notification.IsDisabled() {
 		log.Debug("message did not match notification because notification was disabled")
 		return false, nil
 
 	}
+	if notification.Schedule != "" {
+		schedule, exists := config.FromCtx(ctx).Schedules[notification.Schedule]
+		if !exists {
+			return false, fmt.Errorf("schedule '%s' does not exist", notification.Schedule)
+		}
+		active, err := schedule.IsActive(clock.Now(ctx))
+		if err != nil {
+			return false, fmt.Errorf("failed to evaluate schedule '%s' - %v", notification.Schedule, err)
+		}
+		if !active {
+			log.Debug(fmt.Sprintf("message did not match notification because schedule '%s' is not active", notification.Schedule))
+			return false, nil
+		}
+	}
 	if notification.CelExpressionFilter == "" {
 		log.Debug("message matched notification! Notification does not contain any CEL filtering so it matches everything")
 		return true, nil
 	}
 
-	matches, err := cel.CelEvaluate(ctx, notification.CelExpressionFilter, message.GetCelDecl(), data.AsMap())
+	matches, err := MatchesExpression(ctx, notification.CelExpressionFilter, data)
 	if err != nil {
 		return false, err
 	}
-	if matches == types.True {
+	if matches {
 		log.Debug("message matched notification CEL filtering")
 		return true, nil
 	}
@@ -35,3 +51,25 @@ func Matches(ctx context.Context, notification config.Notification, data *messag
 	return false, nil
 
 }
+
+// MatchesExpression evaluates the CEL expression against the message. An empty expression matches every message
+func MatchesExpression(ctx context.Context, expression string, data *message.NotificationData) (bool, error) {
+	if expression == "" {
+		return true, nil
+	}
+	matches, err := message.CelEnvironment().Evaluate(ctx, expression, data.AsMap())
+	if err != nil {
+		return false, err
+	}
+	return matches == types.True, nil
+}
+
+// Silenced returns the active silence muting the notification for the message, it is evaluated after the notification
+// matched. Nil is returned when silences are not enabled or no silence matches
+func Silenced(ctx context.Context, notification config.Notification, data *message.NotificationData) (*silence.Silence, error) {
+	store := silence.FromCtx(ctx)
+	if store == nil {
+		return nil, nil
+	}
+	return silence.Silenced(ctx, store, notification.Name, data)
+}
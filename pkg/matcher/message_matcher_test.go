@@ -3,7 +3,9 @@ package matcher
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/kcloutie/knot/pkg/clock"
 	"github.com/kcloutie/knot/pkg/config"
 	"github.com/kcloutie/knot/pkg/message"
 )
@@ -18,6 +20,14 @@ func TestMatches(t *testing.T) {
 	attributes := map[string]string{
 		"att1": "val1",
 	}
+	scheduleCtx := config.WithCtx(context.Background(), &config.ServerConfiguration{
+		Schedules: map[string]config.Schedule{
+			"business-hours": {Ranges: []config.TimeRange{{Weekdays: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}}},
+			"weekends":       {Ranges: []config.TimeRange{{Weekdays: []string{"sat", "sun"}}}},
+		},
+	})
+	// monday
+	scheduleCtx = clock.WithCtx(scheduleCtx, func() time.Time { return time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC) })
 	type args struct {
 		ctx          context.Context
 		notification config.Notification
@@ -36,7 +46,7 @@ func TestMatches(t *testing.T) {
 				notification: config.Notification{
 					Name:                "no filter",
 					CelExpressionFilter: "",
-					Disabled:            false,
+					Disabled:            config.AsBoolPointer(false),
 				},
 				data: &message.NotificationData{
 					ID:         "1",
@@ -54,7 +64,7 @@ func TestMatches(t *testing.T) {
 				notification: config.Notification{
 					Name:                "disabled",
 					CelExpressionFilter: "",
-					Disabled:            true,
+					Disabled:            config.AsBoolPointer(true),
 				},
 				data: &message.NotificationData{
 					ID:         "1",
@@ -72,7 +82,7 @@ func TestMatches(t *testing.T) {
 				notification: config.Notification{
 					Name:                "with filter",
 					CelExpressionFilter: "data.prop1 == 'val1'",
-					Disabled:            false,
+					Disabled:            config.AsBoolPointer(false),
 				},
 				data: &message.NotificationData{
 					ID:         "1",
@@ -90,7 +100,7 @@ func TestMatches(t *testing.T) {
 				notification: config.Notification{
 					Name:                "with filter",
 					CelExpressionFilter: "data.prop1 == 'val2'",
-					Disabled:            false,
+					Disabled:            config.AsBoolPointer(false),
 				},
 				data: &message.NotificationData{
 					ID:         "1",
@@ -101,6 +111,54 @@ func TestMatches(t *testing.T) {
 			want:    false,
 			wantErr: false,
 		},
+		{
+			name: "active schedule",
+			args: args{
+				ctx: scheduleCtx,
+				notification: config.Notification{
+					Name:     "with schedule",
+					Schedule: "business-hours",
+				},
+				data: &message.NotificationData{
+					ID:         "1",
+					Data:       data,
+					Attributes: attributes,
+				},
+			},
+			want: true,
+		},
+		{
+			name: "inactive schedule",
+			args: args{
+				ctx: scheduleCtx,
+				notification: config.Notification{
+					Name:     "with schedule",
+					Schedule: "weekends",
+				},
+				data: &message.NotificationData{
+					ID:         "1",
+					Data:       data,
+					Attributes: attributes,
+				},
+			},
+			want: false,
+		},
+		{
+			name: "missing schedule",
+			args: args{
+				ctx: scheduleCtx,
+				notification: config.Notification{
+					Name:     "with schedule",
+					Schedule: "missing",
+				},
+				data: &message.NotificationData{
+					ID:         "1",
+					Data:       data,
+					Attributes: attributes,
+				},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -115,3 +173,15 @@ func TestMatches(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkMatches(b *testing.B) {
+	ctx := context.Background()
+	not := config.Notification{Name: "n1", CelExpressionFilter: `data.prop1 == "val1" && data.prop2.prop3 > 1`}
+	data := &message.NotificationData{Data: map[string]interface{}{"prop1": "val1", "prop2": map[string]interface{}{"prop3": 2}}}
+	for i := 0; i < b.N; i++ {
+		_, err := Matches(ctx, not, data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
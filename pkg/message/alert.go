@@ -0,0 +1,39 @@
+package message
+
+const (
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// Alert is the common shape that the alerting listeners normalize the vendor specific payloads to
+type Alert struct {
+	Status     string
+	Title      string
+	Labels     map[string]string
+	Url        string
+	IncidentId string
+}
+
+// ToNotificationData returns the notification data for the alert. The normalized alert properties are available at
+// the root of the data and the original payload is available in the payload property
+func (a Alert) ToNotificationData(source string, id string, payload map[string]interface{}) NotificationData {
+	labels := map[string]interface{}{}
+	for key, val := range a.Labels {
+		labels[key] = val
+	}
+	return NotificationData{
+		Data: map[string]interface{}{
+			"status":     a.Status,
+			"title":      a.Title,
+			"labels":     labels,
+			"url":        a.Url,
+			"incidentId": a.IncidentId,
+			"payload":    payload,
+		},
+		Attributes: map[string]string{
+			"source": source,
+			"status": a.Status,
+		},
+		ID: id,
+	}
+}
@@ -0,0 +1,35 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAlert_ToNotificationData(t *testing.T) {
+	payload := map[string]interface{}{"test": "123"}
+	alert := Alert{
+		Status:     AlertStatusFiring,
+		Title:      "High CPU",
+		Labels:     map[string]string{"team": "a"},
+		Url:        "https://grafana/alert",
+		IncidentId: "inc-1",
+	}
+	want := NotificationData{
+		Data: map[string]interface{}{
+			"status":     "firing",
+			"title":      "High CPU",
+			"labels":     map[string]interface{}{"team": "a"},
+			"url":        "https://grafana/alert",
+			"incidentId": "inc-1",
+			"payload":    payload,
+		},
+		Attributes: map[string]string{
+			"source": "grafana",
+			"status": "firing",
+		},
+		ID: "1",
+	}
+	if got := alert.ToNotificationData("grafana", "1", payload); !reflect.DeepEqual(got, want) {
+		t.Errorf("Alert.ToNotificationData() = %v, want %v", got, want)
+	}
+}
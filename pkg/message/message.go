@@ -1,23 +1,59 @@
 package message
 
 import (
+	"context"
+	"fmt"
+	"sync"
+
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	lcel "github.com/kcloutie/knot/pkg/cel"
 )
 
+// DataValueKey is the key used in the notification data when the received data is not a JSON object
+const DataValueKey = "value"
+
 type NotificationData struct {
 	Data       map[string]interface{}
 	Attributes map[string]string
 	ID         string
+	// Previous is the state of the earlier notifications with the same fingerprint when the notification is correlated
+	Previous map[string]interface{} `json:",omitempty"`
+	// Escalation is the escalation of the notification (id, step, ackUrl) when the notification is escalated
+	Escalation map[string]interface{} `json:",omitempty"`
+	// Failure is the failure of the notification (notification, error, attempts) when the message is sent to a fallback
+	Failure map[string]interface{} `json:",omitempty"`
+	// Vars are the values of the variables of the notification
+	Vars map[string]interface{} `json:",omitempty"`
+	// Enrichment are the rows of the lookup tables joined to the message, by enrichment name
+	Enrichment map[string]interface{} `json:",omitempty"`
+	// Meta describes how and when the message was received
+	Meta *Meta `json:",omitempty"`
 }
 
 func (n NotificationData) AsMap() map[string]interface{} {
-	return map[string]interface{}{
+	values := map[string]interface{}{
 		"data":       n.Data,
 		"attributes": n.Attributes,
 		"id":         n.ID,
+		"meta":       n.GetMeta().AsMap(),
+	}
+	if n.Previous != nil {
+		values["previous"] = n.Previous
+	}
+	if n.Escalation != nil {
+		values["escalation"] = n.Escalation
+	}
+	if n.Failure != nil {
+		values["failure"] = n.Failure
+	}
+	if n.Vars != nil {
+		values["vars"] = n.Vars
 	}
+	if n.Enrichment != nil {
+		values["enrichment"] = n.Enrichment
+	}
+	return values
 }
 
 func GetCelDecl() cel.EnvOption {
@@ -25,11 +61,36 @@ func GetCelDecl() cel.EnvOption {
 		decls.NewVar("data", decls.NewMapType(decls.String, decls.Dyn)),
 		decls.NewVar("attributes", decls.NewMapType(decls.String, decls.String)),
 		decls.NewVar("id", decls.String),
+		decls.NewVar("previous", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("escalation", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("failure", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("vars", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("enrichment", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("meta", decls.NewMapType(decls.String, decls.Dyn)),
 	)
 }
 
-func (o *NotificationData) GetPropertyValue(property string) (string, error) {
-	value, err := lcel.CelValue(property, GetCelDecl(), o.AsMap())
+var (
+	celEnvironment     *lcel.Environment
+	celEnvironmentOnce sync.Once
+)
+
+// CelEnvironment returns the environment compiling the expressions evaluated against the messages. Its programs are
+// cached and shared by every caller
+func CelEnvironment() *lcel.Environment {
+	celEnvironmentOnce.Do(func() {
+		env, err := lcel.NewEnvironment(GetCelDecl())
+		if err != nil {
+			panic(fmt.Sprintf("invalid message CEL declarations - %v", err))
+		}
+		celEnvironment = env
+	})
+	return celEnvironment
+}
+
+// GetPropertyValue evaluates the expression against the message, using the clock of the context for the time functions
+func (o *NotificationData) GetPropertyValue(ctx context.Context, property string) (string, error) {
+	value, err := CelEnvironment().Evaluate(ctx, property, o.AsMap())
 	if err != nil {
 		return "", err
 	}
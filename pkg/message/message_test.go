@@ -1,8 +1,12 @@
 package message
 
 import (
+	"context"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/clock"
 )
 
 func TestNotificationData_AsMap(t *testing.T) {
@@ -70,6 +74,7 @@ func TestNotificationData_AsCelData(t *testing.T) {
 				},
 			},
 			want: map[string]interface{}{
+				"meta": Meta{}.AsMap(),
 				"data": map[string]interface{}{
 					"test": "123",
 				},
@@ -79,6 +84,79 @@ func TestNotificationData_AsCelData(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "with previous",
+			n: NotificationData{
+				ID:       "1",
+				Previous: map[string]interface{}{"status": "firing"},
+			},
+			want: map[string]interface{}{
+				"meta":       Meta{}.AsMap(),
+				"data":       map[string]interface{}(nil),
+				"id":         "1",
+				"attributes": map[string]string(nil),
+				"previous":   map[string]interface{}{"status": "firing"},
+			},
+		},
+		{
+			name: "with vars",
+			n: NotificationData{
+				ID:   "1",
+				Vars: map[string]interface{}{"count": int64(2)},
+			},
+			want: map[string]interface{}{
+				"meta":       Meta{}.AsMap(),
+				"data":       map[string]interface{}(nil),
+				"id":         "1",
+				"attributes": map[string]string(nil),
+				"vars":       map[string]interface{}{"count": int64(2)},
+			},
+		},
+		{
+			name: "with enrichment",
+			n: NotificationData{
+				ID:         "1",
+				Enrichment: map[string]interface{}{"owners": map[string]interface{}{"team": "platform"}},
+			},
+			want: map[string]interface{}{
+				"meta":       Meta{}.AsMap(),
+				"data":       map[string]interface{}(nil),
+				"id":         "1",
+				"attributes": map[string]string(nil),
+				"enrichment": map[string]interface{}{"owners": map[string]interface{}{"team": "platform"}},
+			},
+		},
+		{
+			name: "with meta",
+			n: NotificationData{
+				ID: "1",
+				Meta: &Meta{
+					Listener:     "webhook",
+					Path:         "hooks/deploy",
+					ReceivedTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+					RequestId:    "req1",
+					Headers:      map[string]string{"X-Source": "ci"},
+					SourceIp:     "10.0.0.1",
+					Notification: "n1",
+				},
+			},
+			want: map[string]interface{}{
+				"data":       map[string]interface{}(nil),
+				"id":         "1",
+				"attributes": map[string]string(nil),
+				"meta": map[string]interface{}{
+					"listener":     "webhook",
+					"path":         "hooks/deploy",
+					"receivedTime": "2024-01-02T03:04:05Z",
+					"publishTime":  "",
+					"requestId":    "req1",
+					"traceId":      "",
+					"headers":      map[string]string{"X-Source": "ci"},
+					"sourceIp":     "10.0.0.1",
+					"notification": "n1",
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -88,3 +166,18 @@ func TestNotificationData_AsCelData(t *testing.T) {
 		})
 	}
 }
+
+func TestNotificationData_GetPropertyValue(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctx := clock.WithCtx(context.Background(), func() time.Time { return now })
+	n := NotificationData{
+		Data: map[string]interface{}{"name": "test"},
+	}
+	got, err := n.GetPropertyValue(ctx, `data.name + "-" + string(now().getFullYear())`)
+	if err != nil {
+		t.Fatalf("NotificationData.GetPropertyValue() error = %v", err)
+	}
+	if got != "test-2024" {
+		t.Errorf("NotificationData.GetPropertyValue() = %v, want %v", got, "test-2024")
+	}
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"time"
+)
+
+// Meta describes how and when the message was received. The listeners populate it and the dispatcher sets the name of
+// the notification evaluating or sending the message. It is available as meta to the CEL expressions and templates
+type Meta struct {
+	// Listener is the name of the listener or watcher that received the message
+	Listener string `json:"listener,omitempty"`
+	// Path is the request path or the name of the watcher
+	Path string `json:"path,omitempty"`
+	// ReceivedTime is when knot received the message
+	ReceivedTime time.Time `json:"receivedTime,omitempty"`
+	// PublishTime is when the source published the message, zero when the source does not provide it
+	PublishTime time.Time `json:"publishTime,omitempty"`
+	RequestId   string    `json:"requestId,omitempty"`
+	TraceId     string    `json:"traceId,omitempty"`
+	// Headers are the request headers selected in the meta configuration, empty when the request does not have them
+	Headers  map[string]string `json:"headers,omitempty"`
+	SourceIp string            `json:"sourceIp,omitempty"`
+	// Notification is the name of the notification evaluating or sending the message
+	Notification string `json:"notification,omitempty"`
+}
+
+// AsMap returns the values of the meta. Every key is present so the templates can use them, the times are RFC3339
+// strings which are empty when the time is not known
+func (m Meta) AsMap() map[string]interface{} {
+	headers := map[string]string{}
+	for name, value := range m.Headers {
+		headers[name] = value
+	}
+	return map[string]interface{}{
+		"listener":     m.Listener,
+		"path":         m.Path,
+		"receivedTime": formatTime(m.ReceivedTime),
+		"publishTime":  formatTime(m.PublishTime),
+		"requestId":    m.RequestId,
+		"traceId":      m.TraceId,
+		"headers":      headers,
+		"sourceIp":     m.SourceIp,
+		"notification": m.Notification,
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// GetMeta returns the meta of the message, an empty meta when the message does not have one
+func (n NotificationData) GetMeta() Meta {
+	if n.Meta == nil {
+		return Meta{}
+	}
+	return *n.Meta
+}
+
+// WithNotification returns a copy of the message with the notification name set in the meta
+func (n *NotificationData) WithNotification(name string) *NotificationData {
+	data := *n
+	meta := n.GetMeta()
+	meta.Notification = name
+	data.Meta = &meta
+	return &data
+}
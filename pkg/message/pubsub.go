@@ -12,6 +12,9 @@ func ToNotificationData(message *pubsub.Message) (NotificationData, error) {
 		Attributes: message.Attributes,
 		ID:         message.ID,
 	}
+	if !message.PublishTime.IsZero() {
+		results.Meta = &Meta{PublishTime: message.PublishTime}
+	}
 	data := map[string]interface{}{}
 	err := json.Unmarshal(message.Data, &data)
 	if err != nil {
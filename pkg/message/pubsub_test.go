@@ -3,6 +3,7 @@ package message
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 )
@@ -34,6 +35,20 @@ func TestToNotificationData(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Publish time",
+			message: pubsub.Message{
+				Data:        []byte(`{}`),
+				ID:          "1",
+				PublishTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+			want: NotificationData{
+				Data: map[string]interface{}{},
+				ID:   "1",
+				Meta: &Meta{PublishTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			},
+			wantErr: false,
+		},
 		{
 			name: "Some fields empty",
 			message: pubsub.Message{
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"context"
+	"errors"
+)
+
+// Error is returned by providers to indicate whether sending the notification again could succeed
+type Error struct {
+	Err       error
+	Retryable bool
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewRetryableError marks the error as temporary (5xx, 429, timeouts...), the notification will be retried
+func NewRetryableError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, Retryable: true}
+}
+
+// NewPermanentError marks the error as permanent (4xx, template errors, missing properties...), the notification
+// will not be retried
+func NewPermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, Retryable: false}
+}
+
+// NewHttpStatusError classifies the error using the http status code of the response. Rate limits (429), server
+// errors (5xx) and requests without a response (0) are retryable, all other status codes are permanent
+func NewHttpStatusError(statusCode int, err error) error {
+	if statusCode == 0 || statusCode == 429 || statusCode >= 500 {
+		return NewRetryableError(err)
+	}
+	return NewPermanentError(err)
+}
+
+// IsRetryable returns true when the notification should be sent again. Errors that were not classified by the
+// provider, such as timeouts, are considered retryable
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.Retryable
+	}
+	return !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,35 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "unclassified", err: fmt.Errorf("dude"), want: true},
+		{name: "retryable", err: NewRetryableError(fmt.Errorf("dude")), want: true},
+		{name: "permanent", err: NewPermanentError(fmt.Errorf("dude")), want: false},
+		{name: "wrapped permanent", err: fmt.Errorf("wrapped - %w", NewPermanentError(fmt.Errorf("dude"))), want: false},
+		{name: "cancelled", err: context.Canceled, want: false},
+		{name: "timeout", err: context.DeadlineExceeded, want: true},
+		{name: "rate limited", err: NewHttpStatusError(429, fmt.Errorf("dude")), want: true},
+		{name: "server error", err: NewHttpStatusError(503, fmt.Errorf("dude")), want: true},
+		{name: "no response", err: NewHttpStatusError(0, fmt.Errorf("dude")), want: true},
+		{name: "not found", err: NewHttpStatusError(404, fmt.Errorf("dude")), want: false},
+		{name: "unauthorized", err: NewHttpStatusError(401, fmt.Errorf("dude")), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package githubcomment
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 
 	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/correlation"
 	"github.com/kcloutie/knot/pkg/github"
 	"github.com/kcloutie/knot/pkg/message"
 	"github.com/kcloutie/knot/pkg/provider"
@@ -15,6 +17,8 @@ import (
 
 var _ provider.ProviderInterface = (*Provider)(nil)
 
+const commitCommentStep = "commitComment"
+
 type Provider struct {
 	Log          *zap.Logger
 	providerName string
@@ -71,12 +75,13 @@ func (v *Provider) SendNotification(ctx context.Context, data *message.Notificat
 	v.Log = v.Log.With(zap.String("provider", v.providerName))
 	_, err := provider.HasRequiredProperties(v.notification.Properties, v.GetRequiredPropertyNames())
 	if err != nil {
-		return err
+		return provider.NewPermanentError(err)
 	}
 
 	templateConfig := template.NewRenderTemplateOptions()
 	provider.SetGoTemplateOptionValues(ctx, v.Log, &templateConfig, v.notification.Properties)
 
+	// the errors of the missing and invalid properties are already permanent, the errors fetching the values are retried
 	ghConfig, err := v.GetServiceConfig(ctx, data, v.Log)
 	if err != nil {
 		return err
@@ -86,7 +91,7 @@ func (v *Provider) SendNotification(ctx context.Context, data *message.Notificat
 
 	providerConfig, err := v.GetProviderConfig(ctx, data, v.Log)
 	if err != nil {
-		return err
+		return provider.NewPermanentError(err)
 	}
 
 	heading, err := v.notification.Properties["heading"].GetValue(ctx, v.Log, data)
@@ -95,7 +100,7 @@ func (v *Provider) SendNotification(ctx context.Context, data *message.Notificat
 	}
 	renderedHeading, err := template.RenderTemplateValues(ctx, heading, fmt.Sprintf("%s_%s/heading", data.ID, v.providerName), data.AsMap(), []string{}, templateConfig)
 	if err != nil {
-		return err
+		return provider.NewPermanentError(err)
 	}
 
 	body, err := v.notification.Properties["body"].GetValue(ctx, v.Log, data)
@@ -104,48 +109,58 @@ func (v *Provider) SendNotification(ctx context.Context, data *message.Notificat
 	}
 	renderedBody, err := template.RenderTemplateValues(ctx, body, fmt.Sprintf("%s_%s/body", data.ID, v.providerName), data.AsMap(), []string{}, templateConfig)
 	if err != nil {
-		return err
+		return provider.NewPermanentError(err)
 	}
 
-	if providerConfig.RemoveExistingCommentsFromAllPullRequestCommits {
-		v.Log.Info("Cleaning up existing commit comments")
-		ghConfig.CleanExistingCommentsOnAllPullRequestCommits(string(renderedHeading))
-		// v.log.Info("Finished cleaning up existing comments on all commits of the pull request")
+	// the commit comment is not written again when only the pull request comment failed in a previous attempt
+	if provider.StepDone(ctx, commitCommentStep) {
+		v.Log.Info("github commit comment was created by a previous attempt, skipping it")
 	} else {
-		v.Log.Info("RemoveExistingCommentsFromAllPullRequestCommits was set to false, skipping the deletion of existing comments")
-	}
+		if providerConfig.RemoveExistingCommentsFromAllPullRequestCommits {
+			v.Log.Info("Cleaning up existing commit comments")
+			ghConfig.CleanExistingCommentsOnAllPullRequestCommits(string(renderedHeading))
+			// v.log.Info("Finished cleaning up existing comments on all commits of the pull request")
+		} else {
+			v.Log.Info("RemoveExistingCommentsFromAllPullRequestCommits was set to false, skipping the deletion of existing comments")
+		}
 
-	if ghConfig.PrNumber > 0 {
-		if providerConfig.RemoveExistingPullRequestComments {
-			v.Log.Info("Cleaning up existing pull request comments")
-			ghConfig.CleanExistingCommentsOnPullRequest(string(renderedHeading))
-			// v.log.Info("Finished cleaning up existing comments on the pull request")
+		if ghConfig.PrNumber > 0 {
+			if providerConfig.RemoveExistingPullRequestComments {
+				v.Log.Info("Cleaning up existing pull request comments")
+				ghConfig.CleanExistingCommentsOnPullRequest(string(renderedHeading))
+				// v.log.Info("Finished cleaning up existing comments on the pull request")
+			} else {
+				v.Log.Info("RemoveExistingPullRequestComments was set to false, skipping the deletion of existing comments")
+			}
 		} else {
-			v.Log.Info("RemoveExistingPullRequestComments was set to false, skipping the deletion of existing comments")
+			v.Log.Info("Pull request number was not greater than 0, skipping the deletion of existing comments")
 		}
-	} else {
-		v.Log.Info("Pull request number was not greater than 0, skipping the deletion of existing comments")
-	}
 
-	// Would normally generate the comment body here, but the body is not generated using templates
-	v.Log.Info("Creating commit comment")
-	newComment, err := ghConfig.WriteCommitComment(string(renderedBody), string(renderedHeading), providerConfig.RemoveDuplicateCommitComments)
-	if err != nil {
-		// v.log.Error("failed to write the github commit comment", zap.Error(err))
-		return fmt.Errorf("unable to write github commit comment. Error: %v", err)
-	}
+		// Would normally generate the comment body here, but the body is not generated using templates
+		v.Log.Info("Creating commit comment")
+		newComment, err := ghConfig.WriteCommitComment(string(renderedBody), string(renderedHeading), providerConfig.RemoveDuplicateCommitComments)
+		if err != nil {
+			// v.log.Error("failed to write the github commit comment", zap.Error(err))
+			return classifyApiError(fmt.Errorf("unable to write github commit comment. Error: %w", err))
+		}
 
-	v.Log = v.Log.With(zap.String("commitCommentUrl", newComment.GetHTMLURL()))
-	v.Log.Info("github commit comment has been created")
+		correlation.SetHandle(ctx, "githubCommitCommentId", fmt.Sprint(newComment.GetID()))
+		correlation.SetHandle(ctx, "githubCommitCommentUrl", newComment.GetHTMLURL())
+		v.Log = v.Log.With(zap.String("commitCommentUrl", newComment.GetHTMLURL()))
+		v.Log.Info("github commit comment has been created")
+		provider.CompleteStep(ctx, commitCommentStep)
+	}
 
 	if ghConfig.PrNumber > 0 {
 		v.Log.Info("Creating pull request comment")
 		newComment, err := ghConfig.WritePullRequestComment(string(renderedBody))
 		if err != nil {
 			// return githubToken, fmt.Errorf("unable to write github pull request comment. Error: %v", err)
-			return fmt.Errorf("unable to write github pull request comment. Error: %v", err)
+			return classifyApiError(fmt.Errorf("unable to write github pull request comment. Error: %w", err))
 		}
 		// r.EventEmitter.EmitMessage(ctx, &notification, zap.InfoLevel, "GithubComment", fmt.Sprintf("github pull request comment has been created here %s", *newComment.HTMLURL))
+		correlation.SetHandle(ctx, "githubPullRequestCommentId", fmt.Sprint(newComment.GetID()))
+		correlation.SetHandle(ctx, "githubPullRequestCommentUrl", newComment.GetHTMLURL())
 		v.Log = v.Log.With(zap.String("PrCommentUrl", newComment.GetHTMLURL()))
 		v.Log.Info("github pull request comment has been created")
 	} else {
@@ -155,6 +170,15 @@ func (v *Provider) SendNotification(ctx context.Context, data *message.Notificat
 	return nil
 }
 
+// classifyApiError marks github api errors as retryable or permanent based on the status code of the response
+func classifyApiError(err error) error {
+	var apiErr *github.ApiError
+	if errors.As(err, &apiErr) {
+		return provider.NewHttpStatusError(apiErr.StatusCode, err)
+	}
+	return err
+}
+
 func (v *Provider) GetProviderConfig(ctx context.Context, data *message.NotificationData, log *zap.Logger) (*ProviderConfig, error) {
 	planTaskName, err := v.notification.Properties["planTaskName"].GetValue(ctx, v.Log, data)
 	if err != nil {
@@ -203,7 +227,7 @@ func (v *Provider) GetServiceConfig(ctx context.Context, data *message.Notificat
 		return nil, err
 	}
 	if token == "" {
-		return nil, fmt.Errorf("the github token property was not supplied or was empty")
+		return nil, provider.NewPermanentError(fmt.Errorf("the github token property was not supplied or was empty"))
 	}
 
 	org, err := v.notification.Properties["org"].GetValue(ctx, v.Log, data)
@@ -211,7 +235,7 @@ func (v *Provider) GetServiceConfig(ctx context.Context, data *message.Notificat
 		return nil, err
 	}
 	if org == "" {
-		return nil, fmt.Errorf("the github org property was not supplied or was empty")
+		return nil, provider.NewPermanentError(fmt.Errorf("the github org property was not supplied or was empty"))
 	}
 
 	repo, err := v.notification.Properties["repo"].GetValue(ctx, v.Log, data)
@@ -219,7 +243,7 @@ func (v *Provider) GetServiceConfig(ctx context.Context, data *message.Notificat
 		return nil, err
 	}
 	if repo == "" {
-		return nil, fmt.Errorf("the github repo property was not supplied or was empty")
+		return nil, provider.NewPermanentError(fmt.Errorf("the github repo property was not supplied or was empty"))
 	}
 
 	commitSha, err := v.notification.Properties["commitSha"].GetValue(ctx, v.Log, data)
@@ -227,7 +251,7 @@ func (v *Provider) GetServiceConfig(ctx context.Context, data *message.Notificat
 		return nil, err
 	}
 	if commitSha == "" {
-		return nil, fmt.Errorf("the github commitSha property was not supplied or was empty")
+		return nil, provider.NewPermanentError(fmt.Errorf("the github commitSha property was not supplied or was empty"))
 	}
 
 	prNumberStr, err := v.notification.Properties["prNumber"].GetValue(ctx, v.Log, data)
@@ -235,13 +259,13 @@ func (v *Provider) GetServiceConfig(ctx context.Context, data *message.Notificat
 		return nil, err
 	}
 	if prNumberStr == "" {
-		return nil, fmt.Errorf("the github prNumber property was not supplied or was empty")
+		return nil, provider.NewPermanentError(fmt.Errorf("the github prNumber property was not supplied or was empty"))
 	}
 
 	prNumber, err := strconv.Atoi(prNumberStr)
 	if err != nil {
 
-		return nil, fmt.Errorf("failed to convert the supplied pr number '%v' to an integer. Error: %v", prNumberStr, err)
+		return nil, provider.NewPermanentError(fmt.Errorf("failed to convert the supplied pr number '%v' to an integer. Error: %v", prNumberStr, err))
 	}
 
 	isEnterprise := false
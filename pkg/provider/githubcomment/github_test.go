@@ -11,6 +11,7 @@ import (
 	"github.com/kcloutie/knot/pkg/config"
 	"github.com/kcloutie/knot/pkg/github"
 	"github.com/kcloutie/knot/pkg/message"
+	"github.com/kcloutie/knot/pkg/provider"
 	"go.uber.org/zap/zaptest"
 )
 
@@ -371,3 +372,113 @@ func TestProvider_SendNotification(t *testing.T) {
 		})
 	}
 }
+
+func TestProvider_SendNotification_Retryable(t *testing.T) {
+	properties := func(token config.PropertyAndValue, prNumber string) map[string]config.PropertyAndValue {
+		return map[string]config.PropertyAndValue{
+			"token":     token,
+			"org":       {Value: toPtrString("org")},
+			"repo":      {Value: toPtrString("repo")},
+			"commitSha": {Value: toPtrString("sha")},
+			"prNumber":  {Value: toPtrString(prNumber)},
+			"heading":   {Value: toPtrString("heading")},
+			"body":      {Value: toPtrString("body")},
+		}
+	}
+	tests := []struct {
+		name          string
+		properties    map[string]config.PropertyAndValue
+		wantRetryable bool
+	}{
+		{
+			name:          "token fetch error",
+			properties:    properties(config.PropertyAndValue{FromFile: toPtrString(t.TempDir() + "/missing")}, "1"),
+			wantRetryable: true,
+		},
+		{
+			name:       "empty token",
+			properties: properties(config.PropertyAndValue{Value: toPtrString("")}, "1"),
+		},
+		{
+			name:       "invalid pr number",
+			properties: properties(config.PropertyAndValue{Value: toPtrString("token")}, "one"),
+		},
+		{
+			name: "missing property",
+			properties: map[string]config.PropertyAndValue{
+				"heading": {Value: toPtrString("heading")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			v.SetLogger(zaptest.NewLogger(t))
+			v.SetNotification(config.Notification{Properties: tt.properties})
+
+			err := v.SendNotification(context.Background(), &message.NotificationData{Data: map[string]interface{}{}})
+			if err == nil {
+				t.Fatalf("Provider.SendNotification() expected an error")
+			}
+			if provider.IsRetryable(err) != tt.wantRetryable {
+				t.Errorf("Provider.SendNotification() retryable = %v, want %v, error = %v", provider.IsRetryable(err), tt.wantRetryable, err)
+			}
+		})
+	}
+}
+
+func TestProvider_SendNotification_RetryPullRequestComment(t *testing.T) {
+	commitComments := 0
+	pullRequestComments := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "GET":
+			rw.Write([]byte(`[]`))
+		case req.URL.Path == "/api/v3/repos/org/repo/commits/sha/comments":
+			commitComments++
+			rw.Write([]byte(`{"id":1,"html_url":"url","body":"heading\nbody"}`))
+		case req.URL.Path == "/api/v3/repos/org/repo/issues/1/comments":
+			pullRequestComments++
+			if pullRequestComments == 1 {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			rw.Write([]byte(`{"id":2,"html_url":"url","body":"heading\nbody"}`))
+		}
+	}))
+	defer server.Close()
+	notification := config.Notification{
+		Properties: map[string]config.PropertyAndValue{
+			"token":         {Value: toPtrString("token")},
+			"org":           {Value: toPtrString("org")},
+			"repo":          {Value: toPtrString("repo")},
+			"commitSha":     {Value: toPtrString("sha")},
+			"prNumber":      {Value: toPtrString("1")},
+			"enterpriseUrl": {Value: toPtrString(server.URL)},
+			"heading":       {Value: toPtrString("heading")},
+			"body":          {Value: toPtrString("body")},
+		},
+	}
+	ctx := provider.WithSteps(context.Background())
+	data := &message.NotificationData{Data: map[string]interface{}{}}
+
+	// each attempt uses a new provider like the dispatcher does
+	for attempt := 1; attempt <= 2; attempt++ {
+		v := New()
+		v.SetLogger(zaptest.NewLogger(t))
+		v.SetNotification(notification)
+		err := v.SendNotification(ctx, data)
+		if attempt == 1 && !provider.IsRetryable(err) {
+			t.Fatalf("Provider.SendNotification() expected a retryable error, got %v", err)
+		}
+		if attempt == 2 && err != nil {
+			t.Fatalf("Provider.SendNotification() error = %v", err)
+		}
+	}
+	if commitComments != 1 {
+		t.Errorf("commit comments = %v, want 1", commitComments)
+	}
+	if pullRequestComments != 2 {
+		t.Errorf("pull request comments = %v, want 2", pullRequestComments)
+	}
+}
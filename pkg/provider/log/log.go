@@ -45,11 +45,11 @@ func (v *Provider) SendNotification(ctx context.Context, data *message.Notificat
 	logger := v.log.Sugar()
 	_, err := provider.HasRequiredProperties(v.notification.Properties, v.GetRequiredPropertyNames())
 	if err != nil {
-		return err
+		return provider.NewPermanentError(err)
 	}
 	message, err := v.notification.Properties["message"].GetValue(ctx, v.log, data)
 	if err != nil {
-		return err
+		return provider.NewPermanentError(err)
 	}
 
 	templateConfig := template.NewRenderTemplateOptions()
@@ -57,7 +57,7 @@ func (v *Provider) SendNotification(ctx context.Context, data *message.Notificat
 
 	renderedMessage, err := template.RenderTemplateValues(ctx, message, fmt.Sprintf("%s_%s", data.ID, v.providerName), data.AsMap(), []string{}, templateConfig)
 	if err != nil {
-		return err
+		return provider.NewPermanentError(err)
 	}
 
 	logger.Info(string(renderedMessage))
@@ -0,0 +1,38 @@
+package provider
+
+import (
+	"context"
+	"sync"
+)
+
+type ctxStepsKey struct{}
+
+type steps struct {
+	mutex sync.Mutex
+	done  map[string]bool
+}
+
+// WithSteps returns a context in which the providers record the steps of a notification that succeeded, so the
+// attempts that follow a retryable failure do not repeat them
+func WithSteps(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxStepsKey{}, &steps{done: map[string]bool{}})
+}
+
+// CompleteStep records that the step succeeded. It does nothing when the context does not record the steps
+func CompleteStep(ctx context.Context, name string) {
+	if s, ok := ctx.Value(ctxStepsKey{}).(*steps); ok {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.done[name] = true
+	}
+}
+
+// StepDone returns true when the step succeeded in a previous attempt of the notification
+func StepDone(ctx context.Context, name string) bool {
+	if s, ok := ctx.Value(ctxStepsKey{}).(*steps); ok {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.done[name]
+	}
+	return false
+}
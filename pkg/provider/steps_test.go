@@ -0,0 +1,27 @@
+package provider
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSteps(t *testing.T) {
+	ctx := WithSteps(context.Background())
+	if StepDone(ctx, "comment") {
+		t.Errorf("StepDone() = true before the step is completed")
+	}
+	CompleteStep(ctx, "comment")
+	if !StepDone(ctx, "comment") {
+		t.Errorf("StepDone() = false after the step is completed")
+	}
+	if StepDone(ctx, "other") {
+		t.Errorf("StepDone() = true for another step")
+	}
+
+	// the steps are not recorded without WithSteps
+	ctx = context.Background()
+	CompleteStep(ctx, "comment")
+	if StepDone(ctx, "comment") {
+		t.Errorf("StepDone() = true without WithSteps")
+	}
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+// Counters is how many messages of a notification were allowed or throttled by the rate limit
+type Counters struct {
+	Allowed    int64 `json:"allowed" yaml:"allowed"`
+	Dropped    int64 `json:"dropped" yaml:"dropped"`
+	Delayed    int64 `json:"delayed" yaml:"delayed"`
+	Suppressed int64 `json:"suppressed" yaml:"suppressed"`
+	Summaries  int64 `json:"summaries" yaml:"summaries"`
+}
+
+// sweepInterval is how often the buckets that are full are removed. A full bucket is the same as a new bucket so the
+// buckets of the keys that are no longer used do not accumulate
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  float64
+	rate   float64
+}
+
+// isFull returns true when the bucket has refilled by the time
+func (b *bucket) isFull(now time.Time) bool {
+	return b.tokens+float64(now.Sub(b.last))*b.rate >= b.limit
+}
+
+// summary holds the messages suppressed for a key until the summary is sent
+type summary struct {
+	count int
+	last  *message.NotificationData
+}
+
+// SummaryFunc is called once the bucket has room again with the number of suppressed messages and the last one
+type SummaryFunc func(count int, last *message.NotificationData)
+
+// Limiter is a set of token buckets, one per notification and key
+type Limiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	summaries map[string]*summary
+	counters  map[string]*Counters
+	lastSweep time.Time
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func())
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets:   map[string]*bucket{},
+		summaries: map[string]*summary{},
+		counters:  map[string]*Counters{},
+		now:       time.Now,
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+// Reserve takes a token from the bucket of the key. When the bucket is empty, the token is only taken when it will be
+// available within maxWait. It returns whether the token was taken and how long to wait before it can be used
+func (l *Limiter) Reserve(key string, limit int, period time.Duration, maxWait time.Duration) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+	rate := float64(limit) / float64(period)
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit), last: now}
+		l.buckets[key] = b
+	}
+	b.limit = float64(limit)
+	b.rate = rate
+	b.tokens += float64(now.Sub(b.last)) * rate
+	if b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate)
+	if wait > maxWait {
+		return false, wait
+	}
+	b.tokens--
+	return true, wait
+}
+
+// sweep removes the buckets that are full, at most once per sweep interval. The lock must be held
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.isFull(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Cancel gives back the token taken by Reserve when the message is not sent, such as when a delayed message is
+// cancelled
+func (l *Limiter) Cancel(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if b, exists := l.buckets[key]; exists {
+		b.tokens++
+	}
+}
+
+// Suppress records a message that was over the limit. The summary function is called once for all the messages
+// suppressed for the key, after the wait
+func (l *Limiter) Suppress(key string, data *message.NotificationData, wait time.Duration, send SummaryFunc) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	s, exists := l.summaries[key]
+	if exists {
+		s.count++
+		s.last = data
+		return
+	}
+	l.summaries[key] = &summary{count: 1, last: data}
+	l.afterFunc(wait, func() {
+		l.mutex.Lock()
+		s := l.summaries[key]
+		delete(l.summaries, key)
+		l.mutex.Unlock()
+		send(s.count, s.last)
+	})
+}
+
+// Count increments the counter of the notification using the update function
+func (l *Limiter) Count(notification string, update func(c *Counters)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	c, exists := l.counters[notification]
+	if !exists {
+		c = &Counters{}
+		l.counters[notification] = c
+	}
+	update(c)
+}
+
+// Stats returns a copy of the counters of every notification
+func (l *Limiter) Stats() map[string]Counters {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	results := map[string]Counters{}
+	for name, c := range l.counters {
+		results[name] = *c
+	}
+	return results
+}
+
+type ctxLimiterKey struct{}
+
+// FromCtx returns the limiter stored in the context or nil when no notification is rate limited
+func FromCtx(ctx context.Context) *Limiter {
+	if l, ok := ctx.Value(ctxLimiterKey{}).(*Limiter); ok {
+		return l
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, l *Limiter) context.Context {
+	return context.WithValue(ctx, ctxLimiterKey{}, l)
+}
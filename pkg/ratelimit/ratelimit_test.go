@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+func TestLimiter_Reserve(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter()
+	limiter.now = func() time.Time {
+		return now
+	}
+
+	steps := []struct {
+		name        string
+		advance     time.Duration
+		key         string
+		maxWait     time.Duration
+		wantAllowed bool
+		wantWait    time.Duration
+		cancel      bool
+	}{
+		{name: "first token", key: "a", wantAllowed: true},
+		{name: "second token", key: "a", wantAllowed: true},
+		{name: "bucket empty", key: "a", wantAllowed: false, wantWait: 30 * time.Second},
+		{name: "other key has its own bucket", key: "b", wantAllowed: true},
+		{name: "refilled", advance: 30 * time.Second, key: "a", wantAllowed: true},
+		{name: "delayed", key: "a", maxWait: time.Minute, wantAllowed: true, wantWait: 30 * time.Second},
+		{name: "delayed tokens are taken", key: "a", maxWait: time.Second, wantAllowed: false, wantWait: time.Minute},
+		{name: "cancelled tokens are given back", key: "a", maxWait: time.Minute, wantAllowed: true, wantWait: time.Minute, cancel: true},
+		{name: "after cancel", key: "a", maxWait: time.Minute, wantAllowed: true, wantWait: time.Minute},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		allowed, wait := limiter.Reserve(step.key, 2, time.Minute, step.maxWait)
+		if step.cancel {
+			limiter.Cancel(step.key)
+		}
+		if allowed != step.wantAllowed || wait != step.wantWait {
+			t.Errorf("%s: Limiter.Reserve() = %v, %v, want %v, %v", step.name, allowed, wait, step.wantAllowed, step.wantWait)
+		}
+	}
+}
+
+func TestLimiter_RemovesFullBuckets(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter()
+	limiter.now = func() time.Time {
+		return now
+	}
+
+	limiter.Reserve("idle", 2, time.Minute, 0)
+	limiter.Reserve("busy", 1, time.Hour, 0)
+	now = now.Add(2 * time.Minute)
+	limiter.Reserve("new", 2, time.Minute, 0)
+	if _, exists := limiter.buckets["idle"]; exists {
+		t.Errorf("Limiter.Reserve() did not remove the full bucket")
+	}
+	if _, exists := limiter.buckets["busy"]; !exists {
+		t.Errorf("Limiter.Reserve() removed the bucket that is not full")
+	}
+	// the removed bucket is created full again
+	allowed, _ := limiter.Reserve("idle", 2, time.Minute, 0)
+	if !allowed {
+		t.Errorf("Limiter.Reserve() = %v, want allowed", allowed)
+	}
+	// the busy bucket still has no token
+	allowed, _ = limiter.Reserve("busy", 1, time.Hour, 0)
+	if allowed {
+		t.Errorf("Limiter.Reserve() = %v, want not allowed", allowed)
+	}
+}
+
+func TestLimiter_Suppress(t *testing.T) {
+	limiter := NewLimiter()
+	scheduled := []func(){}
+	limiter.afterFunc = func(d time.Duration, f func()) {
+		scheduled = append(scheduled, f)
+	}
+
+	gotCount := 0
+	var gotLast *message.NotificationData
+	send := func(count int, last *message.NotificationData) {
+		gotCount = count
+		gotLast = last
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		limiter.Suppress("a", &message.NotificationData{ID: id}, time.Second, send)
+	}
+	if len(scheduled) != 1 {
+		t.Fatalf("Limiter.Suppress() scheduled %v summaries, want 1", len(scheduled))
+	}
+	scheduled[0]()
+	if gotCount != 3 || gotLast.ID != "3" {
+		t.Errorf("Limiter.Suppress() summary = %v, %v, want 3 messages and the last one", gotCount, gotLast.ID)
+	}
+
+	limiter.Suppress("a", &message.NotificationData{ID: "4"}, time.Second, send)
+	if len(scheduled) != 2 {
+		t.Errorf("Limiter.Suppress() should schedule a new summary after the previous one was sent")
+	}
+}
@@ -0,0 +1,82 @@
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Policy determines how many times and how often a failing operation is attempted
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction (0 to 1) of the backoff that is randomized
+	Jitter float64
+	// Deadline is the total time allowed for all attempts. 0 means there is no deadline
+	Deadline time.Duration
+}
+
+// sleep waits for the duration or until the context is done. It is a variable so tests do not have to wait
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable, the maximum number of attempts is reached or
+// the deadline passes. The number of attempts and the last error are returned
+func Do(ctx context.Context, policy Policy, isRetryable func(error) bool, fn func(ctx context.Context) error) (int, error) {
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Deadline)
+		defer cancel()
+	}
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	attempt := 0
+	for attempt < maxAttempts {
+		attempt++
+		err = fn(ctx)
+		if err == nil || attempt == maxAttempts || !isRetryable(err) {
+			return attempt, err
+		}
+		backoff := Backoff(policy, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return attempt, err
+		}
+		if sleep(ctx, backoff) != nil {
+			return attempt, err
+		}
+	}
+	return attempt, err
+}
+
+// Backoff returns the time to wait after the given attempt. The backoff doubles after each attempt up to the maximum
+// backoff and the jitter is applied to the result
+func Backoff(policy Policy, attempt int) time.Duration {
+	backoff := policy.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff >= policy.MaxBackoff {
+			break
+		}
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 && backoff > 0 {
+		delta := float64(backoff) * policy.Jitter
+		backoff = time.Duration(float64(backoff) - delta + rand.Float64()*2*delta)
+	}
+	return backoff
+}
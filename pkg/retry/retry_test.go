@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var errRetryable = fmt.Errorf("retryable")
+var errPermanent = fmt.Errorf("permanent")
+
+func TestDo(t *testing.T) {
+	sleep = func(ctx context.Context, d time.Duration) error {
+		return nil
+	}
+	isRetryable := func(err error) bool {
+		return err == errRetryable
+	}
+	policy := Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	tests := []struct {
+		name         string
+		policy       Policy
+		errors       []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "succeeds first time",
+			policy:       policy,
+			errors:       []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "succeeds after retries",
+			policy:       policy,
+			errors:       []error{errRetryable, errRetryable, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max attempts",
+			policy:       policy,
+			errors:       []error{errRetryable, errRetryable, errRetryable, nil},
+			wantAttempts: 3,
+			wantErr:      errRetryable,
+		},
+		{
+			name:         "permanent errors are not retried",
+			policy:       policy,
+			errors:       []error{errPermanent, nil},
+			wantAttempts: 1,
+			wantErr:      errPermanent,
+		},
+		{
+			name:         "no policy attempts once",
+			policy:       Policy{},
+			errors:       []error{errRetryable, nil},
+			wantAttempts: 1,
+			wantErr:      errRetryable,
+		},
+		{
+			name:         "backoff exceeding the deadline stops retries",
+			policy:       Policy{MaxAttempts: 3, InitialBackoff: time.Minute, Deadline: time.Second},
+			errors:       []error{errRetryable, nil},
+			wantAttempts: 1,
+			wantErr:      errRetryable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			attempts, err := Do(context.Background(), tt.policy, isRetryable, func(ctx context.Context) error {
+				calls++
+				return tt.errors[calls-1]
+			})
+			if attempts != tt.wantAttempts || calls != tt.wantAttempts {
+				t.Errorf("Do() attempts = %v, calls = %v, want %v", attempts, calls, tt.wantAttempts)
+			}
+			if err != tt.wantErr {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 4, want: 5 * time.Second},
+		{attempt: 40, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %v", tt.attempt), func(t *testing.T) {
+			if got := Backoff(policy, tt.attempt); got != tt.want {
+				t.Errorf("Backoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		got := Backoff(policy, 1)
+		if got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Errorf("Backoff() with jitter = %v, want between 500ms and 1.5s", got)
+		}
+	}
+}
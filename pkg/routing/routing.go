@@ -0,0 +1,123 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/matcher"
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+// Selection is a receiver selected by the routing tree for a message
+type Selection struct {
+	// Receiver is the name of the notification
+	Receiver string
+	// Route is the path of the route that selected the receiver
+	Route string
+	// Properties are the properties inherited from the routes
+	Properties map[string]config.PropertyAndValue
+}
+
+// RouteError is returned when the matcher of a route could not be evaluated. The route is considered as not matching
+type RouteError struct {
+	Route string
+	Err   error
+}
+
+func (e RouteError) Error() string {
+	return fmt.Sprintf("failed to evaluate the matcher of route '%s' - %v", e.Route, e.Err)
+}
+
+// Match walks the routing tree and returns the receivers selected for the message. A receiver selected by more than
+// one route is only returned once, for the first route that selected it
+func Match(ctx context.Context, root *config.Route, data *message.NotificationData) ([]Selection, []RouteError) {
+	selections, _, errs := match(ctx, *root, getRouteName(*root, "", 0), map[string]config.PropertyAndValue{}, data)
+	seen := map[string]bool{}
+	results := []Selection{}
+	for _, selection := range selections {
+		if seen[selection.Receiver] {
+			continue
+		}
+		seen[selection.Receiver] = true
+		results = append(results, selection)
+	}
+	return results, errs
+}
+
+func match(ctx context.Context, route config.Route, path string, inherited map[string]config.PropertyAndValue, data *message.NotificationData) ([]Selection, bool, []RouteError) {
+	errs := []RouteError{}
+	matches, err := matcher.MatchesExpression(ctx, route.Matcher, data)
+	if err != nil {
+		return nil, false, append(errs, RouteError{Route: path, Err: err})
+	}
+	if !matches {
+		return nil, false, errs
+	}
+
+	properties := map[string]config.PropertyAndValue{}
+	for name, value := range inherited {
+		properties[name] = value
+	}
+	for name, value := range route.Properties {
+		properties[name] = value
+	}
+
+	selections := []Selection{}
+	childMatched := false
+	for i, child := range route.Routes {
+		childSelections, childMatches, childErrs := match(ctx, child, getRouteName(child, path, i), properties, data)
+		errs = append(errs, childErrs...)
+		if !childMatches {
+			continue
+		}
+		childMatched = true
+		selections = append(selections, childSelections...)
+		if !child.Continue {
+			break
+		}
+	}
+	if !childMatched {
+		for _, receiver := range route.Receivers {
+			selections = append(selections, Selection{
+				Receiver:   receiver,
+				Route:      path,
+				Properties: properties,
+			})
+		}
+	}
+	return selections, true, errs
+}
+
+// getRouteName returns the path of the route using its name, or its index when it does not have a name
+func getRouteName(route config.Route, parent string, index int) string {
+	name := route.Name
+	if name == "" {
+		if parent == "" {
+			name = "root"
+		} else {
+			name = fmt.Sprint(index)
+		}
+	}
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+// Apply returns a copy of the notification using the properties of the selection. The properties of the selection
+// override the properties of the notification
+func Apply(not config.Notification, selection Selection) config.Notification {
+	if len(selection.Properties) == 0 {
+		return not
+	}
+	properties := map[string]config.PropertyAndValue{}
+	for name, value := range not.Properties {
+		properties[name] = value
+	}
+	for name, value := range selection.Properties {
+		properties[name] = value
+	}
+	not.Properties = properties
+	return not
+}
@@ -0,0 +1,131 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+func TestMatch(t *testing.T) {
+	teamAChannel := "#team-a"
+	defaultChannel := "#alerts"
+	root := &config.Route{
+		Receivers:  []string{"catch-all"},
+		Properties: map[string]config.PropertyAndValue{"channel": {Value: &defaultChannel}},
+		Routes: []config.Route{
+			{
+				Name:     "audit",
+				Matcher:  "data.severity == 'critical'",
+				Continue: true,
+				Receivers: []string{
+					"audit-log",
+				},
+			},
+			{
+				Name:       "team-a",
+				Matcher:    "data.team == 'a'",
+				Receivers:  []string{"slack"},
+				Properties: map[string]config.PropertyAndValue{"channel": {Value: &teamAChannel}},
+				Routes: []config.Route{
+					{
+						Matcher:   "data.severity == 'critical'",
+						Receivers: []string{"pager", "slack"},
+					},
+				},
+			},
+			{
+				Name:      "team-b",
+				Matcher:   "data.team == 'b'",
+				Receivers: []string{"github"},
+			},
+			{
+				Name:      "broken",
+				Matcher:   "data.team == 'c' && data.missing.property",
+				Receivers: []string{"github"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		data         map[string]interface{}
+		wantRoutes   []string
+		wantChannels []string
+		wantErrs     int
+	}{
+		{
+			name:         "team a",
+			data:         map[string]interface{}{"team": "a", "severity": "info"},
+			wantRoutes:   []string{"root/team-a:slack"},
+			wantChannels: []string{teamAChannel},
+		},
+		{
+			name:         "team a critical continues after the audit route",
+			data:         map[string]interface{}{"team": "a", "severity": "critical"},
+			wantRoutes:   []string{"root/audit:audit-log", "root/team-a/0:pager", "root/team-a/0:slack"},
+			wantChannels: []string{defaultChannel, teamAChannel, teamAChannel},
+		},
+		{
+			name:         "team b",
+			data:         map[string]interface{}{"team": "b", "severity": "info"},
+			wantRoutes:   []string{"root/team-b:github"},
+			wantChannels: []string{defaultChannel},
+		},
+		{
+			name:         "nothing matches uses the default receiver",
+			data:         map[string]interface{}{"team": "d", "severity": "info"},
+			wantRoutes:   []string{"root:catch-all"},
+			wantChannels: []string{defaultChannel},
+		},
+		{
+			name:         "matcher errors do not match",
+			data:         map[string]interface{}{"team": "c", "severity": "info"},
+			wantRoutes:   []string{"root:catch-all"},
+			wantChannels: []string{defaultChannel},
+			wantErrs:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selections, errs := Match(context.Background(), root, &message.NotificationData{ID: "1", Data: tt.data, Attributes: map[string]string{}})
+			if len(errs) != tt.wantErrs {
+				t.Errorf("Match() errors = %v, want %v errors", errs, tt.wantErrs)
+			}
+			gotRoutes := []string{}
+			gotChannels := []string{}
+			for _, selection := range selections {
+				gotRoutes = append(gotRoutes, selection.Route+":"+selection.Receiver)
+				gotChannels = append(gotChannels, *selection.Properties["channel"].Value)
+			}
+			if !reflect.DeepEqual(gotRoutes, tt.wantRoutes) {
+				t.Errorf("Match() routes = %v, want %v", gotRoutes, tt.wantRoutes)
+			}
+			if !reflect.DeepEqual(gotChannels, tt.wantChannels) {
+				t.Errorf("Match() channels = %v, want %v", gotChannels, tt.wantChannels)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	notChannel := "#not"
+	routeChannel := "#route"
+	token := "token"
+	not := config.Notification{
+		Name: "slack",
+		Properties: map[string]config.PropertyAndValue{
+			"channel": {Value: &notChannel},
+			"token":   {Value: &token},
+		},
+	}
+	got := Apply(not, Selection{Properties: map[string]config.PropertyAndValue{"channel": {Value: &routeChannel}}})
+	if *got.Properties["channel"].Value != routeChannel || *got.Properties["token"].Value != token {
+		t.Errorf("Apply() properties = %v", got.Properties)
+	}
+	if *not.Properties["channel"].Value != notChannel {
+		t.Errorf("Apply() should not modify the notification")
+	}
+}
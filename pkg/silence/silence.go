@@ -0,0 +1,354 @@
+package silence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	lcel "github.com/kcloutie/knot/pkg/cel"
+	"github.com/kcloutie/knot/pkg/clock"
+	"github.com/kcloutie/knot/pkg/config"
+	"github.com/kcloutie/knot/pkg/logger"
+	"github.com/kcloutie/knot/pkg/message"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	StateActive  = "active"
+	StatePending = "pending"
+	StateExpired = "expired"
+)
+
+// Silence mutes the notifications matching the CEL matcher between the start and end time
+type Silence struct {
+	Id string `json:"id" yaml:"id"`
+	// Matcher is a CEL expression evaluated against the message. The name of the notification is available in the
+	// notification variable
+	Matcher   string    `json:"matcher" yaml:"matcher"`
+	StartsAt  time.Time `json:"startsAt" yaml:"startsAt"`
+	EndsAt    time.Time `json:"endsAt" yaml:"endsAt"`
+	CreatedBy string    `json:"createdBy,omitempty" yaml:"createdBy,omitempty"`
+	Comment   string    `json:"comment,omitempty" yaml:"comment,omitempty"`
+	CreatedAt time.Time `json:"createdAt" yaml:"createdAt"`
+}
+
+// GetCelDecl returns the declarations available to the silence matchers, the message declarations and the name of
+// the notification
+func GetCelDecl() []cel.EnvOption {
+	return []cel.EnvOption{
+		message.GetCelDecl(),
+		cel.Declarations(decls.NewVar("notification", decls.String)),
+	}
+}
+
+// celCacheLimit bounds the number of cached silence matchers as silences are created at runtime
+const celCacheLimit = 256
+
+var (
+	celEnv     *lcel.Environment
+	celEnvOnce sync.Once
+)
+
+// celEnvironment returns the environment compiling the silence matchers, the programs of the most recent matchers are
+// cached
+func celEnvironment() *lcel.Environment {
+	celEnvOnce.Do(func() {
+		env, err := lcel.NewBoundedEnvironment(celCacheLimit, GetCelDecl()...)
+		if err != nil {
+			panic(fmt.Sprintf("invalid silence CEL declarations - %v", err))
+		}
+		celEnv = env
+	})
+	return celEnv
+}
+
+// State returns whether the silence is pending, active or expired at the given time
+func (s Silence) State(now time.Time) string {
+	if now.Before(s.StartsAt) {
+		return StatePending
+	}
+	if now.Before(s.EndsAt) {
+		return StateActive
+	}
+	return StateExpired
+}
+
+// Validate returns an error when the silence can not be used
+func (s Silence) Validate() error {
+	if s.Matcher == "" {
+		return fmt.Errorf("the silence matcher was not specified")
+	}
+	if s.EndsAt.IsZero() {
+		return fmt.Errorf("the silence end time was not specified")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return fmt.Errorf("the silence end time must be after the start time")
+	}
+	_, err := celEnvironment().Compile(s.Matcher)
+	if err != nil {
+		return fmt.Errorf("invalid silence matcher %#v - %v", s.Matcher, err)
+	}
+	return nil
+}
+
+// Matches returns true when the matcher of the silence matches the message sent to the notification
+func (s Silence) Matches(ctx context.Context, notification string, data *message.NotificationData) (bool, error) {
+	values := data.AsMap()
+	values["notification"] = notification
+	matches, err := celEnvironment().Evaluate(ctx, s.Matcher, values)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate silence '%s' - %v", s.Id, err)
+	}
+	return matches == types.True, nil
+}
+
+// New creates a silence with a new id. The silence starts now when the start time is zero
+func New(matcher string, startsAt time.Time, endsAt time.Time, createdBy string, comment string) Silence {
+	now := time.Now().UTC()
+	if startsAt.IsZero() {
+		startsAt = now
+	}
+	return Silence{
+		Id:        uuid.NewV4().String(),
+		Matcher:   matcher,
+		StartsAt:  startsAt.UTC(),
+		EndsAt:    endsAt.UTC(),
+		CreatedBy: createdBy,
+		Comment:   comment,
+		CreatedAt: now,
+	}
+}
+
+// GetEndTime returns the end time or, when it is zero, the start time plus the duration. A zero start time is now
+func GetEndTime(startsAt time.Time, endsAt time.Time, duration string) (time.Time, error) {
+	if !endsAt.IsZero() {
+		return endsAt, nil
+	}
+	if duration == "" {
+		return time.Time{}, fmt.Errorf("the end time or the duration of the silence must be specified")
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid silence duration '%s' - %v", duration, err)
+	}
+	if startsAt.IsZero() {
+		startsAt = time.Now()
+	}
+	return startsAt.Add(d), nil
+}
+
+// Store persists the silences
+type Store interface {
+	// Add validates and stores the silence
+	Add(ctx context.Context, silence Silence) error
+	// List returns all silences, including the expired ones, ordered by start time
+	List(ctx context.Context) ([]Silence, error)
+	// Get returns the silence or an error when the silence does not exist
+	Get(ctx context.Context, id string) (*Silence, error)
+	// Expire ends the silence now
+	Expire(ctx context.Context, id string) (*Silence, error)
+}
+
+// NewStore creates the store from the configuration
+func NewStore(cfg *config.SilenceConfiguration) (Store, error) {
+	if cfg.File == "" {
+		return nil, fmt.Errorf("the silence file was not specified")
+	}
+	return NewFileStore(cfg.File)
+}
+
+// FileStore stores the silences in a json file. The file is read again when it is modified so silences added by the
+// cli are used by a running server
+type FileStore struct {
+	File string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	silences []Silence
+	now      func() time.Time
+}
+
+func NewFileStore(file string) (*FileStore, error) {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the directory of the silence file '%s' - %v", file, err)
+	}
+	return &FileStore{
+		File: file,
+		now:  time.Now,
+	}, nil
+}
+
+// load reads the silences from the file when it was modified since it was last read. The lock must be held
+func (s *FileStore) load() error {
+	info, err := os.Stat(s.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.silences = []Silence{}
+			s.modTime = time.Time{}
+			return nil
+		}
+		return fmt.Errorf("failed to read the silence file '%s' - %v", s.File, err)
+	}
+	if s.silences != nil && info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(s.File)
+	if err != nil {
+		return fmt.Errorf("failed to read the silence file '%s' - %v", s.File, err)
+	}
+	silences := []Silence{}
+	err = json.Unmarshal(data, &silences)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal the silence file '%s' - %v", s.File, err)
+	}
+	s.silences = silences
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// save writes the silences to the file. The lock must be held
+func (s *FileStore) save(silences []Silence) error {
+	data, err := json.MarshalIndent(silences, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal the silences - %v", err)
+	}
+	// write to a temporary file first so a partially written file is never read
+	tmp := s.File + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write the silence file '%s' - %v", s.File, err)
+	}
+	err = os.Rename(tmp, s.File)
+	if err != nil {
+		return fmt.Errorf("failed to write the silence file '%s' - %v", s.File, err)
+	}
+	s.silences = silences
+	// force the next read to reload the file, the modification time resolution can be too coarse to detect changes
+	s.modTime = time.Time{}
+	return nil
+}
+
+func (s *FileStore) Add(ctx context.Context, silence Silence) error {
+	err := silence.Validate()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.load()
+	if err != nil {
+		return err
+	}
+	silences := []Silence{}
+	for _, existing := range s.silences {
+		if existing.Id != silence.Id {
+			silences = append(silences, existing)
+		}
+	}
+	return s.save(append(silences, silence))
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	silences := make([]Silence, len(s.silences))
+	copy(silences, s.silences)
+	sort.SliceStable(silences, func(i, j int) bool {
+		return silences[i].StartsAt.Before(silences[j].StartsAt)
+	})
+	return silences, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Silence, error) {
+	silences, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, silence := range silences {
+		if silence.Id == id {
+			return &silence, nil
+		}
+	}
+	return nil, fmt.Errorf("silence '%s' does not exist", id)
+}
+
+func (s *FileStore) Expire(ctx context.Context, id string) (*Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	silences := make([]Silence, len(s.silences))
+	copy(silences, s.silences)
+	for i, silence := range silences {
+		if silence.Id != id {
+			continue
+		}
+		now := s.now().UTC()
+		if silence.EndsAt.After(now) {
+			silences[i].EndsAt = now
+			if silence.StartsAt.After(now) {
+				silences[i].StartsAt = now
+			}
+			err = s.save(silences)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &silences[i], nil
+	}
+	return nil, fmt.Errorf("silence '%s' does not exist", id)
+}
+
+// Silenced returns the first active silence matching the message sent to the notification or nil when the
+// notification is not silenced. A silence whose matcher fails to evaluate, like a missing key, is logged and does not
+// match so it can not stop the delivery of the other messages
+func Silenced(ctx context.Context, store Store, notification string, data *message.NotificationData) (*Silence, error) {
+	silences, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := clock.Now(ctx)
+	for _, silence := range silences {
+		if silence.State(now) != StateActive {
+			continue
+		}
+		matches, err := silence.Matches(ctx, notification, data)
+		if err != nil {
+			logger.FromCtx(ctx).Warn(err.Error())
+			continue
+		}
+		if matches {
+			return &silence, nil
+		}
+	}
+	return nil, nil
+}
+
+type ctxStoreKey struct{}
+
+// FromCtx returns the silence store stored in the context or nil when silences are not enabled
+func FromCtx(ctx context.Context) Store {
+	if s, ok := ctx.Value(ctxStoreKey{}).(Store); ok {
+		return s
+	}
+	return nil
+}
+
+func WithCtx(ctx context.Context, s Store) context.Context {
+	return context.WithValue(ctx, ctxStoreKey{}, s)
+}
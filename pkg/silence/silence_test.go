@@ -0,0 +1,100 @@
+package silence
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kcloutie/knot/pkg/message"
+)
+
+func TestSilenced(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "silences.json"))
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	now := time.Now()
+	silences := []Silence{
+		{Id: "expired", Matcher: "true", StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)},
+		{Id: "pending", Matcher: "true", StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour)},
+		{Id: "narrow", Matcher: `data.repo == "x"`, StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour)},
+		{Id: "active", Matcher: `notification == "log" && attributes.project == "demo"`, StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour)},
+	}
+	for _, s := range silences {
+		err = store.Add(ctx, s)
+		if err != nil {
+			t.Fatalf("FileStore.Add() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		notification string
+		attributes   map[string]string
+		data         map[string]interface{}
+		want         string
+	}{
+		{
+			name:         "silenced",
+			notification: "log",
+			attributes:   map[string]string{"project": "demo"},
+			want:         "active",
+		},
+		{
+			name:         "other notification",
+			notification: "github",
+			attributes:   map[string]string{"project": "demo"},
+		},
+		{
+			name:         "other project",
+			notification: "log",
+			attributes:   map[string]string{"project": "other"},
+		},
+		{
+			name:         "matcher of another silence with a missing key",
+			notification: "github",
+			attributes:   map[string]string{"project": "other"},
+		},
+		{
+			name:         "narrow silence",
+			notification: "github",
+			attributes:   map[string]string{"project": "other"},
+			data:         map[string]interface{}{"repo": "x"},
+			want:         "narrow",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Silenced(ctx, store, tt.notification, &message.NotificationData{Attributes: tt.attributes, Data: tt.data})
+			if err != nil {
+				t.Fatalf("Silenced() error = %v", err)
+			}
+			if (got == nil && tt.want != "") || (got != nil && got.Id != tt.want) {
+				t.Errorf("Silenced() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	expired, err := store.Expire(ctx, "active")
+	if err != nil {
+		t.Fatalf("FileStore.Expire() error = %v", err)
+	}
+	if expired.State(time.Now()) != StateExpired {
+		t.Errorf("FileStore.Expire() = %v, want an expired silence", expired)
+	}
+	reloaded, err := NewFileStore(store.File)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	got, err := reloaded.Get(ctx, "active")
+	if err != nil || got.State(time.Now()) != StateExpired {
+		t.Errorf("the expired silence should be persisted, got %v, %v", got, err)
+	}
+
+	err = store.Add(ctx, Silence{Id: "invalid", Matcher: "data.", StartsAt: now, EndsAt: now.Add(time.Hour)})
+	if err == nil {
+		t.Errorf("FileStore.Add() of an invalid matcher should return an error")
+	}
+}
@@ -34,7 +34,7 @@ func TestProviderLog(t *testing.T) {
 	body, _ := io.ReadAll(response.Body)
 	// assert.NilError(t, fmt.Errorf("ttt"))
 	assert.Equal(t, response.StatusCode, 200)
-	assert.Equal(t, string(body), "")
+	assert.Assert(t, strings.HasPrefix(string(body), `{"results":[{"index":0,"id":"1"`), string(body))
 }
 
 func TestProviderLog2(t *testing.T) {
@@ -71,5 +71,5 @@ func TestProviderLog2(t *testing.T) {
 	body, _ := io.ReadAll(response.Body)
 	// assert.NilError(t, fmt.Errorf("ttt"))
 	assert.Equal(t, response.StatusCode, 200)
-	assert.Equal(t, string(body), "")
+	assert.Assert(t, strings.HasPrefix(string(body), `{"results":[{"index":0,"id":"1"`), string(body))
 }